@@ -23,6 +23,8 @@ const (
 	portableDir    = "supersonic_portable"
 	savedQueueFile = "saved_queue.json"
 	themesDir      = "themes"
+	certsDir       = "certs"
+	stateDir       = "state"
 )
 
 var (
@@ -122,6 +124,17 @@ func (a *App) ThemesDir() string {
 	return filepath.Join(a.storage.ConfigPath(), themesDir)
 }
 
+// CertsDir holds the local CA and the certificate of TLS listeners.
+func (a *App) CertsDir() string {
+	return filepath.Join(a.storage.ConfigPath(), certsDir)
+}
+
+// StateDir holds state that has to survive a crash, such as the journal
+// of network changes made by the TUN mode.
+func (a *App) StateDir() string {
+	return filepath.Join(a.storage.ConfigPath(), stateDir)
+}
+
 func checkPortablePath() string {
 	if p, err := os.Executable(); err == nil {
 		pdirPath := path.Join(filepath.Dir(p), portableDir)
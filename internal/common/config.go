@@ -3,9 +3,22 @@ package common
 import "xengate/internal/models"
 
 type Config struct {
-	Connections []*models.Connection    `json:"connections"`
-	AccessRules []*models.AccessRule    `json:"rules"`
-	BlockedList []*models.BlockedIPInfo `json:"blocked_list"`
+	Connections  []*models.Connection     `json:"connections"`
+	AccessRules  []*models.AccessRule     `json:"rules"`
+	BlockedList  []*models.BlockedIPInfo  `json:"blocked_list"`
+	RoutingRules []*models.RoutingRule    `json:"routing_rules,omitempty"`
+	DNS          *models.DNSConfig        `json:"dns,omitempty"`
+	Users        []*models.ProxyUser      `json:"users,omitempty"`
+	Listeners    []*models.ListenerConfig `json:"listeners,omitempty"`
+	Tun          *models.TunConfig        `json:"tun,omitempty"`
+	Sniffing     bool                     `json:"sniffing,omitempty"`
+
+	DestinationLists []*models.DestinationList `json:"destination_lists,omitempty"`
+	AutoBan          *models.AutoBanConfig     `json:"auto_ban,omitempty"`
+	AutoBanHistory   []*models.AutoBanRecord   `json:"auto_ban_history,omitempty"`
+	AllowlistOnly    bool                      `json:"allowlist_only,omitempty"`
+	Allowlist        []*models.AllowedClient   `json:"allowlist,omitempty"`
+	MaxConnections   int                       `json:"max_connections,omitempty"`
 }
 
 type ConfigManager interface {
@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	minCacheTTL      = 5 * time.Second
+	maxCacheTTL      = 24 * time.Hour
+	negativeCacheTTL = 30 * time.Second
+)
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+type cacheEntry struct {
+	rcode   dnsmessage.RCode
+	answers []dnsmessage.Resource
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache stores DNS answers keyed by name and type and honours the record
+// TTLs. Once full, expired entries are purged first and then the entries
+// closest to expiry.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	maxSize int
+	hits    int64
+	misses  int64
+}
+
+func NewCache(maxSize int) *Cache {
+	if maxSize <= 0 {
+		maxSize = 1024
+	}
+	return &Cache{
+		entries: make(map[cacheKey]*cacheEntry),
+		maxSize: maxSize,
+	}
+}
+
+func newCacheKey(name string, qtype dnsmessage.Type) cacheKey {
+	return cacheKey{
+		name:  strings.ToLower(strings.TrimSuffix(name, ".")),
+		qtype: qtype,
+	}
+}
+
+// Get returns the cached answers with their TTLs reduced by the time
+// spent in the cache.
+func (c *Cache) Get(name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, dnsmessage.RCode, bool) {
+	key := newCacheKey(name, qtype)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		if ok {
+			delete(c.entries, key)
+		}
+		c.misses++
+		return nil, 0, false
+	}
+	c.hits++
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	answers := make([]dnsmessage.Resource, len(entry.answers))
+	for i, rr := range entry.answers {
+		answers[i] = rr
+		if rr.Header.TTL > elapsed {
+			answers[i].Header.TTL = rr.Header.TTL - elapsed
+		} else {
+			answers[i].Header.TTL = 1
+		}
+	}
+	return answers, entry.rcode, true
+}
+
+// Put caches a response. Successful answers live for the smallest TTL in
+// the set, negative answers for a short fixed time.
+func (c *Cache) Put(name string, qtype dnsmessage.Type, rcode dnsmessage.RCode, answers []dnsmessage.Resource) {
+	ttl := negativeCacheTTL
+	if rcode == dnsmessage.RCodeSuccess && len(answers) > 0 {
+		ttl = maxCacheTTL
+		for _, rr := range answers {
+			if d := time.Duration(rr.Header.TTL) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+	} else if rcode != dnsmessage.RCodeSuccess && rcode != dnsmessage.RCodeNameError {
+		return // don't cache server failures
+	}
+	if ttl < minCacheTTL {
+		ttl = minCacheTTL
+	}
+
+	now := time.Now()
+	key := newCacheKey(name, qtype)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxSize {
+		c.evictLocked(now)
+	}
+
+	c.entries[key] = &cacheEntry{
+		rcode:   rcode,
+		answers: answers,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+}
+
+func (c *Cache) evictLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	for len(c.entries) >= c.maxSize {
+		var oldestKey cacheKey
+		var oldest time.Time
+		for key, entry := range c.entries {
+			if oldest.IsZero() || entry.expires.Before(oldest) {
+				oldest = entry.expires
+				oldestKey = key
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	c.entries = make(map[cacheKey]*cacheEntry)
+	c.mu.Unlock()
+}
+
+type CacheStats struct {
+	Size   int
+	Hits   int64
+	Misses int64
+}
+
+func (c *Cache) GetStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Size:   len(c.entries),
+		Hits:   c.hits,
+		Misses: c.misses,
+	}
+}
@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCacheTTL(t *testing.T) {
+	c := NewCache(8)
+	c.Put("Example.COM.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+		aResource("example.com", net.IPv4(192, 0, 2, 1), 300),
+		aResource("example.com", net.IPv4(192, 0, 2, 2), 120),
+	})
+
+	// The entry lives for the smallest TTL, and answers age with it.
+	entry := c.entries[newCacheKey("example.com", dnsmessage.TypeA)]
+	if ttl := entry.expires.Sub(entry.stored); ttl != 120*time.Second {
+		t.Errorf("entry lives for %s, want 2m0s", ttl)
+	}
+	entry.stored = entry.stored.Add(-100 * time.Second)
+
+	answers, rcode, ok := c.Get("example.com", dnsmessage.TypeA)
+	if !ok || rcode != dnsmessage.RCodeSuccess || len(answers) != 2 {
+		t.Fatalf("Get() = %d answers, %v, %v; want the cached answers", len(answers), rcode, ok)
+	}
+	if answers[0].Header.TTL != 200 || answers[1].Header.TTL != 20 {
+		t.Errorf("TTLs = %d, %d; want 200, 20", answers[0].Header.TTL, answers[1].Header.TTL)
+	}
+	if entry.answers[0].Header.TTL != 300 {
+		t.Error("Get() changed the cached answers")
+	}
+
+	entry.expires = time.Now().Add(-time.Second)
+	if _, _, ok := c.Get("example.com", dnsmessage.TypeA); ok {
+		t.Error("expired entry returned")
+	}
+	if stats := c.GetStats(); stats.Size != 0 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want an empty cache with one hit and one miss", stats)
+	}
+}
+
+func TestCacheRCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		rcode   dnsmessage.RCode
+		answers []dnsmessage.Resource
+		cached  bool
+		ttl     time.Duration
+	}{
+		{"answer", dnsmessage.RCodeSuccess, []dnsmessage.Resource{aResource("a.example", net.IPv4(192, 0, 2, 1), 1)}, true, minCacheTTL},
+		{"no data", dnsmessage.RCodeSuccess, nil, true, negativeCacheTTL},
+		{"nxdomain", dnsmessage.RCodeNameError, nil, true, negativeCacheTTL},
+		{"server failure", dnsmessage.RCodeServerFailure, nil, false, 0},
+		{"refused", dnsmessage.RCodeRefused, nil, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(8)
+			c.Put("a.example.", dnsmessage.TypeA, tt.rcode, tt.answers)
+			entry, cached := c.entries[newCacheKey("a.example", dnsmessage.TypeA)]
+			if cached != tt.cached {
+				t.Fatalf("cached = %v, want %v", cached, tt.cached)
+			}
+			if cached && entry.expires.Sub(entry.stored) != tt.ttl {
+				t.Errorf("entry lives for %s, want %s", entry.expires.Sub(entry.stored), tt.ttl)
+			}
+		})
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := NewCache(2)
+	answer := func(name string, ttl uint32) []dnsmessage.Resource {
+		return []dnsmessage.Resource{aResource(name, net.IPv4(192, 0, 2, 1), ttl)}
+	}
+	c.Put("short.example.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, answer("short.example", 60))
+	c.Put("long.example.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, answer("long.example", 3600))
+	c.Put("new.example.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, answer("new.example", 600))
+
+	for name, want := range map[string]bool{"short.example": false, "long.example": true, "new.example": true} {
+		if _, _, ok := c.Get(name, dnsmessage.TypeA); ok != want {
+			t.Errorf("%s cached = %v, want %v", name, ok, want)
+		}
+	}
+
+	c.Flush()
+	if stats := c.GetStats(); stats.Size != 0 {
+		t.Errorf("size after Flush() = %d", stats.Size)
+	}
+}
@@ -0,0 +1,312 @@
+package dns
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DefaultFakeIPRange = "198.18.0.0/15"
+	DefaultFakeIPSize  = 65536
+
+	// fakeIPTTL is kept short so clients come back before an address is
+	// handed to another name.
+	fakeIPTTL = 1
+
+	typeSVCB  dnsmessage.Type = 64
+	typeHTTPS dnsmessage.Type = 65
+)
+
+type fakeIPEntry struct {
+	Name   string
+	offset uint32
+}
+
+// FakeIPPool hands out addresses from a reserved IPv4 range in place of
+// the real addresses of names, and maps them back when a flow to one of
+// them arrives. It holds at most maxSize names; once full, the least
+// recently used name gives up its address.
+type FakeIPPool struct {
+	mu      sync.Mutex
+	network *net.IPNet
+	base    uint32
+	maxSize int
+	next    uint32
+	lru     *list.List // *fakeIPEntry, most recently used first
+	byName  map[string]*list.Element
+	byIP    map[uint32]*list.Element
+	changes uint64
+}
+
+// NewFakeIPPool creates a pool over cidr, DefaultFakeIPRange if empty.
+// maxSize is capped to the usable addresses of the range.
+func NewFakeIPPool(cidr string, maxSize int) (*FakeIPPool, error) {
+	if cidr == "" {
+		cidr = DefaultFakeIPRange
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || network.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid fake IP range %q", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones < 2 || bits-ones > 24 {
+		return nil, fmt.Errorf("fake IP range %s must be between /8 and /30", cidr)
+	}
+
+	// The network and broadcast addresses are never handed out.
+	usable := 1<<(bits-ones) - 2
+	if maxSize <= 0 {
+		maxSize = DefaultFakeIPSize
+	}
+	if maxSize > usable {
+		maxSize = usable
+	}
+
+	return &FakeIPPool{
+		network: network,
+		base:    binary.BigEndian.Uint32(network.IP.To4()),
+		maxSize: maxSize,
+		next:    1,
+		lru:     list.New(),
+		byName:  make(map[string]*list.Element),
+		byIP:    make(map[uint32]*list.Element),
+	}, nil
+}
+
+// Network returns the range of the pool.
+func (p *FakeIPPool) Network() *net.IPNet {
+	return p.network
+}
+
+// Contains reports whether ip is in the range of the pool.
+func (p *FakeIPPool) Contains(ip net.IP) bool {
+	return p.network.Contains(ip)
+}
+
+func (p *FakeIPPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Changes counts the allocations so far, so callers can tell whether the
+// pool needs saving.
+func (p *FakeIPPool) Changes() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changes
+}
+
+func (p *FakeIPPool) ip(offset uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, p.base+offset)
+	return ip
+}
+
+func (p *FakeIPPool) offset(ip net.IP) (uint32, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil || !p.network.Contains(ip4) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(ip4) - p.base, true
+}
+
+// Allocate returns the fake address of name, handing out a new one if it
+// has none.
+func (p *FakeIPPool) Allocate(name string) net.IP {
+	name = normalizeName(name)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if elem, ok := p.byName[name]; ok {
+		p.lru.MoveToFront(elem)
+		return p.ip(elem.Value.(*fakeIPEntry).offset)
+	}
+
+	var offset uint32
+	if p.lru.Len() < p.maxSize {
+		offset = p.freeOffset()
+	} else {
+		oldest := p.lru.Back()
+		entry := p.lru.Remove(oldest).(*fakeIPEntry)
+		delete(p.byName, entry.Name)
+		delete(p.byIP, entry.offset)
+		offset = entry.offset
+	}
+
+	p.insert(name, offset)
+	p.changes++
+	return p.ip(offset)
+}
+
+// freeOffset returns an unused offset. Offsets are handed out in order;
+// only a pool loaded with gaps has to search for one.
+func (p *FakeIPPool) freeOffset() uint32 {
+	if p.next <= uint32(p.maxSize) {
+		p.next++
+		return p.next - 1
+	}
+	for offset := uint32(1); ; offset++ {
+		if _, used := p.byIP[offset]; !used {
+			return offset
+		}
+	}
+}
+
+func (p *FakeIPPool) insert(name string, offset uint32) {
+	elem := p.lru.PushFront(&fakeIPEntry{Name: name, offset: offset})
+	p.byName[name] = elem
+	p.byIP[offset] = elem
+}
+
+// Lookup returns the name ip was handed out for.
+func (p *FakeIPPool) Lookup(ip net.IP) (string, bool) {
+	offset, ok := p.offset(ip)
+	if !ok {
+		return "", false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.byIP[offset]
+	if !ok {
+		return "", false
+	}
+	p.lru.MoveToFront(elem)
+	return elem.Value.(*fakeIPEntry).Name, true
+}
+
+// Answers synthesizes the answers for a question handled by the pool: a
+// fake address for A, no data for AAAA, SVCB and HTTPS so clients stay on
+// the fake address, and the name for PTR queries within the range. ok is
+// false for questions that have to be forwarded.
+func (p *FakeIPPool) Answers(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode, bool) {
+	switch q.Type {
+	case dnsmessage.TypeA:
+		ip := p.Allocate(q.Name.String())
+		return []dnsmessage.Resource{aResource(q.Name.String(), ip, fakeIPTTL)}, dnsmessage.RCodeSuccess, true
+	case dnsmessage.TypeAAAA, typeSVCB, typeHTTPS:
+		return nil, dnsmessage.RCodeSuccess, true
+	case dnsmessage.TypePTR:
+		ip := reverseIP(q.Name.String())
+		if ip == nil || !p.Contains(ip) {
+			return nil, 0, false
+		}
+		name, ok := p.Lookup(ip)
+		if !ok {
+			return nil, dnsmessage.RCodeNameError, true
+		}
+		ptr, err := dnsmessage.NewName(fqdn(name))
+		if err != nil {
+			return nil, dnsmessage.RCodeNameError, true
+		}
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+				TTL:   fakeIPTTL,
+			},
+			Body: &dnsmessage.PTRResource{PTR: ptr},
+		}}, dnsmessage.RCodeSuccess, true
+	}
+	return nil, 0, false
+}
+
+// reverseIP parses an in-addr.arpa name.
+func reverseIP(name string) net.IP {
+	name = normalizeName(name)
+	if !strings.HasSuffix(name, ".in-addr.arpa") {
+		return nil
+	}
+	labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+	if len(labels) != net.IPv4len {
+		return nil
+	}
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return net.ParseIP(strings.Join(labels, ".")).To4()
+}
+
+type fakeIPState struct {
+	Range   string            `json:"range"`
+	Entries []fakeIPStateItem `json:"entries"`
+}
+
+type fakeIPStateItem struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// Save writes the mappings to w, least recently used first.
+func (p *FakeIPPool) Save(w io.Writer) error {
+	p.mu.Lock()
+	state := fakeIPState{
+		Range:   p.network.String(),
+		Entries: make([]fakeIPStateItem, 0, p.lru.Len()),
+	}
+	for elem := p.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*fakeIPEntry)
+		state.Entries = append(state.Entries, fakeIPStateItem{
+			Name: entry.Name,
+			IP:   p.ip(entry.offset).String(),
+		})
+	}
+	p.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(&state)
+}
+
+// Load replaces the mappings with the ones saved by Save. State saved for
+// another range is ignored, as are entries beyond the size of the pool.
+func (p *FakeIPPool) Load(r io.Reader) error {
+	var state fakeIPState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("invalid fake IP state: %w", err)
+	}
+	if state.Range != p.network.String() {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lru.Init()
+	p.byName = make(map[string]*list.Element)
+	p.byIP = make(map[uint32]*list.Element)
+	p.next = 1
+
+	entries := state.Entries
+	if len(entries) > p.maxSize {
+		entries = entries[len(entries)-p.maxSize:]
+	}
+	for _, item := range entries {
+		name := normalizeName(item.Name)
+		offset, ok := p.offset(net.ParseIP(item.IP))
+		if name == "" || !ok || offset == 0 || offset > uint32(p.maxSize) {
+			continue
+		}
+		if _, dup := p.byName[name]; dup {
+			continue
+		}
+		if _, dup := p.byIP[offset]; dup {
+			continue
+		}
+		p.insert(name, offset)
+		if offset >= p.next {
+			p.next = offset + 1
+		}
+	}
+	return nil
+}
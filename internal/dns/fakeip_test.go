@@ -0,0 +1,196 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newTestFakeIPPool(t *testing.T, cidr string, size int) *FakeIPPool {
+	t.Helper()
+	p, err := NewFakeIPPool(cidr, size)
+	if err != nil {
+		t.Fatalf("NewFakeIPPool(%q): %v", cidr, err)
+	}
+	return p
+}
+
+func TestNewFakeIPPool(t *testing.T) {
+	for _, cidr := range []string{"bogus", "fd00::/64", "10.0.0.0/7", "10.0.0.0/31"} {
+		if _, err := NewFakeIPPool(cidr, 0); err == nil {
+			t.Errorf("NewFakeIPPool(%q) accepted the range", cidr)
+		}
+	}
+
+	p := newTestFakeIPPool(t, "", 0)
+	if p.Network().String() != DefaultFakeIPRange || p.maxSize != DefaultFakeIPSize {
+		t.Errorf("default pool = %s of %d, want %s of %d", p.Network(), p.maxSize, DefaultFakeIPRange, DefaultFakeIPSize)
+	}
+	if p := newTestFakeIPPool(t, "10.0.0.0/29", 100); p.maxSize != 6 {
+		t.Errorf("maxSize = %d, want the 6 usable addresses of a /29", p.maxSize)
+	}
+}
+
+func TestFakeIPPoolAllocate(t *testing.T) {
+	p := newTestFakeIPPool(t, "198.18.0.0/15", 0)
+
+	first := p.Allocate("Example.COM.")
+	if first.String() != "198.18.0.1" {
+		t.Errorf("first address = %s, want 198.18.0.1", first)
+	}
+	if again := p.Allocate("example.com"); !again.Equal(first) {
+		t.Errorf("same name got %s, want %s", again, first)
+	}
+	if second := p.Allocate("example.org"); second.String() != "198.18.0.2" {
+		t.Errorf("second address = %s, want 198.18.0.2", second)
+	}
+
+	if name, ok := p.Lookup(first); !ok || name != "example.com" {
+		t.Errorf("Lookup(%s) = %q, %v; want example.com", first, name, ok)
+	}
+	for _, ip := range []net.IP{net.IPv4(198, 18, 0, 3), net.IPv4(192, 0, 2, 1)} {
+		if name, ok := p.Lookup(ip); ok {
+			t.Errorf("Lookup(%s) = %q, want no name", ip, name)
+		}
+	}
+	if p.Len() != 2 || p.Changes() != 2 {
+		t.Errorf("Len(), Changes() = %d, %d; want 2, 2", p.Len(), p.Changes())
+	}
+}
+
+func TestFakeIPPoolLRU(t *testing.T) {
+	p := newTestFakeIPPool(t, "10.0.0.0/30", 0) // two usable addresses
+
+	a := p.Allocate("a.example")
+	b := p.Allocate("b.example")
+	p.Lookup(a) // a flow to a makes b the least recently used
+
+	if c := p.Allocate("c.example"); !c.Equal(b) {
+		t.Errorf("c.example got %s, want the address of b.example %s", c, b)
+	}
+	if name, _ := p.Lookup(b); name != "c.example" {
+		t.Errorf("Lookup(%s) = %q, want c.example", b, name)
+	}
+	if name, _ := p.Lookup(a); name != "a.example" {
+		t.Errorf("Lookup(%s) = %q, want a.example", a, name)
+	}
+	if p.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", p.Len())
+	}
+}
+
+func TestFakeIPPoolSaveLoad(t *testing.T) {
+	p := newTestFakeIPPool(t, "10.0.0.0/29", 3)
+	a := p.Allocate("a.example")
+	b := p.Allocate("b.example")
+	c := p.Allocate("c.example")
+	p.Lookup(a)
+
+	var saved bytes.Buffer
+	if err := p.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestFakeIPPool(t, "10.0.0.0/29", 3)
+	if err := loaded.Load(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for name, ip := range map[string]net.IP{"a.example": a, "b.example": b, "c.example": c} {
+		if got, _ := loaded.Lookup(ip); got != name {
+			t.Errorf("Lookup(%s) after Load = %q, want %s", ip, got, name)
+		}
+	}
+
+	// The order of use survives too: Lookup above used every entry in
+	// the order a, b, c, so a goes first.
+	if d := loaded.Allocate("d.example"); !d.Equal(a) {
+		t.Errorf("d.example got %s, want the address of a.example %s", d, a)
+	}
+
+	other := newTestFakeIPPool(t, "10.1.0.0/29", 3)
+	other.Allocate("x.example")
+	if err := other.Load(bytes.NewReader(saved.Bytes())); err != nil || other.Len() != 1 {
+		t.Errorf("Load() of another range = %v with %d entries, want it ignored", err, other.Len())
+	}
+	if err := other.Load(strings.NewReader("{")); err == nil {
+		t.Error("Load() accepted invalid state")
+	}
+}
+
+func TestFakeIPPoolLoadSkipsInvalidEntries(t *testing.T) {
+	p := newTestFakeIPPool(t, "10.0.0.0/28", 8)
+	state := `{"range": "10.0.0.0/28", "entries": [
+		{"name": "ok.example", "ip": "10.0.0.2"},
+		{"name": "", "ip": "10.0.0.3"},
+		{"name": "outside.example", "ip": "192.0.2.1"},
+		{"name": "network.example", "ip": "10.0.0.0"},
+		{"name": "beyond.example", "ip": "10.0.0.9"},
+		{"name": "OK.example", "ip": "10.0.0.4"},
+		{"name": "same-ip.example", "ip": "10.0.0.2"}
+	]}`
+	if err := p.Load(strings.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 1 {
+		t.Errorf("Len() = %d, want only ok.example", p.Len())
+	}
+
+	// Filling the pool hands out every other address, including the
+	// gap below the loaded one, without evicting ok.example.
+	got := map[string]bool{}
+	for i := 0; i < 7; i++ {
+		got[p.Allocate(fmt.Sprintf("n%d.example", i)).String()] = true
+	}
+	for _, want := range []string{"10.0.0.1", "10.0.0.3", "10.0.0.8"} {
+		if !got[want] {
+			t.Errorf("allocated %v, want %s among them", got, want)
+		}
+	}
+	if got["10.0.0.2"] {
+		t.Error("allocated the address loaded for ok.example")
+	}
+	if name, _ := p.Lookup(net.IPv4(10, 0, 0, 2)); name != "ok.example" {
+		t.Errorf("Lookup(10.0.0.2) = %q, want ok.example", name)
+	}
+}
+
+func TestFakeIPPoolAnswers(t *testing.T) {
+	p := newTestFakeIPPool(t, "198.18.0.0/15", 0)
+	question := func(name string, qtype dnsmessage.Type) dnsmessage.Question {
+		return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}
+	}
+
+	answers, rcode, ok := p.Answers(question("example.com.", dnsmessage.TypeA))
+	if !ok || rcode != dnsmessage.RCodeSuccess || len(answers) != 1 {
+		t.Fatalf("A answers = %v, %v, %v", answers, rcode, ok)
+	}
+	if a := answers[0].Body.(*dnsmessage.AResource).A; net.IP(a[:]).String() != "198.18.0.1" {
+		t.Errorf("A = %v, want 198.18.0.1", a)
+	}
+
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeAAAA, typeHTTPS} {
+		if answers, rcode, ok := p.Answers(question("example.com.", qtype)); !ok || rcode != dnsmessage.RCodeSuccess || len(answers) != 0 {
+			t.Errorf("%v answers = %v, %v, %v; want no data", qtype, answers, rcode, ok)
+		}
+	}
+
+	answers, _, ok = p.Answers(question("1.0.18.198.in-addr.arpa.", dnsmessage.TypePTR))
+	if !ok || len(answers) != 1 || answers[0].Body.(*dnsmessage.PTRResource).PTR.String() != "example.com." {
+		t.Errorf("PTR answers = %v, %v; want example.com.", answers, ok)
+	}
+	if _, rcode, ok := p.Answers(question("9.0.18.198.in-addr.arpa.", dnsmessage.TypePTR)); !ok || rcode != dnsmessage.RCodeNameError {
+		t.Errorf("PTR of an unused address = %v, %v; want NXDOMAIN", rcode, ok)
+	}
+	for _, q := range []dnsmessage.Question{
+		question("1.2.0.192.in-addr.arpa.", dnsmessage.TypePTR),
+		question("example.com.", dnsmessage.TypeMX),
+	} {
+		if _, _, ok := p.Answers(q); ok {
+			t.Errorf("Answers(%v) handled a question that has to be forwarded", q)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const hostsAnswerTTL = 60
+
+// Hosts is a static name to address override map, consulted before the
+// cache and any upstream.
+type Hosts struct {
+	mu      sync.RWMutex
+	entries map[string][]net.IP
+}
+
+func NewHosts() *Hosts {
+	return &Hosts{
+		entries: make(map[string][]net.IP),
+	}
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+func (h *Hosts) Set(name string, ips ...net.IP) {
+	h.mu.Lock()
+	h.entries[normalizeName(name)] = ips
+	h.mu.Unlock()
+}
+
+func (h *Hosts) Remove(name string) {
+	h.mu.Lock()
+	delete(h.entries, normalizeName(name))
+	h.mu.Unlock()
+}
+
+func (h *Hosts) Lookup(name string) []net.IP {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.entries[normalizeName(name)]
+}
+
+// SetAll replaces the map with entries given as name -> address strings.
+// Invalid addresses are skipped.
+func (h *Hosts) SetAll(entries map[string]string) {
+	parsed := make(map[string][]net.IP, len(entries))
+	for name, addr := range entries {
+		if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+			key := normalizeName(name)
+			parsed[key] = append(parsed[key], ip)
+		}
+	}
+
+	h.mu.Lock()
+	h.entries = parsed
+	h.mu.Unlock()
+}
+
+// Load adds entries in /etc/hosts format ("address name [aliases...]").
+func (h *Hosts) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			key := normalizeName(name)
+			h.entries[key] = append(h.entries[key], ip)
+		}
+	}
+	return scanner.Err()
+}
+
+func (h *Hosts) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.entries)
+}
+
+// Answers synthesizes A/AAAA answers for a question covered by the map.
+// ok is false when the name has no override.
+func (h *Hosts) Answers(q dnsmessage.Question) ([]dnsmessage.Resource, bool) {
+	ips := h.Lookup(q.Name.String())
+	if len(ips) == 0 {
+		return nil, false
+	}
+
+	var answers []dnsmessage.Resource
+	for _, ip := range ips {
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.To4() != nil:
+			answers = append(answers, aResource(q.Name.String(), ip, hostsAnswerTTL))
+		case q.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			answers = append(answers, aaaaResource(q.Name.String(), ip, hostsAnswerTTL))
+		}
+	}
+	return answers, true
+}
@@ -0,0 +1,319 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type Policy string
+
+const (
+	PolicyRemote          Policy = "remote"
+	PolicyLocal           Policy = "local"
+	PolicyLocalThenRemote Policy = "local-then-remote"
+
+	DefaultUpstream = "1.1.1.1:53"
+
+	localAnswerTTL = 60
+)
+
+var ErrNotFound = errors.New("no such host")
+
+// DialFunc opens a stream connection, usually through a tunnel.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type Resolver struct {
+	policy   Policy
+	upstream string
+	dial     DialFunc
+	cache    *Cache
+	hosts    *Hosts
+	local    *net.Resolver
+	timeout  time.Duration
+}
+
+func NewResolver(policy Policy, upstream string, dial DialFunc, cacheSize int) *Resolver {
+	switch policy {
+	case PolicyRemote, PolicyLocal, PolicyLocalThenRemote:
+	default:
+		policy = PolicyRemote
+	}
+	if upstream == "" {
+		upstream = DefaultUpstream
+	}
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+
+	return &Resolver{
+		policy:   policy,
+		upstream: upstream,
+		dial:     dial,
+		cache:    NewCache(cacheSize),
+		hosts:    NewHosts(),
+		local:    net.DefaultResolver,
+		timeout:  5 * time.Second,
+	}
+}
+
+func (r *Resolver) Policy() Policy {
+	return r.policy
+}
+
+func (r *Resolver) Upstream() string {
+	return r.upstream
+}
+
+func (r *Resolver) Cache() *Cache {
+	return r.cache
+}
+
+func (r *Resolver) Hosts() *Hosts {
+	return r.hosts
+}
+
+// LookupIP resolves host according to the resolver policy. IP literals
+// and hosts overrides are returned as-is.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if ips := r.hosts.Lookup(host); len(ips) > 0 {
+		return ips, nil
+	}
+
+	if ips, ok := r.cachedIPs(host); ok {
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("%s: %w", host, ErrNotFound)
+		}
+		return ips, nil
+	}
+
+	switch r.policy {
+	case PolicyLocal:
+		return r.lookupLocal(ctx, host)
+	case PolicyLocalThenRemote:
+		ips, err := r.lookupLocal(ctx, host)
+		if err == nil {
+			return ips, nil
+		}
+		log.WithError(err).WithField("host", host).Debug("Local lookup failed, trying remote")
+		return r.lookupRemote(ctx, host)
+	default:
+		return r.lookupRemote(ctx, host)
+	}
+}
+
+func (r *Resolver) cachedIPs(host string) ([]net.IP, bool) {
+	var ips []net.IP
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, _, ok := r.cache.Get(fqdn(host), qtype)
+		if !ok {
+			continue
+		}
+		found = true
+		ips = append(ips, ipsFromAnswers(answers)...)
+	}
+	return ips, found
+}
+
+func (r *Resolver) lookupLocal(ctx context.Context, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	addrs, err := r.local.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	var v4, v6 []dnsmessage.Resource
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			v4 = append(v4, aResource(host, ip4, localAnswerTTL))
+		} else {
+			v6 = append(v6, aaaaResource(host, addr.IP, localAnswerTTL))
+		}
+	}
+	r.cache.Put(fqdn(host), dnsmessage.TypeA, dnsmessage.RCodeSuccess, v4)
+	r.cache.Put(fqdn(host), dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, v6)
+	return ips, nil
+}
+
+func (r *Resolver) lookupRemote(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := r.Query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, ipsFromAnswers(msg.Answers)...)
+	}
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("%s: %w", host, ErrNotFound)
+	}
+	return ips, nil
+}
+
+// Query sends a single question to the upstream through the tunnel and
+// caches the answer.
+func (r *Resolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %w", name, err)
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Intn(1 << 16)),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := r.Exchange(ctx, packed)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if resp.ID != query.ID {
+		return nil, fmt.Errorf("DNS response ID mismatch")
+	}
+
+	r.cache.Put(qname.String(), qtype, resp.RCode, resp.Answers)
+	return &resp, nil
+}
+
+// Forward relays a client query to the upstream unchanged and caches the
+// answers of a successful response.
+func (r *Resolver) Forward(ctx context.Context, query []byte) ([]byte, *dnsmessage.Message, error) {
+	raw, err := r.Exchange(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil {
+		return nil, nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if len(resp.Questions) == 1 && !resp.Truncated {
+		q := resp.Questions[0]
+		r.cache.Put(q.Name.String(), q.Type, resp.RCode, resp.Answers)
+	}
+	return raw, &resp, nil
+}
+
+// Exchange sends a raw DNS message to the upstream over TCP and returns the
+// raw response.
+func (r *Resolver) Exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	if r.dial == nil {
+		return nil, fmt.Errorf("no dialer configured for remote DNS")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	conn, err := r.dial(ctx, "tcp", r.upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS upstream %s: %w", r.upstream, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	return resp, nil
+}
+
+func fqdn(name string) string {
+	if name == "" || name[len(name)-1] != '.' {
+		return name + "."
+	}
+	return name
+}
+
+func ipsFromAnswers(answers []dnsmessage.Resource) []net.IP {
+	var ips []net.IP
+	for _, rr := range answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		}
+	}
+	return ips
+}
+
+func aResource(name string, ip net.IP, ttl uint32) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], ip.To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(fqdn(name)),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.AResource{A: a},
+	}
+}
+
+func aaaaResource(name string, ip net.IP, ttl uint32) dnsmessage.Resource {
+	var aaaa [16]byte
+	copy(aaaa[:], ip.To16())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(fqdn(name)),
+			Type:  dnsmessage.TypeAAAA,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.AAAAResource{AAAA: aaaa},
+	}
+}
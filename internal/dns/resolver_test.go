@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testUpstream answers A queries for the names in records over DNS/TCP
+// and NXDOMAIN for any other name; AAAA queries get no data.
+type testUpstream struct {
+	records map[string]net.IP
+	dials   atomic.Int32
+}
+
+func (u *testUpstream) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	u.dials.Add(1)
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		var length [2]byte
+		if _, err := io.ReadFull(server, length[:]); err != nil {
+			return
+		}
+		raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(server, raw); err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(raw); err != nil {
+			return
+		}
+
+		q := query.Questions[0]
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+		}
+		ip, ok := u.records[strings.TrimSuffix(q.Name.String(), ".")]
+		switch {
+		case !ok:
+			resp.RCode = dnsmessage.RCodeNameError
+		case q.Type == dnsmessage.TypeA:
+			resp.Answers = []dnsmessage.Resource{aResource(q.Name.String(), ip, 300)}
+		}
+
+		packed, _ := resp.Pack()
+		server.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+	}()
+	return client, nil
+}
+
+func TestResolverRemoteCache(t *testing.T) {
+	upstream := &testUpstream{records: map[string]net.IP{"example.com": net.IPv4(192, 0, 2, 1)}}
+	r := NewResolver(PolicyRemote, "192.0.2.53", upstream.dial, 16)
+	if r.Upstream() != "192.0.2.53:53" {
+		t.Errorf("Upstream() = %q, want the default port added", r.Upstream())
+	}
+
+	for i := 0; i < 2; i++ {
+		ips, err := r.LookupIP(context.Background(), "example.com")
+		if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+			t.Fatalf("LookupIP() = %v, %v; want 192.0.2.1", ips, err)
+		}
+	}
+	if got := upstream.dials.Load(); got != 2 {
+		t.Errorf("upstream queried %d times, want once per record type", got)
+	}
+
+	// Missing names are cached too.
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupIP(context.Background(), "missing.example"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("LookupIP(missing) = %v, want ErrNotFound", err)
+		}
+	}
+	if got := upstream.dials.Load(); got != 4 {
+		t.Errorf("upstream queried %d times, want the miss cached", got)
+	}
+}
+
+func TestResolverOverrides(t *testing.T) {
+	upstream := &testUpstream{}
+	r := NewResolver("bogus", "", upstream.dial, 16)
+	if r.Policy() != PolicyRemote || r.Upstream() != DefaultUpstream {
+		t.Errorf("policy, upstream = %q, %q; want the defaults", r.Policy(), r.Upstream())
+	}
+
+	r.Hosts().Load(strings.NewReader("# comment\n192.0.2.10 nas.lan nas # trailing\nbogus name\n"))
+	r.Hosts().Set("Router.LAN.", net.IPv4(192, 0, 2, 254))
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"198.51.100.1", "198.51.100.1"},
+		{"NAS.lan", "192.0.2.10"},
+		{"nas", "192.0.2.10"},
+		{"router.lan", "192.0.2.254"},
+	}
+	for _, tt := range tests {
+		ips, err := r.LookupIP(context.Background(), tt.host)
+		if err != nil || len(ips) != 1 || ips[0].String() != tt.want {
+			t.Errorf("LookupIP(%q) = %v, %v; want %s", tt.host, ips, err, tt.want)
+		}
+	}
+	if got := upstream.dials.Load(); got != 0 {
+		t.Errorf("upstream queried %d times for overridden names", got)
+	}
+}
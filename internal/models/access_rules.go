@@ -8,6 +8,7 @@ type AccessRule struct {
 	ID          string        `json:"id"`
 	Title       string        `json:"title"`
 	IP          string        `json:"ip"`
+	Username    string        `json:"username,omitempty"`
 	IsMaster    bool          `json:"is_master"`
 	DailyLimit  time.Duration `json:"daily_limit"`
 	Description string        `json:"description"`
@@ -16,4 +17,11 @@ type AccessRule struct {
 	LastAccess  time.Time     `json:"last_access,omitempty"`
 	UsedTime    time.Duration `json:"used_time,omitempty"`
 	IsBlocked   bool          `json:"is_blocked,omitempty"`
+	DNSPolicy   string        `json:"dns_policy,omitempty"`
+	BlockLists  []string      `json:"block_lists,omitempty"`
 }
+
+const (
+	DNSPolicyForward = ""
+	DNSPolicyBlock   = "block"
+)
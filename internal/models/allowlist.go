@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AllowedClient is an entry of the client allowlist: a device, by address
+// or CIDR range, or an authenticated user. Only one of Address and
+// Username is set.
+type AllowedClient struct {
+	Address  string    `json:"address,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	AddedAt  time.Time `json:"added_at"`
+}
@@ -2,7 +2,35 @@ package models
 
 import "time"
 
+// BlockedIPInfo is an entry of the IP blocklist. IP is an address or a CIDR
+// range; an entry without ExpiresAt stays until it is removed.
 type BlockedIPInfo struct {
-	IP        string    `json:"ip"`
-	Timestamp time.Time `json:"timestamp"`
+	IP        string     `json:"ip"`
+	Timestamp time.Time  `json:"timestamp"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// AutoBanConfig configures the automatic banning of abusive clients. A
+// client that sends Threshold failed authentications or malformed
+// handshakes within Window is blocked for BanTime, doubled on every
+// further ban up to MaxBanTime. ConnectionBurst, if set, also bans clients
+// opening more new connections than that within Window. Clients in
+// Allowlist, addresses or CIDR ranges, are never banned.
+type AutoBanConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Threshold       int           `json:"threshold,omitempty"`
+	Window          time.Duration `json:"window,omitempty"`
+	BanTime         time.Duration `json:"ban_time,omitempty"`
+	MaxBanTime      time.Duration `json:"max_ban_time,omitempty"`
+	ConnectionBurst int           `json:"connection_burst,omitempty"`
+	Allowlist       []string      `json:"allowlist,omitempty"`
+}
+
+// AutoBanRecord is the escalation state of a client the auto-ban banned,
+// saved so repeat offenders keep escalating across restarts.
+type AutoBanRecord struct {
+	IP      string    `json:"ip"`
+	Bans    int       `json:"bans"`
+	LastBan time.Time `json:"last_ban"` // end of the last ban
 }
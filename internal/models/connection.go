@@ -22,6 +22,7 @@ type ServerConfig struct {
 	Connections int    `json:"connections"`
 	MaxRetries  int    `json:"max_retries"`
 	Mode        string `json:"mode"`
+	UDPGW       string `json:"udpgw,omitempty"` // udpgw address as seen from the server
 }
 
 type Connection struct {
@@ -45,10 +46,22 @@ type Stats struct {
 	Connected     int    `json:"connected"`
 }
 
+// TunConfig configures the TUN inbound. Address is the CIDR of the
+// interface. DNSServers replace the system resolvers while the TUN is up.
+// IncludeRoutes limits the captured traffic to the given CIDRs, everything
+// if empty; ExcludeRoutes keep their original route. With FakeIP, a DNS
+// responder on Gateway answers with addresses from FakeIPRange so flows
+// can be routed by name, and the system resolvers point at it instead of
+// DNSServers.
 type TunConfig struct {
-	DeviceName string
-	Address    string
-	Gateway    string
-	MTU        int
-	DNSServers []string
+	Enabled       bool     `json:"enabled"`
+	DeviceName    string   `json:"device_name"`
+	Address       string   `json:"address"`
+	Gateway       string   `json:"gateway,omitempty"`
+	MTU           int      `json:"mtu"`
+	DNSServers    []string `json:"dns_servers,omitempty"`
+	IncludeRoutes []string `json:"include_routes,omitempty"`
+	ExcludeRoutes []string `json:"exclude_routes,omitempty"`
+	FakeIP        bool     `json:"fake_ip,omitempty"`
+	FakeIPRange   string   `json:"fake_ip_range,omitempty"`
 }
@@ -0,0 +1,12 @@
+package models
+
+// DestinationList is a local file of destinations that clients may not
+// connect to. Each line is a hosts file entry, an adblock "||domain^"
+// rule, or a plain domain, IP address, CIDR or ":port". Domains also block
+// their subdomains. A Global list applies to every client; others only to
+// the clients of the access rules that name it in BlockLists.
+type DestinationList struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Global bool   `json:"global,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+type DNSConfig struct {
+	Policy    string            `json:"policy"`
+	Upstream  string            `json:"upstream"`
+	CacheSize int               `json:"cache_size,omitempty"`
+	Hosts     map[string]string `json:"hosts,omitempty"`
+	HostsFile string            `json:"hosts_file,omitempty"`
+
+	// Built-in DNS server; disabled when ListenPort is 0.
+	ListenAddr string `json:"listen_addr,omitempty"`
+	ListenPort int16  `json:"listen_port,omitempty"`
+}
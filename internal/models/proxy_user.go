@@ -0,0 +1,19 @@
+package models
+
+// ProxyUser is a static proxy account. PasswordHash is a bcrypt hash, as
+// produced by security.HashPassword or `htpasswd -nB`.
+type ProxyUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// ListenerConfig describes one proxy listener.
+type ListenerConfig struct {
+	Mode           string     `json:"mode"` // socks5, http, https, mixed, redirect or tproxy
+	Address        string     `json:"address,omitempty"`
+	Port           int16      `json:"port"`
+	AllowAnonymous bool       `json:"allow_anonymous"`
+	TLS            *TLSConfig `json:"tls,omitempty"` // required for https
+	XForwardedFor  bool       `json:"x_forwarded_for,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+const (
+	RouteActionTunnel = "tunnel"
+	RouteActionDirect = "direct"
+	RouteActionReject = "reject"
+)
+
+// RoutingRule decides how a flow leaves the proxy. Rules are evaluated in
+// order and the first one whose non-empty matchers all match wins.
+type RoutingRule struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Inbound string   `json:"inbound,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+	CIDRs   []string `json:"cidrs,omitempty"`
+	Ports   []int    `json:"ports,omitempty"`
+	Action  string   `json:"action"`
+	Pool    string   `json:"pool,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+const (
+	ClientAuthNone    = ""
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// TLSConfig enables TLS on a listener. Without CertFile and KeyFile a
+// certificate signed by a local CA in the storage directory is used.
+// The common name of a verified client certificate is used as the
+// username for access rules.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file,omitempty"`
+	KeyFile      string `json:"key_file,omitempty"`
+	ClientAuth   string `json:"client_auth,omitempty"`
+	ClientCAFile string `json:"client_ca_file,omitempty"` // defaults to the local CA
+}
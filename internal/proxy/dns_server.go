@@ -0,0 +1,390 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xengate/internal/dns"
+	"xengate/internal/models"
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	maxDNSQueryLog  = 200
+	defaultUDPSize  = 512
+	maxDNSMsgSize   = 65535
+	dnsQueryTimeout = 5 * time.Second
+)
+
+type DNSQueryLog struct {
+	Time     time.Time
+	ClientIP string
+	Name     string
+	Type     string
+	Result   string
+	Duration time.Duration
+}
+
+type DNSStats struct {
+	Queries   int64
+	CacheHits int64
+	Forwarded int64
+	Blocked   int64
+	Failed    int64
+}
+
+// DNSServer answers DNS queries from LAN clients over UDP and TCP. Answers
+// come from the hosts overrides and the resolver cache, misses are
+// forwarded through a tunnel as DNS-over-TCP. With a fake IP pool, A
+// queries are answered from the pool instead.
+type DNSServer struct {
+	manager     *tunnel.Manager
+	fakeIP      *dns.FakeIPPool
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	wg          sync.WaitGroup
+	mu          sync.RWMutex
+	closed      bool
+	ip          string
+	port        int16
+
+	queries   int64
+	cacheHits int64
+	forwarded int64
+	blocked   int64
+	failed    int64
+
+	logMu    sync.Mutex
+	queryLog []DNSQueryLog
+}
+
+func NewDNSServer(ip string, port int16, manager *tunnel.Manager) (*DNSServer, error) {
+	if manager.Resolver() == nil {
+		return nil, fmt.Errorf("DNS server requires a resolver")
+	}
+	return &DNSServer{
+		manager: manager,
+		ip:      ip,
+		port:    port,
+	}, nil
+}
+
+// newFakeIPServer creates the responder of the TUN fake IP mode. It is not
+// started; the TUN stack hands it the queries sent to the gateway.
+func newFakeIPServer(manager *tunnel.Manager, pool *dns.FakeIPPool) (*DNSServer, error) {
+	if manager.Resolver() == nil {
+		return nil, fmt.Errorf("fake IP DNS requires a resolver")
+	}
+	return &DNSServer{
+		manager: manager,
+		fakeIP:  pool,
+	}, nil
+}
+
+func (s *DNSServer) Start(ctx context.Context) error {
+	addr := listenAddr(s.ip, s.port)
+
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+	}
+
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to listen on tcp %s: %w", addr, err)
+	}
+
+	s.udpConn = udpConn
+	s.tcpListener = tcpListener
+	log.Infof("DNS server listening on %s (udp/tcp)", addr)
+
+	s.wg.Add(2)
+	go s.serveUDP(ctx)
+	go s.acceptLoop(ctx)
+
+	// Shutdown handler
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	return nil
+}
+
+func (s *DNSServer) isClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+func (s *DNSServer) serveUDP(ctx context.Context) {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxDNSMsgSize)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			log.Errorf("DNS read error: %v", err)
+			continue
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			resp := s.handleQuery(ctx, tunnel.ClientIP(addr), query, true)
+			if resp != nil {
+				s.udpConn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *DNSServer) acceptLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			log.Errorf("DNS accept error: %v", err)
+			continue
+		}
+
+		release, ok := admit(s.manager, conn, "dns")
+		if !ok {
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.handleTCP(ctx, conn, release)
+	}
+}
+
+func (s *DNSServer) handleTCP(ctx context.Context, conn net.Conn, release func()) {
+	defer s.wg.Done()
+	defer release()
+	s.serveStream(ctx, conn)
+}
+
+// serveStream answers the length-prefixed queries read from conn until the
+// client goes away, then closes conn.
+func (s *DNSServer) serveStream(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	clientIP := tunnel.ClientIP(conn.RemoteAddr())
+	for {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		resp := s.handleQuery(ctx, clientIP, query, false)
+		if resp == nil {
+			return
+		}
+
+		out := make([]byte, 2+len(resp))
+		binary.BigEndian.PutUint16(out, uint16(len(resp)))
+		copy(out[2:], resp)
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// handleQuery returns the packed response, or nil when the query is not
+// worth answering.
+func (s *DNSServer) handleQuery(ctx context.Context, clientIP string, query []byte, udp bool) []byte {
+	start := time.Now()
+	atomic.AddInt64(&s.queries, 1)
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || msg.Response || len(msg.Questions) != 1 {
+		atomic.AddInt64(&s.failed, 1)
+		log.WithField("clientIP", clientIP).Debug("Dropping malformed DNS query")
+		return nil
+	}
+	q := msg.Questions[0]
+
+	entry := DNSQueryLog{
+		Time:     start,
+		ClientIP: clientIP,
+		Name:     q.Name.String(),
+		Type:     q.Type.String(),
+	}
+	defer func() {
+		entry.Duration = time.Since(start)
+		s.logQuery(entry)
+	}()
+
+	if s.manager.IsIPBlocked(clientIP) || !s.manager.IsClientAllowed(clientIP, "") ||
+		s.manager.AccessControl().DNSPolicy(clientIP) == models.DNSPolicyBlock {
+		atomic.AddInt64(&s.blocked, 1)
+		entry.Result = "blocked"
+		return s.reply(&msg, dnsmessage.RCodeRefused, nil, udp)
+	}
+
+	resolver := s.manager.Resolver()
+
+	if answers, ok := resolver.Hosts().Answers(q); ok {
+		atomic.AddInt64(&s.cacheHits, 1)
+		entry.Result = "hosts"
+		return s.reply(&msg, dnsmessage.RCodeSuccess, answers, udp)
+	}
+
+	if s.fakeIP != nil {
+		if answers, rcode, ok := s.fakeIP.Answers(q); ok {
+			entry.Result = "fake-ip"
+			return s.reply(&msg, rcode, answers, udp)
+		}
+	}
+
+	if answers, rcode, ok := resolver.Cache().Get(q.Name.String(), q.Type); ok {
+		atomic.AddInt64(&s.cacheHits, 1)
+		entry.Result = "cache"
+		return s.reply(&msg, rcode, answers, udp)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+
+	raw, resp, err := resolver.Forward(ctx, query)
+	if err != nil {
+		atomic.AddInt64(&s.failed, 1)
+		entry.Result = "failed"
+		log.WithError(err).WithField("name", entry.Name).Debug("DNS forward failed")
+		return s.reply(&msg, dnsmessage.RCodeServerFailure, nil, udp)
+	}
+
+	atomic.AddInt64(&s.forwarded, 1)
+	entry.Result = resp.RCode.String()
+	if udp && len(raw) > udpPayloadSize(&msg) {
+		return s.reply(&msg, resp.RCode, resp.Answers, udp)
+	}
+	return raw
+}
+
+// reply builds a response to msg. Over UDP, answers that don't fit are
+// dropped and the truncated bit is set so the client retries over TCP.
+func (s *DNSServer) reply(msg *dnsmessage.Message, rcode dnsmessage.RCode, answers []dnsmessage.Resource, udp bool) []byte {
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 msg.ID,
+			Response:           true,
+			OpCode:             msg.OpCode,
+			RecursionDesired:   msg.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: msg.Questions,
+		Answers:   answers,
+	}
+
+	packed, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+
+	if udp && len(packed) > udpPayloadSize(msg) {
+		resp.Answers = nil
+		resp.Truncated = true
+		packed, err = resp.Pack()
+		if err != nil {
+			return nil
+		}
+	}
+	return packed
+}
+
+func udpPayloadSize(msg *dnsmessage.Message) int {
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			if size := int(rr.Header.Class); size > defaultUDPSize {
+				return size
+			}
+		}
+	}
+	return defaultUDPSize
+}
+
+func (s *DNSServer) logQuery(entry DNSQueryLog) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	s.queryLog = append(s.queryLog, entry)
+	if len(s.queryLog) > maxDNSQueryLog {
+		s.queryLog = s.queryLog[len(s.queryLog)-maxDNSQueryLog:]
+	}
+}
+
+// RecentQueries returns the latest queries, newest first.
+func (s *DNSServer) RecentQueries() []DNSQueryLog {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	result := make([]DNSQueryLog, len(s.queryLog))
+	for i, entry := range s.queryLog {
+		result[len(s.queryLog)-1-i] = entry
+	}
+	return result
+}
+
+func (s *DNSServer) GetStats() DNSStats {
+	return DNSStats{
+		Queries:   atomic.LoadInt64(&s.queries),
+		CacheHits: atomic.LoadInt64(&s.cacheHits),
+		Forwarded: atomic.LoadInt64(&s.forwarded),
+		Blocked:   atomic.LoadInt64(&s.blocked),
+		Failed:    atomic.LoadInt64(&s.failed),
+	}
+}
+
+func (s *DNSServer) Stop() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+
+	s.wg.Wait()
+	return nil
+}
@@ -2,14 +2,16 @@ package proxy
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"net/url"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"xengate/internal/tunnel"
@@ -17,6 +19,16 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	httpIdleTimeout     = 90 * time.Second
+	httpResponseTimeout = 60 * time.Second
+	proxyRealm          = "XenGate"
+)
+
+// metadataKey carries the tunnel.Metadata of a request to the dialer of
+// the upstream transport.
+type metadataKey struct{}
+
 type HTTPProxy struct {
 	manager  *tunnel.Manager
 	listener net.Listener
@@ -26,44 +38,34 @@ type HTTPProxy struct {
 	ip       string
 	port     int16
 	mode     string
+	opts     *Options
 }
 
-func NewHTTPProxy(mode string, ip string, port int16, manager *tunnel.Manager) *HTTPProxy {
+func NewHTTPProxy(mode string, ip string, port int16, manager *tunnel.Manager, opts *Options) (*HTTPProxy, error) {
+	if mode == "https" && opts.tlsConfig() == nil {
+		return nil, fmt.Errorf("https proxy requires a TLS configuration")
+	}
 	return &HTTPProxy{
 		manager: manager,
 		ip:      ip,
 		port:    port,
 		mode:    mode,
-	}
+		opts:    opts,
+	}, nil
 }
 
 func (p *HTTPProxy) Start(ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", p.ip, p.port)
-
-	var listener net.Listener
-	var err error
-
-	if p.mode == "https" {
-		// Load TLS certificate
-		// cert, err := tls.LoadX509KeyPair(p.config.CertFile, p.config.KeyFile)
-		// if err != nil {
-		// 	return fmt.Errorf("failed to load TLS certificate: %w", err)
-		// }
-
-		// tlsConfig := &tls.Config{
-		// 	Certificates: []tls.Certificate{cert},
-		// }
-
-		// listener, err = tls.Listen("tcp", addr, tlsConfig)
-		// log.Infof("HTTPS proxy listening on %s", addr)
-	} else {
-		listener, err = net.Listen("tcp", addr)
-		log.Infof("HTTP proxy listening on %s", addr)
-	}
+	addr := listenAddr(p.ip, p.port)
 
+	listener, err := listen(addr, p.opts)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
+	if p.opts.tlsConfig() != nil {
+		log.Infof("HTTPS proxy listening on %s", addr)
+	} else {
+		log.Infof("HTTP proxy listening on %s", addr)
+	}
 
 	p.listener = listener
 
@@ -102,8 +104,16 @@ func (p *HTTPProxy) acceptLoop(ctx context.Context) {
 				continue
 			}
 
+			release, ok := admit(p.manager, conn, p.mode)
+			if !ok {
+				continue
+			}
+
 			p.wg.Add(1)
-			go p.handleConnection(ctx, conn)
+			go func() {
+				defer release()
+				p.handleConnection(ctx, conn)
+			}()
 		}
 	}
 }
@@ -114,155 +124,397 @@ func (p *HTTPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		clientConn.Close()
 	}()
 
+	p.serveConn(ctx, clientConn)
+}
+
+// serveConn reads requests from clientConn until either side closes the
+// connection. CONNECT requests turn the connection into a tunnel.
+func (p *HTTPProxy) serveConn(ctx context.Context, clientConn net.Conn) {
 	// Set timeout for initial request
 	clientConn.SetReadDeadline(time.Now().Add(10 * time.Second))
 
-	// Read the first line to determine the type of request
-	reader := bufio.NewReader(clientConn)
-	firstLine, err := reader.ReadString('\n')
+	clientIP := tunnel.ClientIP(clientConn.RemoteAddr())
+
+	// A verified client certificate identifies the user
+	user, err := peerCertUser(clientConn)
 	if err != nil {
-		log.Debugf("Failed to read request: %v", err)
+		log.Debugf("HTTPS proxy TLS error: %v", err)
 		return
 	}
 
-	firstLine = strings.TrimSpace(firstLine)
-	if firstLine == "" {
-		return
+	meta := tunnel.Metadata{
+		Inbound:  p.mode,
+		ClientIP: clientIP,
+		User:     user,
 	}
 
-	// Check if it's a CONNECT request (HTTPS)
-	if strings.HasPrefix(strings.ToUpper(firstLine), "CONNECT ") {
-		p.handleHTTPS(ctx, clientConn, reader, firstLine)
-	} else {
-		// Handle HTTP request
-		p.handleHTTP(ctx, clientConn, reader, firstLine)
+	// Upstream connections are not kept idle: each one holds an access
+	// session that has to end with its request, or the client stays bound
+	// to that host.
+	transport := &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			flow, _ := ctx.Value(metadataKey{}).(tunnel.Metadata)
+			flow.Target = addr
+			session, err := p.manager.Connect(ctx, &flow)
+			if err != nil {
+				return nil, err
+			}
+			return session.Conn(), nil
+		},
+		DisableCompression:    true,
+		DisableKeepAlives:     true,
+		ResponseHeaderTimeout: httpResponseTimeout,
 	}
-}
 
-func (p *HTTPProxy) handleHTTPS(ctx context.Context, clientConn net.Conn, reader *bufio.Reader, firstLine string) {
-	// Extract host:port from CONNECT request
-	parts := strings.Split(firstLine, " ")
-	if len(parts) < 2 {
-		clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
-	}
+	reader := bufio.NewReader(clientConn)
+	for first := true; ; first = false {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if isMalformedRequest(err) {
+				log.Debugf("Failed to read request: %v", err)
+				writeHTTPError(clientConn, http.StatusBadRequest, "malformed request")
+				// Later requests of a connection come from a client that
+				// already spoke HTTP; only a bad opening counts as abuse.
+				if first {
+					p.manager.ReportAbuse(meta.ClientIP, tunnel.AbuseMalformed)
+				}
+			}
+			return
+		}
+		clientConn.SetReadDeadline(time.Time{})
 
-	target := parts[1]
-	log.Debugf("HTTPS CONNECT request for: %s", target)
+		// Automatic proxy configuration is served without authentication
+		if isPACRequest(req) {
+			if !p.servePAC(clientConn, req) {
+				return
+			}
+			clientConn.SetReadDeadline(time.Now().Add(httpIdleTimeout))
+			continue
+		}
 
-	// Read headers until we get an empty line (end of headers)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil || line == "\r\n" {
-			break
+		reqMeta := meta
+		user, ok := p.authenticate(req, meta)
+		if !ok {
+			// Without a body to skip, the client can retry with
+			// credentials on the same connection.
+			keepAlive := req.Body == http.NoBody && !req.Close
+			writeProxyAuthRequired(clientConn, keepAlive)
+			if !keepAlive {
+				return
+			}
+			clientConn.SetReadDeadline(time.Now().Add(httpIdleTimeout))
+			continue
+		}
+		reqMeta.User = user
+
+		if req.Method == http.MethodConnect {
+			p.handleConnect(clientConn, reader, req, reqMeta)
+			return
+		}
+
+		if !p.handleHTTP(ctx, clientConn, reader, req, reqMeta, transport) {
+			return
 		}
+		clientConn.SetReadDeadline(time.Now().Add(httpIdleTimeout))
 	}
+}
 
-	// Acknowledge CONNECT request
-	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+// authenticate returns the identity of the client sending req. Clients
+// with a verified certificate need no credentials; otherwise Basic
+// Proxy-Authorization is checked against the shared credential store.
+func (p *HTTPProxy) authenticate(req *http.Request, meta tunnel.Metadata) (string, bool) {
+	if meta.User != "" {
+		return meta.User, true
+	}
+
+	if store := p.opts.credentials(); store != nil {
+		if username, password, ok := proxyBasicAuth(req); ok {
+			if store.Authenticate(username, password) {
+				return username, true
+			}
+			log.WithFields(log.Fields{
+				"clientIP": meta.ClientIP,
+				"user":     username,
+			}).Warn("HTTP proxy authentication failed")
+			p.manager.ReportAbuse(meta.ClientIP, tunnel.AbuseAuthFailure)
+			return "", false
+		}
+	}
 
-	// Clear timeout now that connection is established
-	clientConn.SetDeadline(time.Time{})
+	return "", p.opts.allowAnonymous()
+}
 
-	// Tunnel the connection
-	p.manager.Forward(clientConn, target)
+func proxyBasicAuth(req *http.Request) (string, string, bool) {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
 }
 
-func (p *HTTPProxy) handleHTTP(ctx context.Context, clientConn net.Conn, reader *bufio.Reader, firstLine string) {
-	// Parse the request line
-	parts := strings.Split(firstLine, " ")
-	if len(parts) < 3 {
-		clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+func writeProxyAuthRequired(conn net.Conn, keepAlive bool) {
+	body := "407 Proxy Authentication Required\n"
+	resp := &http.Response{
+		StatusCode: http.StatusProxyAuthRequired,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Proxy-Authenticate": {`Basic realm="` + proxyRealm + `", charset="UTF-8"`},
+			"Content-Type":       {"text/plain; charset=utf-8"},
+		},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         !keepAlive,
 	}
+	resp.Write(conn)
+}
 
-	method, requestURI, proto := parts[0], parts[1], parts[2]
-	log.Debugf("HTTP %s request for: %s", method, requestURI)
+// handleConnect dials the target first and only confirms the tunnel once
+// it is established.
+func (p *HTTPProxy) handleConnect(clientConn net.Conn, reader *bufio.Reader, req *http.Request, meta tunnel.Metadata) {
+	target := req.Host
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+	meta.Target = target
+	log.Debugf("HTTPS CONNECT request for: %s", target)
 
-	// Parse the URL
-	targetURL, err := url.Parse(requestURI)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	session, err := p.manager.Connect(ctx, &meta)
+	cancel()
 	if err != nil {
-		clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		log.Debugf("CONNECT to %s failed: %v", target, err)
+		status, detail := errorResponse(err)
+		writeHTTPError(clientConn, status, detail)
+		return
+	}
+	defer session.Close()
+
+	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
 		return
 	}
 
-	// Extract host and port
-	host := targetURL.Hostname()
-	port := targetURL.Port()
-	if port == "" {
-		if targetURL.Scheme == "https" {
-			port = "443"
+	// The client may have sent data right behind the request, which is
+	// still in the reader.
+	session.Relay(&peekConn{Conn: clientConn, r: reader})
+}
+
+// handleHTTP forwards one plain HTTP request and reports whether the
+// client connection can be reused.
+func (p *HTTPProxy) handleHTTP(ctx context.Context, clientConn net.Conn, reader *bufio.Reader, req *http.Request, meta tunnel.Metadata, transport *http.Transport) bool {
+	log.Debugf("HTTP %s request for: %s", req.Method, req.URL)
+
+	if req.URL.Host == "" || (req.URL.Scheme != "http" && req.URL.Scheme != "") {
+		writeHTTPError(clientConn, http.StatusBadRequest, "absolute http:// URL required")
+		return false
+	}
+
+	outReq := req.Clone(context.WithValue(ctx, metadataKey{}, meta))
+	outReq.RequestURI = ""
+	outReq.URL.Scheme = "http"
+	outReq.Close = false
+
+	var body *trackedBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &trackedBody{ReadCloser: req.Body}
+		outReq.Body = body
+	}
+
+	upgrade := upgradeType(req.Header)
+	removeHopHeaders(outReq.Header)
+	if upgrade != "" {
+		outReq.Header.Set("Connection", "Upgrade")
+		outReq.Header.Set("Upgrade", upgrade)
+	}
+	addVia(outReq.Header, req.ProtoMajor, req.ProtoMinor)
+	if p.opts.forwardedFor() {
+		if prior := outReq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			outReq.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+meta.ClientIP)
 		} else {
-			port = "80"
+			outReq.Header.Set("X-Forwarded-For", meta.ClientIP)
 		}
 	}
-	target := net.JoinHostPort(host, port)
 
-	// Reconstruct the request with relative URL
-	requestURL := requestURI
-	if targetURL.Opaque == "" && targetURL.Host != "" {
-		requestURL = targetURL.RequestURI()
+	resp, err := transport.RoundTrip(outReq)
+	if err != nil {
+		log.Debugf("Request to %s failed: %v", req.URL.Host, err)
+		status, detail := errorResponse(err)
+		writeHTTPError(clientConn, status, detail)
+		return false
 	}
+	defer resp.Body.Close()
 
-	// Read headers
-	var headers bytes.Buffer
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil || line == "\r\n" {
-			break
-		}
-		headers.WriteString(line)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.handleUpgrade(clientConn, reader, resp)
+		return false
 	}
 
-	// Check for HTTP/2
-	isHTTP2 := strings.Contains(headers.String(), "HTTP/2")
+	removeHopHeaders(resp.Header)
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor)
+
+	// Answer in the protocol version of the client; HTTP/1.0 clients get
+	// close-delimited bodies instead of chunked ones.
+	resp.Proto = req.Proto
+	resp.ProtoMajor, resp.ProtoMinor = req.ProtoMajor, req.ProtoMinor
+	// The connection can only carry another request if the upstream
+	// consumed the whole request body.
+	keepAlive := !req.Close && req.ProtoAtLeast(1, 1) && (body == nil || body.eof.Load())
+	resp.Close = !keepAlive
+
+	if err := resp.Write(clientConn); err != nil {
+		log.Debugf("Failed to write response: %v", err)
+		return false
+	}
+	return keepAlive
+}
 
-	// Connect to target through tunnel
-	targetConn, err := p.dialThroughTunnel(ctx, target)
-	if err != nil {
-		log.Errorf("Failed to connect to target: %v", err)
-		clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+// handleUpgrade relays a connection after a 101 Switching Protocols
+// response, e.g. for WebSockets.
+func (p *HTTPProxy) handleUpgrade(clientConn net.Conn, reader *bufio.Reader, resp *http.Response) {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		writeHTTPError(clientConn, http.StatusBadGateway, "upgrade not supported by upstream")
+		return
+	}
+
+	body := resp.Body
+	resp.Body = nil
+	if err := resp.Write(clientConn); err != nil {
 		return
 	}
-	defer targetConn.Close()
-
-	if isHTTP2 {
-		// For HTTP/2, just tunnel the raw connection
-		go func() {
-			io.Copy(targetConn, io.MultiReader(
-				bytes.NewBufferString(firstLine+"\r\n"),
-				&headers,
-				bytes.NewBufferString("\r\n"),
-				reader,
-			))
-		}()
-		io.Copy(clientConn, targetConn)
-	} else {
-		// Send request to target
-		fmt.Fprintf(targetConn, "%s %s %s\r\n", method, requestURL, proto)
-		io.Copy(targetConn, &headers)
-		targetConn.Write([]byte("\r\n"))
 
-		// Copy any remaining body
-		go io.Copy(targetConn, reader)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	body.Close()
+	clientConn.Close()
+	<-done
+}
 
-		// Copy response back to client
-		io.Copy(clientConn, targetConn)
+// trackedBody records whether a request body was read to the end.
+type trackedBody struct {
+	io.ReadCloser
+	eof atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof.Store(true)
 	}
+	return n, err
 }
 
-func (p *HTTPProxy) dialThroughTunnel(ctx context.Context, target string) (net.Conn, error) {
-	// Create a connection pair
-	clientConn, serverConn := net.Pipe()
+// Hop-by-hop headers, RFC 7230 section 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
 
-	go func() {
-		defer serverConn.Close()
-		if err := p.manager.Forward(serverConn, target); err != nil {
-			log.Debugf("Forward error: %v", err)
+// removeHopHeaders strips hop-by-hop headers, the headers listed in
+// Connection, and all Proxy-* headers.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
 		}
-	}()
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	for name := range h {
+		if strings.HasPrefix(name, "Proxy-") {
+			delete(h, name)
+		}
+	}
+}
+
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+func addVia(h http.Header, major, minor int) {
+	via := fmt.Sprintf("%d.%d xengate", major, minor)
+	if prior := h.Get("Via"); prior != "" {
+		via = prior + ", " + via
+	}
+	h.Set("Via", via)
+}
+
+// errorResponse maps a forwarding error to the response status and a
+// fixed detail; the error itself names servers and rules and is only
+// logged.
+func errorResponse(err error) (int, string) {
+	switch {
+	case tunnel.IsPolicyError(err):
+		return http.StatusForbidden, "destination not allowed"
+	case errors.Is(err, tunnel.ErrTimeout), isTimeout(err):
+		return http.StatusGatewayTimeout, "upstream timed out"
+	default:
+		return http.StatusBadGateway, "upstream unreachable"
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
 
-	return clientConn, nil
+// isMalformedRequest reports whether a http.ReadRequest error means the
+// client sent something that is not HTTP, rather than that the connection
+// was closed, reset, timed out or cut short.
+func isMalformedRequest(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed), errors.As(err, &netErr):
+		return false
+	}
+	return true
+}
+
+// writeHTTPError sends a short plain text error and closes the exchange.
+func writeHTTPError(conn net.Conn, status int, detail string) {
+	body := fmt.Sprintf("%d %s\n\n%s\n", status, http.StatusText(status), detail)
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	resp.Write(conn)
 }
 
 func (p *HTTPProxy) Stop() error {
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xengate/internal/models"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{
+		"Connection":          {"keep-alive, X-Hop"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Connection":    {"keep-alive"},
+		"Proxy-Authorization": {"Basic YWxpY2U6c2VjcmV0"},
+		"Proxy-Custom":        {"1"},
+		"Transfer-Encoding":   {"chunked"},
+		"X-Hop":               {"1"},
+		"X-End-To-End":        {"1"},
+	}
+	removeHopHeaders(h)
+
+	if len(h) != 1 || h.Get("X-End-To-End") != "1" {
+		t.Errorf("headers after removal = %v, want only X-End-To-End", h)
+	}
+}
+
+// serveHTTPProxy runs serveConn for a client of p and returns the client
+// end of the connection.
+func serveHTTPProxy(t *testing.T, p *HTTPProxy) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	conn := &remoteAddrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		p.serveConn(context.Background(), conn)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func TestHTTPProxyKeepAlive(t *testing.T) {
+	received := make(chan http.Header, 2)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Header().Set("Keep-Alive", "timeout=5")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer origin.Close()
+
+	manager := newTestManager(t)
+	manager.SetRoutingRules([]*models.RoutingRule{
+		{Title: "loopback", CIDRs: []string{"127.0.0.0/8"}, Action: models.RouteActionDirect},
+	})
+	p, err := NewHTTPProxy("http", "127.0.0.1", 0, manager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := serveHTTPProxy(t, p)
+	reader := bufio.NewReader(client)
+
+	for _, path := range []string{"/first", "/second"} {
+		req := "GET " + origin.URL + path + " HTTP/1.1\r\n" +
+			"Host: " + strings.TrimPrefix(origin.URL, "http://") + "\r\n" +
+			"Proxy-Connection: keep-alive\r\n" +
+			"Connection: X-Hop\r\n" +
+			"X-Hop: 1\r\n" +
+			"X-End-To-End: 1\r\n\r\n"
+		if _, err := io.WriteString(client, req); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("reading response to %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != path || resp.Close {
+			t.Errorf("response = %q, close %v; want %q on a kept connection", body, resp.Close, path)
+		}
+		if resp.Header.Get("Keep-Alive") != "" || !strings.HasSuffix(resp.Header.Get("Via"), "xengate") {
+			t.Errorf("response headers = %v, want hop-by-hop headers removed and Via added", resp.Header)
+		}
+
+		header := <-received
+		for _, name := range []string{"Proxy-Connection", "X-Hop"} {
+			if header.Get(name) != "" {
+				t.Errorf("origin received %s", name)
+			}
+		}
+		if header.Get("X-End-To-End") != "1" || header.Get("Via") != "1.1 xengate" {
+			t.Errorf("origin headers = %v, want X-End-To-End and Via", header)
+		}
+	}
+}
+
+func TestHTTPProxyErrorHidesDetails(t *testing.T) {
+	manager := newTestManager(t)
+	manager.SetRoutingRules([]*models.RoutingRule{
+		{Title: "secret rule", Domains: []string{"blocked.example"}, Action: models.RouteActionReject},
+	})
+	p, err := NewHTTPProxy("http", "127.0.0.1", 0, manager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"connect rejected", "CONNECT blocked.example:443 HTTP/1.1\r\nHost: blocked.example:443\r\n\r\n", http.StatusForbidden},
+		{"get rejected", "GET http://blocked.example/ HTTP/1.1\r\nHost: blocked.example\r\n\r\n", http.StatusForbidden},
+		{"no tunnel", "GET http://other.example/ HTTP/1.1\r\nHost: other.example\r\n\r\n", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := serveHTTPProxy(t, p)
+			io.WriteString(client, tt.request)
+			resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				t.Fatalf("reading response: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			for _, leak := range []string{"secret rule", "pool", "dial"} {
+				if strings.Contains(string(body), leak) {
+					t.Errorf("body %q mentions %q", body, leak)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MixedProxy serves SOCKS4, SOCKS5 and HTTP on a single port. The first
+// byte of each connection selects the handler: SOCKS starts with its
+// version number, anything else is treated as HTTP.
+type MixedProxy struct {
+	manager  *tunnel.Manager
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.RWMutex
+	closed   bool
+	ip       string
+	port     int16
+	opts     *Options
+	socks    *Socks5Server
+	http     *HTTPProxy
+}
+
+func NewMixedProxy(ip string, port int16, manager *tunnel.Manager, opts *Options) (*MixedProxy, error) {
+	socks, err := NewSocks5Server(ip, port, manager, opts)
+	if err != nil {
+		return nil, err
+	}
+	httpProxy, err := NewHTTPProxy("http", ip, port, manager, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MixedProxy{
+		manager: manager,
+		ip:      ip,
+		port:    port,
+		opts:    opts,
+		socks:   socks,
+		http:    httpProxy,
+	}, nil
+}
+
+func (p *MixedProxy) Start(ctx context.Context) error {
+	addr := listenAddr(p.ip, p.port)
+	listener, err := listen(addr, p.opts)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	p.listener = listener
+	log.Infof("Mixed proxy (SOCKS/HTTP) listening on %s", addr)
+
+	p.wg.Add(1)
+	go p.acceptLoop(ctx)
+
+	// Shutdown handler
+	go func() {
+		<-ctx.Done()
+		p.Stop()
+	}()
+
+	return nil
+}
+
+func (p *MixedProxy) acceptLoop(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			p.mu.RLock()
+			closed := p.closed
+			p.mu.RUnlock()
+			if closed {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			log.Errorf("Accept error: %v", err)
+			continue
+		}
+
+		release, ok := admit(p.manager, conn, "mixed")
+		if !ok {
+			continue
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer release()
+			p.handleConnection(ctx, conn)
+		}()
+	}
+}
+
+func (p *MixedProxy) handleConnection(ctx context.Context, conn net.Conn) {
+	defer func() {
+		p.wg.Done()
+		conn.Close()
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	pc := newPeekConn(conn)
+	first, err := pc.r.Peek(1)
+	if err != nil {
+		log.Debugf("Failed to read first byte: %v", err)
+		return
+	}
+
+	switch first[0] {
+	case socks4Version, socks5Version:
+		p.socks.serveConn(ctx, pc)
+	default:
+		p.http.serveConn(ctx, pc)
+	}
+}
+
+func (p *MixedProxy) Stop() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// peekConn is a net.Conn that allows looking at incoming data before a
+// handler consumes it.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPeekConn(conn net.Conn) *peekConn {
+	return &peekConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *peekConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
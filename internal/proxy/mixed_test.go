@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMixedProxyDetection(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(t *testing.T, client net.Conn)
+	}{
+		{"socks5", func(t *testing.T, client net.Conn) {
+			client.Write([]byte{socks5Version, 1, authNone})
+			if reply := readN(t, client, 2); reply[0] != socks5Version || reply[1] != authNone {
+				t.Errorf("greeting reply = %x, want 0500", reply)
+			}
+		}},
+		{"socks4", func(t *testing.T, client net.Conn) {
+			// Without a tunnel the request is refused, in SOCKS4.
+			client.Write(socks4Request(cmdConnect, 80, net.IPv4(192, 0, 2, 9), "", ""))
+			if reply := readN(t, client, 8); reply[0] != socks4ReplyVersion || reply[1] != socks4Rejected {
+				t.Errorf("reply = %x, want a SOCKS4 rejection", reply)
+			}
+		}},
+		{"http", func(t *testing.T, client net.Conn) {
+			client.Write([]byte("GET /proxy.pac HTTP/1.1\r\nHost: proxy\r\n\r\n"))
+			resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				t.Fatalf("reading response: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != pacContentType {
+				t.Errorf("response = %s %q, want the PAC script", resp.Status, resp.Header.Get("Content-Type"))
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewMixedProxy("127.0.0.1", 0, newTestManager(t), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, server := net.Pipe()
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			p.wg.Add(1)
+			go p.handleConnection(context.Background(), server)
+			tt.check(t, client)
+			client.Close()
+			p.wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,699 @@
+//go:build linux
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"xengate/internal/models"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tunTable holds the routes into the TUN. Sockets without directMark
+	// look it up after the specific routes of the main table, so direct
+	// routes, the SSH servers and the local networks keep their routes.
+	tunTable           = 5847
+	tunRulePriority    = 5847
+	tunNFTable         = "xengate"
+	networkJournalFile = "network.journal"
+	resolvConf         = "/etc/resolv.conf"
+)
+
+// tunSysctls enable forwarding for clients that use this host as their
+// gateway and raise the socket buffer limits. The limits are only tuning
+// and are not writable in every network namespace.
+var tunSysctls = []struct {
+	key, value string
+	optional   bool
+}{
+	{"net.ipv4.ip_forward", "1", false},
+	{"net.core.rmem_max", "26214400", true},
+	{"net.core.wmem_max", "26214400", true},
+}
+
+// NetworkManager sends the traffic of the system into the TUN interface
+// through netlink and nftables. The SSH servers keep host routes through
+// their original gateway so the tunnels do not loop into the TUN, and the
+// system resolver is pointed at the configured DNS servers. Every change
+// is written to a journal before the next one is made, so what a crashed
+// run left behind is rolled back the next time it starts.
+type NetworkManager struct {
+	config  *models.TunConfig
+	ip6     string
+	bypass  []string
+	journal *networkJournal
+	mu      sync.Mutex
+}
+
+// NewNetworkManager creates the manager of the interface described by
+// config, which must already be validated. bypass lists the host:port
+// addresses of the SSH servers; the journal is kept in stateDir, or only
+// in memory if it is empty.
+func NewNetworkManager(config *models.TunConfig, ip6 string, bypass []string, stateDir string) *NetworkManager {
+	return &NetworkManager{
+		config:  config,
+		ip6:     ip6,
+		bypass:  bypass,
+		journal: newNetworkJournal(stateDir),
+	}
+}
+
+// RecoverNetwork rolls back the network changes of a TUN mode that did not
+// shut down cleanly, as recorded in the journal in stateDir, and removes
+// the rules a crashed tproxy or redirect mode left behind.
+func RecoverNetwork(stateDir string) error {
+	if os.Geteuid() == 0 {
+		NewTProxyNetworkManager(nil, 0).Cleanup()
+		NewRedirectNetworkManager(0, nil).Cleanup()
+	}
+	return newNetworkJournal(stateDir).recover()
+}
+
+func (nm *NetworkManager) Setup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	log.Info("تنظیم پیکربندی شبکه...")
+
+	// بازگرداندن تغییرات اجرایی که درست بسته نشده
+	if err := nm.journal.recover(); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(nm.config.DeviceName)
+	if err != nil {
+		return fmt.Errorf("رابط %s پیدا نشد: %w", nm.config.DeviceName, err)
+	}
+	if err := nm.configureLink(link); err != nil {
+		return err
+	}
+
+	for _, s := range tunSysctls {
+		if err := nm.setSysctl(s.key, s.value); err != nil {
+			if s.optional {
+				log.WithError(err).Warnf("%s تغییر نکرد", s.key)
+				continue
+			}
+			return err
+		}
+	}
+
+	// مسیرهای پیش‌فرض پیش از افزودن مسیرهای TUN خوانده می‌شوند
+	defaults := defaultRoutes(link)
+
+	if err := nm.addBypassRoutes(link); err != nil {
+		return err
+	}
+	if err := nm.addExcludeRoutes(defaults); err != nil {
+		return err
+	}
+	if err := nm.addFirewall(); err != nil {
+		return err
+	}
+	if err := nm.addTunRoutes(link); err != nil {
+		return err
+	}
+	if err := nm.setDNS(); err != nil {
+		return err
+	}
+
+	log.Info("پیکربندی شبکه با موفقیت انجام شد")
+	return nil
+}
+
+func (nm *NetworkManager) Cleanup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	log.Info("پاکسازی پیکربندی شبکه...")
+	nm.journal.rollback()
+	log.Info("پاکسازی شبکه تکمیل شد")
+	return nil
+}
+
+// configureLink sets the addresses and MTU of the TUN interface and brings
+// it up. They go away with the interface, so they are not journaled.
+func (nm *NetworkManager) configureLink(link netlink.Link) error {
+	addr, err := netlink.ParseAddr(nm.config.Address)
+	if err != nil {
+		return fmt.Errorf("آدرس IP نامعتبر: %w", err)
+	}
+	if err := netlink.AddrAdd(link, addr); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("خطا در تنظیم آدرس IP: %w", err)
+	}
+	if err := netlink.LinkSetMTU(link, nm.config.MTU); err != nil {
+		return fmt.Errorf("خطا در تنظیم MTU: %w", err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("خطا در فعال کردن رابط: %w", err)
+	}
+
+	// IPv6 ممکن است در سیستم غیرفعال باشد، پس خطای آن مانع کار نمی‌شود
+	if nm.ip6 != "" {
+		addr6, err := netlink.ParseAddr(nm.ip6 + "/64")
+		if err == nil {
+			err = netlink.AddrAdd(link, addr6)
+		}
+		if err != nil && !errors.Is(err, unix.EEXIST) {
+			log.WithError(err).Warn("خطا در تنظیم آدرس IPv6")
+		}
+	}
+	return nil
+}
+
+// defaultRoutes returns the preferred default route of each address
+// family, ignoring routes through the TUN interface itself.
+func defaultRoutes(tun netlink.Link) []netlink.Route {
+	var defaults []netlink.Route
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteList(nil, family)
+		if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+			log.WithError(err).Warn("خطا در خواندن جدول مسیریابی")
+			continue
+		}
+
+		var best *netlink.Route
+		for i, r := range routes {
+			if r.Dst != nil {
+				if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+					continue
+				}
+			}
+			if r.Table != unix.RT_TABLE_MAIN || r.LinkIndex == 0 || r.LinkIndex == tun.Attrs().Index {
+				continue
+			}
+			if best == nil || r.Priority < best.Priority {
+				best = &routes[i]
+			}
+		}
+		if best != nil {
+			best.Family = family
+			defaults = append(defaults, *best)
+			if link, err := netlink.LinkByIndex(best.LinkIndex); err == nil {
+				log.Infof("مسیر پیش‌فرض: %s از %s", best.Gw, link.Attrs().Name)
+			}
+		}
+	}
+	return defaults
+}
+
+// addBypassRoutes pins every SSH server to the route it has now.
+func (nm *NetworkManager) addBypassRoutes(tun netlink.Link) error {
+	seen := make(map[string]bool)
+	for _, addr := range resolveBypass(nm.bypass) {
+		if seen[addr.IP.String()] {
+			continue
+		}
+		seen[addr.IP.String()] = true
+
+		routes, err := netlink.RouteGet(addr.IP)
+		if err != nil || len(routes) == 0 {
+			log.WithError(err).Warnf("مسیری به سرور %s پیدا نشد", addr.IP)
+			continue
+		}
+		current := routes[0]
+		if current.Type == unix.RTN_LOCAL || current.LinkIndex == tun.Attrs().Index {
+			continue
+		}
+
+		bits := 8 * net.IPv6len
+		if addr.IP.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		route := &netlink.Route{
+			LinkIndex: current.LinkIndex,
+			Dst:       &net.IPNet{IP: addr.IP, Mask: net.CIDRMask(bits, bits)},
+			Gw:        current.Gw,
+			Table:     unix.RT_TABLE_MAIN,
+		}
+		if err := nm.addRoute(route); err != nil {
+			return fmt.Errorf("خطا در افزودن مسیر سرور %s: %w", addr.IP, err)
+		}
+		log.Debugf("مسیر سرور اضافه شد: %s", route.Dst)
+	}
+	return nil
+}
+
+// addExcludeRoutes sends the excluded networks through the default route
+// of their family.
+func (nm *NetworkManager) addExcludeRoutes(defaults []netlink.Route) error {
+	for _, cidr := range nm.config.ExcludeRoutes {
+		_, dst, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("مسیر نامعتبر %s: %w", cidr, err)
+		}
+		family := netlink.FAMILY_V4
+		if dst.IP.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+
+		var gateway *netlink.Route
+		for i := range defaults {
+			if defaults[i].Family == family {
+				gateway = &defaults[i]
+			}
+		}
+		if gateway == nil {
+			log.Warnf("مسیر پیش‌فرضی برای %s پیدا نشد", cidr)
+			continue
+		}
+
+		route := &netlink.Route{
+			LinkIndex: gateway.LinkIndex,
+			Dst:       dst,
+			Gw:        gateway.Gw,
+			Table:     unix.RT_TABLE_MAIN,
+		}
+		if err := nm.addRoute(route); err != nil {
+			return fmt.Errorf("خطا در افزودن مسیر %s: %w", cidr, err)
+		}
+		log.Debugf("مسیر مستثنی اضافه شد: %s", cidr)
+	}
+	return nil
+}
+
+// addFirewall accepts the traffic of the TUN interface. The flows end in
+// the userspace stack, so nothing needs to be masqueraded.
+func (nm *NetworkManager) addFirewall() error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("خطا در اتصال به nftables: %w", err)
+	}
+
+	table := conn.AddTable(&nftables.Table{Family: nftables.TableFamilyINet, Name: tunNFTable})
+	input := conn.AddChain(&nftables.Chain{
+		Name:     "input",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	})
+	forward := conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+	})
+	conn.AddRule(&nftables.Rule{Table: table, Chain: input, Exprs: acceptInterface(expr.MetaKeyIIFNAME, nm.config.DeviceName)})
+	conn.AddRule(&nftables.Rule{Table: table, Chain: forward, Exprs: acceptInterface(expr.MetaKeyIIFNAME, nm.config.DeviceName)})
+	conn.AddRule(&nftables.Rule{Table: table, Chain: forward, Exprs: acceptInterface(expr.MetaKeyOIFNAME, nm.config.DeviceName)})
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("خطا در تنظیم قوانین nftables: %w", err)
+	}
+	if err := nm.journal.record(journalEntry{Kind: journalNFTable, Name: tunNFTable}); err != nil {
+		return err
+	}
+	log.Debugf("جدول nftables %s اضافه شد", tunNFTable)
+	return nil
+}
+
+func acceptInterface(key expr.MetaKey, iface string) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte(iface + "\x00")},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+}
+
+// addTunRoutes fills tunTable with the included networks, or the whole
+// address space, and adds the rules that use it. The rule before it
+// consults the main table without its default routes, so its specific
+// routes still win.
+func (nm *NetworkManager) addTunRoutes(link netlink.Link) error {
+	include := nm.config.IncludeRoutes
+	if len(include) == 0 {
+		include = []string{"0.0.0.0/0", "::/0"}
+	} else if nm.config.FakeIP {
+		// Fake addresses are only meaningful to the TUN stack.
+		include = append(include[:len(include):len(include)], nm.config.FakeIPRange)
+	}
+
+	families := make(map[int]bool)
+	for _, cidr := range include {
+		_, dst, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("مسیر نامعتبر %s: %w", cidr, err)
+		}
+		family := netlink.FAMILY_V4
+		if dst.IP.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		if family == netlink.FAMILY_V6 && nm.ip6 == "" {
+			continue
+		}
+
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Table: tunTable}
+		if err := nm.addRoute(route); err != nil {
+			if family == netlink.FAMILY_V6 {
+				log.WithError(err).Warnf("خطا در تنظیم مسیر IPv6: %s", cidr)
+				continue
+			}
+			return fmt.Errorf("خطا در تنظیم مسیریابی: %w", err)
+		}
+		families[family] = true
+		log.Debugf("مسیر اضافه شد: %s dev %s", cidr, nm.config.DeviceName)
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if !families[family] {
+			continue
+		}
+
+		main := netlink.NewRule()
+		main.Family = family
+		main.Table = unix.RT_TABLE_MAIN
+		main.SuppressPrefixlen = 0
+		main.Priority = tunRulePriority - 1
+
+		tun := netlink.NewRule()
+		tun.Family = family
+		tun.Mark = directMark
+		tun.Invert = true
+		tun.Table = tunTable
+		tun.Priority = tunRulePriority
+
+		for _, rule := range []*netlink.Rule{main, tun} {
+			if err := nm.addRule(rule); err != nil {
+				if family == netlink.FAMILY_V6 {
+					log.WithError(err).Warn("خطا در تنظیم قانون مسیریابی IPv6")
+					break
+				}
+				return fmt.Errorf("خطا در افزودن قانون مسیریابی: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// setDNS points the system resolver at the configured DNS servers, or at
+// the fake IP responder on the gateway. The original resolv.conf, or the
+// symlink it was, is journaled first.
+func (nm *NetworkManager) setDNS() error {
+	servers := nm.config.DNSServers
+	if nm.config.FakeIP {
+		servers = []string{nm.config.Gateway}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	entry := journalEntry{Kind: journalResolvConf}
+	if target, err := os.Readlink(resolvConf); err == nil {
+		entry.Link = target
+	} else {
+		data, err := os.ReadFile(resolvConf)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("خطا در خواندن %s: %w", resolvConf, err)
+		}
+		entry.Value = string(data)
+	}
+	if err := nm.journal.record(entry); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by XenGate, restored when the TUN mode stops\n")
+	for _, server := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	if err := replaceFile(resolvConf, func(tmp string) error {
+		return os.WriteFile(tmp, []byte(b.String()), 0644)
+	}); err != nil {
+		return fmt.Errorf("خطا در تنظیم DNS: %w", err)
+	}
+	log.Infof("DNS سیستم: %s", strings.Join(servers, ", "))
+	return nil
+}
+
+// replaceFile creates a temporary file next to path with create and renames
+// it over path, which also replaces a symlink rather than its target.
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := path + ".xengate"
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// addRoute adds a route and journals it. A route that already exists is
+// not ours, so it is left out of the journal.
+func (nm *NetworkManager) addRoute(route *netlink.Route) error {
+	if err := netlink.RouteAdd(route); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return nil
+		}
+		return err
+	}
+
+	entry := journalEntry{Kind: journalRoute, Dst: route.Dst.String(), Table: route.Table}
+	if route.Gw != nil {
+		entry.Gw = route.Gw.String()
+	}
+	if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+		entry.Link = link.Attrs().Name
+	}
+	return nm.journal.record(entry)
+}
+
+func (nm *NetworkManager) addRule(rule *netlink.Rule) error {
+	if err := netlink.RuleAdd(rule); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return nil
+		}
+		return err
+	}
+	return nm.journal.record(journalEntry{
+		Kind:     journalRule,
+		Family:   rule.Family,
+		Mark:     rule.Mark,
+		Invert:   rule.Invert,
+		Table:    rule.Table,
+		Priority: rule.Priority,
+	})
+}
+
+// setSysctl changes key and journals its original value.
+func (nm *NetworkManager) setSysctl(key, value string) error {
+	orig, err := readSysctl(key)
+	if err != nil {
+		return fmt.Errorf("خطا در خواندن %s: %w", key, err)
+	}
+	if orig == value {
+		return nil
+	}
+
+	log.Debugf("تنظیم sysctl %s = %s", key, value)
+	if err := writeSysctl(key, value); err != nil {
+		return fmt.Errorf("خطا در تنظیم sysctl: %w", err)
+	}
+	return nm.journal.record(journalEntry{Kind: journalSysctl, Key: key, Value: orig})
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
+
+func readSysctl(key string) (string, error) {
+	data, err := os.ReadFile(sysctlPath(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeSysctl(key, value string) error {
+	return os.WriteFile(sysctlPath(key), []byte(value), 0644)
+}
+
+const (
+	journalRoute   = "route"
+	journalRule    = "rule"
+	journalSysctl  = "sysctl"
+	journalNFTable = "nftables"
+	// journalResolvConf holds the original resolv.conf in Value, or its
+	// symlink target in Link.
+	journalResolvConf = "resolv.conf"
+)
+
+// journalEntry is one change made by NetworkManager, with what is needed
+// to undo it.
+type journalEntry struct {
+	Kind     string `json:"kind"`
+	Link     string `json:"link,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Gw       string `json:"gw,omitempty"`
+	Table    int    `json:"table,omitempty"`
+	Family   int    `json:"family,omitempty"`
+	Mark     uint32 `json:"mark,omitempty"`
+	Invert   bool   `json:"invert,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+func (e journalEntry) undo() error {
+	switch e.Kind {
+	case journalSysctl:
+		return writeSysctl(e.Key, e.Value)
+
+	case journalRoute:
+		route := &netlink.Route{Gw: net.ParseIP(e.Gw), Table: e.Table}
+		if _, dst, err := net.ParseCIDR(e.Dst); err == nil {
+			route.Dst = dst
+		}
+		if e.Link != "" {
+			link, err := netlink.LinkByName(e.Link)
+			if err != nil {
+				// رابط حذف شده و مسیرهایش هم با آن رفته‌اند
+				return nil
+			}
+			route.LinkIndex = link.Attrs().Index
+		}
+		return ignoreMissing(netlink.RouteDel(route))
+
+	case journalRule:
+		rule := netlink.NewRule()
+		rule.Family = e.Family
+		rule.Mark = e.Mark
+		rule.Invert = e.Invert
+		rule.Table = e.Table
+		rule.Priority = e.Priority
+		return ignoreMissing(netlink.RuleDel(rule))
+
+	case journalNFTable:
+		conn, err := nftables.New()
+		if err != nil {
+			return err
+		}
+		conn.DelTable(&nftables.Table{Family: nftables.TableFamilyINet, Name: e.Name})
+		return ignoreMissing(conn.Flush())
+
+	case journalResolvConf:
+		if e.Link != "" {
+			return replaceFile(resolvConf, func(tmp string) error {
+				return os.Symlink(e.Link, tmp)
+			})
+		}
+		return replaceFile(resolvConf, func(tmp string) error {
+			return os.WriteFile(tmp, []byte(e.Value), 0644)
+		})
+	}
+	return fmt.Errorf("unknown journal entry %q", e.Kind)
+}
+
+func ignoreMissing(err error) error {
+	if errors.Is(err, unix.ESRCH) || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// networkJournal records applied changes, one JSON object per line. Each
+// line is synced before the next change is made.
+type networkJournal struct {
+	path    string
+	entries []journalEntry
+}
+
+func newNetworkJournal(dir string) *networkJournal {
+	j := &networkJournal{}
+	if dir != "" {
+		j.path = filepath.Join(dir, networkJournalFile)
+	}
+	return j
+}
+
+func (j *networkJournal) record(e journalEntry) error {
+	j.entries = append(j.entries, e)
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("خطا در نوشتن دفترچه شبکه: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("خطا در نوشتن دفترچه شبکه: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("خطا در نوشتن دفترچه شبکه: %w", err)
+	}
+	return f.Sync()
+}
+
+// recover rolls back the changes journaled by an earlier run.
+func (j *networkJournal) recover() error {
+	if j.path == "" {
+		return nil
+	}
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("خطا در خواندن دفترچه شبکه: %w", err)
+	}
+
+	var leftover []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// خط آخر ممکن است در لحظه خرابی نیمه‌کاره مانده باشد
+			continue
+		}
+		leftover = append(leftover, e)
+	}
+	f.Close()
+
+	log.Warnf("بازگرداندن %d تغییر شبکه از اجرای قبلی", len(leftover))
+	j.entries = append(leftover, j.entries...)
+	j.rollback()
+	return nil
+}
+
+// rollback undoes the journaled changes in reverse order and removes the
+// journal.
+func (j *networkJournal) rollback() {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		if err := j.entries[i].undo(); err != nil {
+			log.WithError(err).Warnf("خطا در بازگرداندن تغییر %s", j.entries[i].Kind)
+		}
+	}
+	j.entries = nil
+
+	if j.path != "" {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).Warn("خطا در حذف دفترچه شبکه")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xengate/internal/models"
+)
+
+const pacContentType = "application/x-ns-proxy-autoconfig"
+
+func isPACRequest(req *http.Request) bool {
+	if req.URL.Host != "" || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return false
+	}
+	return req.URL.Path == "/proxy.pac" || req.URL.Path == "/wpad.dat"
+}
+
+// servePAC answers a PAC or WPAD request. The script is generated from
+// the current listeners and routing rules on every request, so devices
+// pick up rule changes the next time they fetch it.
+func (p *HTTPProxy) servePAC(conn net.Conn, req *http.Request) bool {
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	script := GeneratePAC(p.opts.listeners(), p.manager.GetRoutingRules(), host)
+
+	keepAlive := !req.Close && req.ProtoAtLeast(1, 1)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header: http.Header{
+			"Content-Type":  {pacContentType},
+			"Cache-Control": {"no-cache"},
+		},
+		Body:          http.NoBody,
+		ContentLength: int64(len(script)),
+		Close:         !keepAlive,
+	}
+	if req.Method != http.MethodHead {
+		resp.Body = io.NopCloser(strings.NewReader(script))
+	}
+	if err := resp.Write(conn); err != nil {
+		return false
+	}
+	return keepAlive
+}
+
+// GeneratePAC builds a proxy auto-config script. Routing rules are
+// evaluated in order like the router does, for the inbounds of the
+// advertised proxies: direct rules that apply to all of them bypass the
+// proxy, everything else goes through the listeners. The script stops at
+// the first rule it cannot express, leaving that rule and the ones after
+// it to the router. Listeners bound to all interfaces are advertised as
+// host.
+func GeneratePAC(listeners []*models.ListenerConfig, rules []*models.RoutingRule, host string) string {
+	advertised := pacProxies(listeners, host)
+	proxies := pacResult(advertised)
+
+	var b strings.Builder
+	b.WriteString("// Generated by XenGate\n")
+	b.WriteString("function portOf(url) {\n")
+	b.WriteString("  var m = url.match(/^[a-z]+:\\/\\/(?:[^@\\/]*@)?(?:\\[[^\\]]*\\]|[^:\\/]*)(?::(\\d+))?/i);\n")
+	b.WriteString("  if (m && m[1]) return parseInt(m[1], 10);\n")
+	b.WriteString("  return url.substring(0, 6).toLowerCase() == \"https:\" ? 443 : 80;\n")
+	b.WriteString("}\n\n")
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  host = host.toLowerCase();\n")
+	b.WriteString("  if (isPlainHostName(host) || host == \"localhost\" || host == \"127.0.0.1\") return \"DIRECT\";\n")
+	b.WriteString("  var port = portOf(url);\n")
+
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		applies := 0
+		for _, proxy := range advertised {
+			if rule.Inbound == "" || rule.Inbound == proxy.inbound {
+				applies++
+			}
+		}
+		if applies == 0 {
+			continue
+		}
+		cond, ok := pacCondition(rule)
+		if !ok {
+			fmt.Fprintf(&b, "  // %s: routed by the proxy\n", strings.ReplaceAll(rule.Title, "\n", " "))
+			break
+		}
+		if cond == "" {
+			continue
+		}
+
+		// Flows through the other proxies fall through to later rules,
+		// which only the router can still tell apart.
+		result := proxies
+		if rule.Action == models.RouteActionDirect && applies == len(advertised) {
+			result = "DIRECT"
+		}
+		fmt.Fprintf(&b, "  // %s\n", strings.ReplaceAll(rule.Title, "\n", " "))
+		fmt.Fprintf(&b, "  if (%s) return %q;\n", cond, result)
+	}
+
+	fmt.Fprintf(&b, "  return %q;\n", proxies)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// pacProxy is a proxy advertised in the script, with the inbound name the
+// router sees its flows under. Mixed listeners tag each flow with the
+// protocol the client spoke.
+type pacProxy struct {
+	inbound string
+	entry   string
+}
+
+func pacProxies(listeners []*models.ListenerConfig, host string) []pacProxy {
+	var plain, socks, secure []pacProxy
+	for _, l := range listeners {
+		addr := l.Address
+		if addr == "" || net.ParseIP(addr).IsUnspecified() {
+			addr = host
+		}
+		hostPort := net.JoinHostPort(addr, strconv.Itoa(int(l.Port)))
+		socksProxies := []pacProxy{{"socks5", "SOCKS5 " + hostPort}, {"socks5", "SOCKS " + hostPort}}
+
+		switch {
+		case l.Mode == "https":
+			secure = append(secure, pacProxy{"https", "HTTPS " + hostPort})
+		case l.TLS != nil && (l.Mode == "http" || l.Mode == "mixed"):
+			secure = append(secure, pacProxy{"http", "HTTPS " + hostPort})
+		case l.Mode == "http":
+			plain = append(plain, pacProxy{"http", "PROXY " + hostPort})
+		case l.Mode == "socks5" && l.TLS == nil:
+			socks = append(socks, socksProxies...)
+		case l.Mode == "mixed":
+			plain = append(plain, pacProxy{"http", "PROXY " + hostPort})
+			socks = append(socks, socksProxies...)
+		}
+	}
+	return append(append(plain, socks...), secure...)
+}
+
+// pacResult is the return value of the script for the proxies.
+func pacResult(proxies []pacProxy) string {
+	if len(proxies) == 0 {
+		return "DIRECT"
+	}
+	entries := make([]string, len(proxies))
+	for i, proxy := range proxies {
+		entries[i] = proxy.entry
+	}
+	return strings.Join(entries, "; ")
+}
+
+// pacCondition translates the match part of a routing rule into a PAC
+// expression, or "" if the rule cannot match anything. ok is false if the
+// rule matches IPv6 ranges, which PAC scripts cannot test.
+func pacCondition(rule *models.RoutingRule) (cond string, ok bool) {
+	var parts []string
+
+	if len(rule.Domains) > 0 {
+		var domains []string
+		for _, d := range rule.Domains {
+			d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+			if d == "" {
+				continue
+			}
+			domains = append(domains, fmt.Sprintf("host == %q || dnsDomainIs(host, %q)", d, "."+d))
+		}
+		if len(domains) == 0 {
+			return "", true
+		}
+		parts = append(parts, "("+strings.Join(domains, " || ")+")")
+	}
+
+	if len(rule.CIDRs) > 0 {
+		var nets []string
+		for _, cidr := range rule.CIDRs {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				// The router ignores invalid ranges too.
+				continue
+			}
+			if ipNet.IP.To4() == nil {
+				// isInNet only knows IPv4
+				return "", false
+			}
+			nets = append(nets, fmt.Sprintf("isInNet(host, %q, %q)", ipNet.IP.String(), net.IP(ipNet.Mask).String()))
+		}
+		if len(nets) == 0 {
+			return "", true
+		}
+		parts = append(parts, "("+strings.Join(nets, " || ")+")")
+	}
+
+	if len(rule.Ports) > 0 {
+		var ports []string
+		for _, port := range rule.Ports {
+			ports = append(ports, fmt.Sprintf("port == %d", port))
+		}
+		parts = append(parts, "("+strings.Join(ports, " || ")+")")
+	}
+
+	if len(parts) == 0 {
+		return "true", true
+	}
+	return strings.Join(parts, " && "), true
+}
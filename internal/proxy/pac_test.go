@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"xengate/internal/models"
+)
+
+func TestPACCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.RoutingRule
+		want string
+		ok   bool
+	}{
+		{
+			name: "match all",
+			want: "true",
+			ok:   true,
+		},
+		{
+			name: "domains",
+			rule: models.RoutingRule{Domains: []string{"Example.com.", " "}},
+			want: `(host == "example.com" || dnsDomainIs(host, ".example.com"))`,
+			ok:   true,
+		},
+		{
+			name: "ipv4 ranges and ports",
+			rule: models.RoutingRule{CIDRs: []string{"10.0.0.0/8", "bogus"}, Ports: []int{80, 443}},
+			want: `(isInNet(host, "10.0.0.0", "255.0.0.0")) && (port == 80 || port == 443)`,
+			ok:   true,
+		},
+		{
+			name: "empty domains never match",
+			rule: models.RoutingRule{Domains: []string{"."}},
+			ok:   true,
+		},
+		{
+			name: "invalid ranges never match",
+			rule: models.RoutingRule{CIDRs: []string{"bogus"}},
+			ok:   true,
+		},
+		{
+			name: "ipv6 range",
+			rule: models.RoutingRule{CIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}},
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pacCondition(&tt.rule)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("pacCondition() = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPACProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners []*models.ListenerConfig
+		want      string
+	}{
+		{"none", nil, "DIRECT"},
+		{
+			name: "order",
+			listeners: []*models.ListenerConfig{
+				{Mode: "https", Port: 8443, TLS: &models.TLSConfig{}},
+				{Mode: "socks5", Address: "192.0.2.10", Port: 1080},
+				{Mode: "http", Address: "0.0.0.0", Port: 8080},
+				{Mode: "redirect", Port: 12345},
+			},
+			want: "PROXY 192.0.2.1:8080; SOCKS5 192.0.2.10:1080; SOCKS 192.0.2.10:1080; HTTPS 192.0.2.1:8443",
+		},
+		{
+			name:      "mixed",
+			listeners: []*models.ListenerConfig{{Mode: "mixed", Port: 7890}},
+			want:      "PROXY 192.0.2.1:7890; SOCKS5 192.0.2.1:7890; SOCKS 192.0.2.1:7890",
+		},
+		{
+			name:      "socks over tls",
+			listeners: []*models.ListenerConfig{{Mode: "socks5", Port: 1080, TLS: &models.TLSConfig{}}},
+			want:      "DIRECT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pacResult(pacProxies(tt.listeners, "192.0.2.1")); got != tt.want {
+				t.Errorf("pacResult() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratePACRuleOrder(t *testing.T) {
+	listeners := []*models.ListenerConfig{{Mode: "http", Port: 8080}}
+	rules := []*models.RoutingRule{
+		{Title: "lan", CIDRs: []string{"192.168.0.0/16"}, Action: models.RouteActionDirect},
+		{Title: "socks only", Inbound: "socks5", Domains: []string{"socks.example"}, Action: models.RouteActionDirect},
+		{Title: "tunneled", Domains: []string{"tunneled.example"}, Action: models.RouteActionTunnel},
+		{Title: "v6", CIDRs: []string{"2001:db8::/32"}, Action: models.RouteActionReject},
+		{Title: "after v6", Domains: []string{"direct.example"}, Action: models.RouteActionDirect},
+	}
+	script := GeneratePAC(listeners, rules, "192.0.2.1")
+
+	for _, want := range []string{
+		`if ((isInNet(host, "192.168.0.0", "255.255.0.0"))) return "DIRECT";`,
+		`if ((host == "tunneled.example" || dnsDomainIs(host, ".tunneled.example"))) return "PROXY 192.0.2.1:8080";`,
+		"// v6: routed by the proxy",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %s:\n%s", want, script)
+		}
+	}
+	for _, unwanted := range []string{"socks.example", "direct.example"} {
+		if strings.Contains(script, unwanted) {
+			t.Errorf("script contains %s:\n%s", unwanted, script)
+		}
+	}
+	if !strings.HasSuffix(script, "  return \"PROXY 192.0.2.1:8080\";\n}\n") {
+		t.Errorf("script does not end with the proxy fallback:\n%s", script)
+	}
+	if strings.Index(script, "192.168.0.0") > strings.Index(script, "tunneled.example") {
+		t.Error("rules are out of order")
+	}
+}
+
+func TestGeneratePACInbounds(t *testing.T) {
+	rule := func(inbound string) []*models.RoutingRule {
+		return []*models.RoutingRule{
+			{Title: "scoped", Inbound: inbound, Domains: []string{"scoped.example"}, Action: models.RouteActionDirect},
+		}
+	}
+	const scoped = `(host == "scoped.example" || dnsDomainIs(host, ".scoped.example"))`
+
+	tests := []struct {
+		name      string
+		listeners []*models.ListenerConfig
+		rules     []*models.RoutingRule
+		want      string // result for scoped.example, "" if the rule is left out
+	}{
+		{"socks", []*models.ListenerConfig{{Mode: "socks5", Port: 1080}}, rule("socks5"), "DIRECT"},
+		{"https", []*models.ListenerConfig{{Mode: "https", Port: 8443, TLS: &models.TLSConfig{}}}, rule("https"), "DIRECT"},
+		{"http over tls", []*models.ListenerConfig{{Mode: "http", Port: 8443, TLS: &models.TLSConfig{}}}, rule("http"), "DIRECT"},
+		{"other inbound", []*models.ListenerConfig{{Mode: "http", Port: 8080}}, rule("socks5"), ""},
+		{"mixed is not an inbound", []*models.ListenerConfig{{Mode: "mixed", Port: 7890}}, rule("mixed"), ""},
+		{
+			name:      "part of mixed",
+			listeners: []*models.ListenerConfig{{Mode: "mixed", Port: 7890}},
+			rules:     rule("socks5"),
+			want:      "PROXY 192.0.2.1:7890; SOCKS5 192.0.2.1:7890; SOCKS 192.0.2.1:7890",
+		},
+		{
+			name:      "all of mixed",
+			listeners: []*models.ListenerConfig{{Mode: "mixed", Port: 7890}},
+			rules:     rule(""),
+			want:      "DIRECT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := GeneratePAC(tt.listeners, tt.rules, "192.0.2.1")
+			if tt.want == "" {
+				if strings.Contains(script, "scoped.example") {
+					t.Errorf("script contains the rule:\n%s", script)
+				}
+				return
+			}
+			if want := "if (" + scoped + ") return \"" + tt.want + "\";"; !strings.Contains(script, want) {
+				t.Errorf("script lacks %s:\n%s", want, script)
+			}
+		})
+	}
+}
@@ -2,9 +2,16 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strconv"
 
+	"xengate/internal/models"
+	"xengate/internal/security"
 	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Proxy interface {
@@ -12,14 +19,159 @@ type Proxy interface {
 	Stop() error
 }
 
-func NewProxy(mode string, ip string, port int16, manager *tunnel.Manager) (Proxy, error) {
+// Options configures a listener. A nil *Options admits anonymous clients
+// and uses the defaults.
+type Options struct {
+	// Credentials authenticates clients; without it anonymous clients
+	// are admitted.
+	Credentials security.CredentialStore
+	// AllowAnonymous admits clients without credentials even when
+	// Credentials is set.
+	AllowAnonymous bool
+	// TLSConfig makes the listener only accept TLS connections.
+	TLSConfig *tls.Config
+	// XForwardedFor makes the HTTP proxy pass the client address on to
+	// origin servers.
+	XForwardedFor bool
+	// Listeners are the listeners advertised in the PAC file served by
+	// HTTP listeners.
+	Listeners []*models.ListenerConfig
+	// Bypass lists host:port addresses that transparent listeners never
+	// capture, normally the SSH servers.
+	Bypass []string
+	// StateDir holds state that has to survive a crash, such as the
+	// network journal of the TUN mode.
+	StateDir string
+	// Tun configures the TUN inbound.
+	Tun *models.TunConfig
+}
+
+func (o *Options) credentials() security.CredentialStore {
+	if o == nil {
+		return nil
+	}
+	return o.Credentials
+}
+
+func (o *Options) allowAnonymous() bool {
+	return o == nil || o.Credentials == nil || o.AllowAnonymous
+}
+
+func (o *Options) tlsConfig() *tls.Config {
+	if o == nil {
+		return nil
+	}
+	return o.TLSConfig
+}
+
+func (o *Options) listeners() []*models.ListenerConfig {
+	if o == nil {
+		return nil
+	}
+	return o.Listeners
+}
+
+func (o *Options) bypass() []string {
+	if o == nil {
+		return nil
+	}
+	return o.Bypass
+}
+
+func (o *Options) stateDir() string {
+	if o == nil {
+		return ""
+	}
+	return o.StateDir
+}
+
+func (o *Options) tun() *models.TunConfig {
+	if o == nil {
+		return nil
+	}
+	return o.Tun
+}
+
+func (o *Options) forwardedFor() bool {
+	return o != nil && o.XForwardedFor
+}
+
+// listenAddr joins a listener address and port. An empty or unspecified
+// address listens on all IPv4 and IPv6 addresses.
+func listenAddr(ip string, port int16) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsUnspecified() {
+		ip = ""
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// listen opens the TCP listener for addr, wrapped in TLS when configured.
+func listen(addr string, opts *Options) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig := opts.tlsConfig(); tlsConfig != nil {
+		return tls.NewListener(listener, tlsConfig), nil
+	}
+	return listener, nil
+}
+
+// admit runs the admission checks of the manager on a connection an
+// inbound has just accepted. Denied connections are closed before any byte
+// is exchanged; release has to be called once an admitted connection is
+// done.
+func admit(manager *tunnel.Manager, conn net.Conn, inbound string) (release func(), ok bool) {
+	release, err := manager.AdmitConnection(tunnel.ClientIP(conn.RemoteAddr()), inbound)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"client":  conn.RemoteAddr(),
+			"inbound": inbound,
+		}).WithError(err).Debug("Connection refused at accept")
+		conn.Close()
+		return nil, false
+	}
+	return release, true
+}
+
+// peerCertUser completes the TLS handshake of conn and returns the common name
+// of the verified client certificate, or "" for plain connections and
+// clients without a certificate.
+func peerCertUser(conn net.Conn) (string, error) {
+	if pc, ok := conn.(*peekConn); ok {
+		conn = pc.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	return state.PeerCertificates[0].Subject.CommonName, nil
+}
+
+func NewProxy(mode string, ip string, port int16, manager *tunnel.Manager, opts *Options) (Proxy, error) {
 	switch mode {
 	case "socks5":
-		return NewSocks5Server(ip, port, manager)
+		return NewSocks5Server(ip, port, manager, opts)
 	case "http", "https":
-		return NewHTTPProxy(mode, ip, port, manager), nil
+		return NewHTTPProxy(mode, ip, port, manager, opts)
+	case "mixed":
+		return NewMixedProxy(ip, port, manager, opts)
+	case "redirect":
+		return NewRedirectProxy(ip, port, manager, opts)
+	case "tproxy":
+		return NewTProxy(ip, port, manager, opts)
+	case "dns":
+		return NewDNSServer(ip, port, manager)
 	case "tuntap":
-		return NewTunTapProxy("tun0", ip, port, manager)
+		return NewTunTapProxy(opts.tun(), manager, opts)
 	default:
 		return nil, fmt.Errorf("unsupported proxy mode: %s", mode)
 	}
@@ -0,0 +1,339 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	soOriginalDst     = 80 // SO_ORIGINAL_DST, linux/netfilter_ipv4.h
+	ip6tSoOriginalDst = 80 // IP6T_SO_ORIGINAL_DST, linux/netfilter_ipv6/ip6_tables.h
+
+	redirectChain = "XENGATE_REDIRECT"
+)
+
+// RedirectProxy is a transparent TCP proxy for connections redirected to
+// it by the nat table. The original destination is read back from
+// conntrack with SO_ORIGINAL_DST.
+type RedirectProxy struct {
+	manager       *tunnel.Manager
+	listener      net.Listener
+	netManager    *RedirectNetworkManager
+	releaseDialer func()
+	wg            sync.WaitGroup
+	mu            sync.RWMutex
+	closed        bool
+	ip            string
+	port          int16
+}
+
+func NewRedirectProxy(ip string, port int16, manager *tunnel.Manager, opts *Options) (*RedirectProxy, error) {
+	if os.Geteuid() != 0 {
+		return nil, errors.New("redirect mode requires root privileges")
+	}
+	if port == 0 {
+		return nil, errors.New("redirect mode requires a fixed port")
+	}
+
+	return &RedirectProxy{
+		manager:    manager,
+		ip:         ip,
+		port:       port,
+		netManager: NewRedirectNetworkManager(port, opts.bypass()),
+	}, nil
+}
+
+func (p *RedirectProxy) Start(ctx context.Context) error {
+	// REDIRECT rewrites to the address of the incoming interface, so
+	// this is normally left unspecified to listen on all of them.
+	addr := listenAddr(p.ip, p.port)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	p.listener = listener
+
+	if err := p.netManager.Setup(); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set up redirect rules: %w", err)
+	}
+	p.releaseDialer = p.manager.UseDirectDialer(&net.Dialer{Control: markSocket})
+
+	log.Infof("Redirect proxy listening on %s", addr)
+
+	p.wg.Add(1)
+	go p.acceptLoop()
+
+	// Shutdown handler
+	go func() {
+		<-ctx.Done()
+		p.Stop()
+	}()
+
+	return nil
+}
+
+func (p *RedirectProxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			p.mu.RLock()
+			closed := p.closed
+			p.mu.RUnlock()
+			if closed {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			log.Errorf("Accept error: %v", err)
+			continue
+		}
+
+		release, ok := admit(p.manager, conn, "redirect")
+		if !ok {
+			continue
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer release()
+			p.handleConnection(conn)
+		}()
+	}
+}
+
+func (p *RedirectProxy) handleConnection(conn net.Conn) {
+	defer p.wg.Done()
+
+	target, err := originalDst(conn.(*net.TCPConn))
+	if err != nil {
+		log.WithError(err).Debug("Failed to get original destination")
+		conn.Close()
+		return
+	}
+
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if target.Port == local.Port && target.IP.Equal(local.IP) {
+		// Connected to the listener itself, not redirected.
+		log.WithField("client", conn.RemoteAddr()).Debug("Rejected connection without redirect")
+		conn.Close()
+		return
+	}
+
+	meta := &tunnel.Metadata{
+		Inbound:  "redirect",
+		ClientIP: tunnel.ClientIP(conn.RemoteAddr()),
+		Target:   target.String(),
+	}
+	if err := p.manager.ForwardMetadata(conn, meta); err != nil {
+		log.WithFields(log.Fields{
+			"client": conn.RemoteAddr(),
+			"target": meta.Target,
+		}).WithError(err).Debug("Redirect forward failed")
+	}
+}
+
+func (p *RedirectProxy) Stop() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	if err := p.netManager.Cleanup(); err != nil {
+		log.WithError(err).Warn("Failed to clean up redirect rules")
+	}
+	if p.releaseDialer != nil {
+		p.releaseDialer()
+	}
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// originalDst returns the destination of a redirected connection before
+// the nat table rewrote it.
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	// IPv4 clients of a dual-stack listener show up as v4-mapped
+	// addresses; conntrack still has them as IPv4.
+	v4 := conn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+
+	var addr *net.TCPAddr
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if v4 {
+			// sockaddr_in fits in the 16 byte address of an ipv6_mreq.
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, soOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&mreq.Multiaddr[0]))
+			addr = &net.TCPAddr{
+				IP:   net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]),
+				Port: ntohs(sa.Port),
+			}
+			return
+		}
+
+		// ip6_mtuinfo starts with a sockaddr_in6.
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSoOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, info.Addr.Addr[:])
+		addr = &net.TCPAddr{IP: ip, Port: ntohs(info.Addr.Port)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("getsockopt SO_ORIGINAL_DST: %w", sockErr)
+	}
+	return addr, nil
+}
+
+// ntohs converts a port read from a raw sockaddr to host byte order.
+func ntohs(port uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return int(b[0])<<8 | int(b[1])
+}
+
+// RedirectNetworkManager installs the nat table rules of the redirect
+// mode for IPv4 and, when the kernel supports it, IPv6. The rules live in
+// their own chain, hooked into PREROUTING for forwarded traffic and OUTPUT
+// for local traffic. Reserved networks, marked sockets and the SSH servers
+// are left alone.
+type RedirectNetworkManager struct {
+	port   int16
+	bypass []string
+	mu     sync.Mutex
+}
+
+var redirectHooks = []string{"PREROUTING", "OUTPUT"}
+
+// NewRedirectNetworkManager creates the rules redirecting TCP to port.
+// bypass lists host:port addresses that must keep their destination,
+// normally the SSH servers the tunnels connect to.
+func NewRedirectNetworkManager(port int16, bypass []string) *RedirectNetworkManager {
+	return &RedirectNetworkManager{
+		port:   port,
+		bypass: bypass,
+	}
+}
+
+func (nm *RedirectNetworkManager) Setup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	bypass := resolveBypass(nm.bypass)
+	for _, family := range netFamilies {
+		if err := nm.setupFamily(family, bypass); err != nil {
+			if family.ipv6 {
+				log.WithError(err).Warn("IPv6 connections will not be redirected")
+				continue
+			}
+			nm.cleanupLocked()
+			return err
+		}
+	}
+	return nil
+}
+
+func (nm *RedirectNetworkManager) setupFamily(family netFamily, bypass []*net.TCPAddr) error {
+	if err := runCommand(family.iptables, "-t", "nat", "-N", redirectChain); err != nil {
+		// Left over from a previous run that did not clean up.
+		if err := runCommand(family.iptables, "-t", "nat", "-F", redirectChain); err != nil {
+			return err
+		}
+	}
+
+	var rules [][]string
+	for _, cidr := range family.reserved {
+		rules = append(rules, []string{"-d", cidr, "-j", "RETURN"})
+	}
+	rules = append(rules, []string{"-m", "mark", "--mark", strconv.Itoa(directMark), "-j", "RETURN"})
+	for _, addr := range bypass {
+		if (addr.IP.To4() == nil) != family.ipv6 {
+			continue
+		}
+		rules = append(rules, []string{"-p", "tcp", "-d", addr.IP.String(), "--dport", strconv.Itoa(addr.Port), "-j", "RETURN"})
+	}
+	rules = append(rules, []string{"-p", "tcp", "-j", "REDIRECT", "--to-ports", strconv.Itoa(int(nm.port))})
+
+	for _, rule := range rules {
+		args := append([]string{"-t", "nat", "-A", redirectChain}, rule...)
+		if err := runCommand(family.iptables, args...); err != nil {
+			return err
+		}
+	}
+
+	for _, hook := range redirectHooks {
+		jump := []string{"-t", "nat", "-C", hook, "-p", "tcp", "-j", redirectChain}
+		if runCommand(family.iptables, jump...) == nil {
+			continue
+		}
+		jump[2] = "-A"
+		if err := runCommand(family.iptables, jump...); err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("%s redirect rules installed", family.iptables)
+	return nil
+}
+
+func (nm *RedirectNetworkManager) Cleanup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.cleanupLocked()
+	return nil
+}
+
+// cleanupLocked removes the rules of both families. Like the tproxy
+// cleanup it does not track what was installed, so it also clears
+// leftovers of an earlier run.
+func (nm *RedirectNetworkManager) cleanupLocked() {
+	for _, family := range netFamilies {
+		for _, hook := range redirectHooks {
+			for runCommand(family.iptables, "-t", "nat", "-D", hook, "-p", "tcp", "-j", redirectChain) == nil {
+				// delete duplicates left by earlier runs too
+			}
+		}
+		if runCommand(family.iptables, "-t", "nat", "-F", redirectChain) == nil {
+			if err := runCommand(family.iptables, "-t", "nat", "-X", redirectChain); err != nil {
+				log.WithError(err).Warnf("Failed to delete %s chain", family.iptables)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	socks4Version      = 0x04
+	socks4ReplyVersion = 0x00
+	socks4Granted      = 0x5A
+	socks4Rejected     = 0x5B
+	socks4MaxField     = 255
+)
+
+// handleSocks4 serves a SOCKS4 or SOCKS4a CONNECT request. The version
+// byte has already been read. SOCKS4 has no passwords, so the userid is
+// never trusted as an identity: requests are anonymous unless the client
+// is identified by a TLS certificate, and are only accepted on listeners
+// that allow anonymous access otherwise.
+func (s *Socks5Server) handleSocks4(conn net.Conn, certUser string) error {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read SOCKS4 request: %w", err)
+	}
+
+	command := header[0]
+	port := binary.BigEndian.Uint16(header[1:3])
+	ip := net.IP(header[3:7])
+
+	userID, err := readNullTerminated(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read SOCKS4 userid: %w", err)
+	}
+
+	// SOCKS4a: 0.0.0.x with x != 0 means a hostname follows the userid.
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		if host, err = readNullTerminated(conn); err != nil {
+			return fmt.Errorf("failed to read SOCKS4a hostname: %w", err)
+		}
+		if host == "" {
+			s.writeSocks4Reply(conn, socks4Rejected, nil)
+			s.manager.ReportAbuse(tunnel.ClientIP(conn.RemoteAddr()), tunnel.AbuseMalformed)
+			return fmt.Errorf("empty SOCKS4a hostname")
+		}
+	}
+
+	if command != cmdConnect {
+		s.writeSocks4Reply(conn, socks4Rejected, nil)
+		return fmt.Errorf("unsupported SOCKS4 command: %d", command)
+	}
+
+	if certUser == "" && !s.opts.allowAnonymous() {
+		s.writeSocks4Reply(conn, socks4Rejected, nil)
+		log.WithFields(log.Fields{
+			"clientIP": tunnel.ClientIP(conn.RemoteAddr()),
+			"user":     userID,
+		}).Warn("SOCKS4 rejected: listener requires authentication")
+		return fmt.Errorf("SOCKS4 is not allowed on a listener that requires authentication")
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	targetAddr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	log.WithFields(log.Fields{
+		"userid": userID,
+		"user":   certUser,
+	}).Debugf("SOCKS4 CONNECT request to %s", targetAddr)
+
+	meta := &tunnel.Metadata{
+		Inbound:  "socks5",
+		ClientIP: tunnel.ClientIP(conn.RemoteAddr()),
+		User:     certUser,
+		Target:   targetAddr,
+	}
+	if s.manager.ShouldSniff(meta) {
+		endSession, err := s.manager.StartFlow(meta)
+		if err != nil {
+			s.writeSocks4Reply(conn, socks4Rejected, nil)
+			return fmt.Errorf("SOCKS4 CONNECT to %s failed: %w", targetAddr, err)
+		}
+		if err := s.writeSocks4Reply(conn, socks4Granted, nil); err != nil {
+			endSession()
+			return err
+		}
+		if err := s.manager.ForwardStarted(conn, meta, endSession); err != nil {
+			return fmt.Errorf("SOCKS4 CONNECT to %s failed: %w", targetAddr, err)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	session, err := s.manager.Connect(ctx, meta)
+	cancel()
+	if err != nil {
+		s.writeSocks4Reply(conn, socks4Rejected, nil)
+		return fmt.Errorf("SOCKS4 CONNECT to %s failed: %w", targetAddr, err)
+	}
+	defer session.Close()
+
+	if err := s.writeSocks4Reply(conn, socks4Granted, session.BoundAddr()); err != nil {
+		return err
+	}
+
+	err = session.Relay(conn)
+	if err != nil && err != io.EOF &&
+		!strings.Contains(err.Error(), "closed") &&
+		!strings.Contains(err.Error(), "reset") {
+		log.Debugf("Forward error for %s: %v", targetAddr, err)
+	}
+	return nil
+}
+
+func (s *Socks5Server) writeSocks4Reply(conn net.Conn, code byte, addr net.Addr) error {
+	reply := make([]byte, 8)
+	reply[0] = socks4ReplyVersion
+	reply[1] = code
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+			binary.BigEndian.PutUint16(reply[2:4], uint16(tcpAddr.Port))
+			copy(reply[4:], ip4)
+		}
+	}
+
+	if _, err := conn.Write(reply); err != nil {
+		return fmt.Errorf("failed to write SOCKS4 reply: %w", err)
+	}
+	return nil
+}
+
+// readNullTerminated reads a NUL terminated field one byte at a time, so
+// nothing past the request is consumed.
+func readNullTerminated(r io.Reader) (string, error) {
+	var b []byte
+	c := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, c); err != nil {
+			return "", err
+		}
+		if c[0] == 0 {
+			return string(b), nil
+		}
+		if len(b) >= socks4MaxField {
+			return "", fmt.Errorf("field too long")
+		}
+		b = append(b, c[0])
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"xengate/internal/models"
+)
+
+// echoServer listens on the loopback interface and echoes what it reads.
+func echoServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+// socks4Request builds a SOCKS4 request, or a SOCKS4a one if host is set.
+func socks4Request(command byte, port int, ip net.IP, userID, host string) []byte {
+	req := []byte{socks4Version, command}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = append(req, ip.To4()...)
+	req = append(append(req, userID...), 0)
+	if host != "" {
+		req = append(append(req, host...), 0)
+	}
+	return req
+}
+
+func TestSocks4Connect(t *testing.T) {
+	echo := echoServer(t)
+	socks4a := net.IPv4(0, 0, 0, 1)
+
+	tests := []struct {
+		name       string
+		request    []byte
+		restricted bool // listener requires authentication
+		reply      byte
+		banned     bool
+	}{
+		{"socks4", socks4Request(cmdConnect, echo.Port, echo.IP, "user", ""), false, socks4Granted, false},
+		{"socks4a", socks4Request(cmdConnect, echo.Port, socks4a, "", "localhost"), false, socks4Granted, false},
+		{"socks4a empty host", append(socks4Request(cmdConnect, echo.Port, socks4a, "", ""), 0), false, socks4Rejected, true},
+		{"bind", socks4Request(0x02, echo.Port, echo.IP, "", ""), false, socks4Rejected, false},
+		{"authentication required", socks4Request(cmdConnect, echo.Port, echo.IP, "alice", ""), true, socks4Rejected, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			if err := manager.SetAutoBan(&models.AutoBanConfig{Enabled: true, Threshold: 1}); err != nil {
+				t.Fatal(err)
+			}
+			manager.SetRoutingRules([]*models.RoutingRule{
+				{Title: "loopback", CIDRs: []string{"127.0.0.0/8"}, Action: models.RouteActionDirect},
+				{Title: "localhost", Domains: []string{"localhost"}, Action: models.RouteActionDirect},
+			})
+			s := &Socks5Server{manager: manager}
+			if tt.restricted {
+				s.opts = &Options{Credentials: testCredentials{"alice": "secret"}}
+			}
+			client, done := serveSocks(t, s, "192.0.2.1")
+
+			client.Write(tt.request)
+			reply := readN(t, client, 8)
+			if reply[0] != socks4ReplyVersion || reply[1] != tt.reply {
+				t.Fatalf("reply = %x, want code %#x", reply, tt.reply)
+			}
+			if tt.reply == socks4Granted {
+				client.Write([]byte("ping"))
+				if got := readN(t, client, 4); string(got) != "ping" {
+					t.Errorf("relayed %q, want ping", got)
+				}
+			}
+			client.Close()
+			<-done
+			if got := manager.IsIPBlocked("192.0.2.1"); got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,13 +19,27 @@ import (
 )
 
 const (
-	socks5Version = 0x05
-	authNone      = 0x00
-	cmdConnect    = 0x01
-	addrIPv4      = 0x01
-	addrDomain    = 0x03
-	addrIPv6      = 0x04
-	replySuccess  = 0x00
+	socks5Version    = 0x05
+	authNone         = 0x00
+	authUserPass     = 0x02
+	authNoAcceptable = 0xFF
+	userPassVersion  = 0x01
+	userPassSuccess  = 0x00
+	userPassFailure  = 0x01
+	cmdConnect       = 0x01
+	cmdUDPAssociate  = 0x03
+	addrIPv4         = 0x01
+	addrDomain       = 0x03
+	addrIPv6         = 0x04
+	replySuccess     = 0x00
+	replyGeneralFail = 0x01
+	replyNotAllowed  = 0x02
+	replyNetUnreach  = 0x03
+	replyHostUnreach = 0x04
+	replyConnRefused = 0x05
+	replyTTLExpired  = 0x06
+	replyCmdNotSupp  = 0x07
+	replyAddrNotSupp = 0x08
 )
 
 type Socks5Server struct {
@@ -34,19 +50,21 @@ type Socks5Server struct {
 	closed   bool
 	ip       string
 	port     int16
+	opts     *Options
 }
 
-func NewSocks5Server(ip string, port int16, manager *tunnel.Manager) (*Socks5Server, error) {
+func NewSocks5Server(ip string, port int16, manager *tunnel.Manager, opts *Options) (*Socks5Server, error) {
 	return &Socks5Server{
 		manager: manager,
 		ip:      ip,
 		port:    port,
+		opts:    opts,
 	}, nil
 }
 
 func (s *Socks5Server) Start(ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", s.ip, s.port)
-	listener, err := net.Listen("tcp", addr)
+	addr := listenAddr(s.ip, s.port)
+	listener, err := listen(addr, s.opts)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
@@ -89,8 +107,16 @@ func (s *Socks5Server) acceptLoop(ctx context.Context) {
 				continue
 			}
 
+			release, ok := admit(s.manager, conn, "socks5")
+			if !ok {
+				continue
+			}
+
 			s.wg.Add(1)
-			go s.handleConnection(ctx, conn)
+			go func() {
+				defer release()
+				s.handleConnection(ctx, conn)
+			}()
 		}
 	}
 }
@@ -101,49 +127,138 @@ func (s *Socks5Server) handleConnection(ctx context.Context, conn net.Conn) {
 		conn.Close()
 	}()
 
+	s.serveConn(ctx, conn)
+}
+
+// serveConn runs the SOCKS handshake and the requested command on conn.
+func (s *Socks5Server) serveConn(ctx context.Context, conn net.Conn) {
 	// Set timeout for handshake
 	conn.SetDeadline(time.Now().Add(10 * time.Second))
 
+	clientIP := tunnel.ClientIP(conn.RemoteAddr())
+
+	// A verified client certificate identifies the user
+	certUser, err := peerCertUser(conn)
+	if err != nil {
+		log.Debugf("SOCKS TLS error: %v", err)
+		return
+	}
+
+	// The first byte tells SOCKS4 and SOCKS5 apart
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return
+	}
+	switch version[0] {
+	case socks4Version:
+		if err := s.handleSocks4(conn, certUser); err != nil {
+			log.Debugf("SOCKS4 request failed: %v", err)
+		}
+		return
+	case socks5Version:
+	default:
+		log.Debugf("Unsupported SOCKS version: %d", version[0])
+		s.manager.ReportAbuse(clientIP, tunnel.AbuseMalformed)
+		return
+	}
+
 	// Step 1: Authentication
-	if err := s.handleAuth(conn); err != nil {
+	user, err := s.handleAuth(conn, certUser)
+	if err != nil {
 		log.Debugf("Auth failed: %v", err)
 		return
 	}
 
 	// Step 2: Request handling
-	if err := s.handleRequest(conn); err != nil {
+	if err := s.handleRequest(ctx, conn, user); err != nil {
 		log.Debugf("Request failed: %v", err)
 	}
 }
 
-func (s *Socks5Server) handleAuth(conn net.Conn) error {
-	// Read the version and number of methods
-	buf := make([]byte, 2)
+// handleAuth negotiates the authentication method and returns the
+// authenticated username, or "" for anonymous clients. The version byte
+// has already been read. Clients identified by a TLS certificate need no
+// further authentication.
+func (s *Socks5Server) handleAuth(conn net.Conn, certUser string) (string, error) {
+	// Read the number of methods
+	buf := make([]byte, 1)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return fmt.Errorf("failed to read auth header: %w", err)
+		return "", fmt.Errorf("failed to read auth header: %w", err)
 	}
 
-	version := buf[0]
-	if version != socks5Version {
-		return fmt.Errorf("unsupported SOCKS version: %d", version)
-	}
-
-	nMethods := buf[1]
+	nMethods := buf[0]
 	methods := make([]byte, nMethods)
 	if _, err := io.ReadFull(conn, methods); err != nil {
-		return fmt.Errorf("failed to read methods: %w", err)
+		return "", fmt.Errorf("failed to read methods: %w", err)
 	}
 
-	// We only support no-auth
-	response := []byte{socks5Version, authNone}
-	if _, err := conn.Write(response); err != nil {
-		return fmt.Errorf("failed to write auth response: %w", err)
+	// Prefer username/password whenever a credential store is configured,
+	// so users are identified even on listeners that allow anonymous access.
+	method := byte(authNoAcceptable)
+	if certUser != "" && bytes.IndexByte(methods, authNone) >= 0 {
+		method = authNone
+	} else if s.opts.credentials() != nil && bytes.IndexByte(methods, authUserPass) >= 0 {
+		method = authUserPass
+	} else if s.opts.allowAnonymous() && bytes.IndexByte(methods, authNone) >= 0 {
+		method = authNone
 	}
 
-	return nil
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", fmt.Errorf("failed to write auth response: %w", err)
+	}
+
+	switch method {
+	case authUserPass:
+		return s.handleUserPassAuth(conn)
+	case authNone:
+		return certUser, nil
+	default:
+		return "", fmt.Errorf("no acceptable authentication method offered")
+	}
 }
 
-func (s *Socks5Server) handleRequest(conn net.Conn) error {
+// handleUserPassAuth runs the RFC 1929 username/password sub-negotiation.
+func (s *Socks5Server) handleUserPassAuth(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("failed to read auth request: %w", err)
+	}
+	if header[0] != userPassVersion {
+		s.manager.ReportAbuse(tunnel.ClientIP(conn.RemoteAddr()), tunnel.AbuseMalformed)
+		return "", fmt.Errorf("unsupported auth version: %d", header[0])
+	}
+
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return "", fmt.Errorf("failed to read username: %w", err)
+	}
+
+	passLen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, passLen); err != nil {
+		return "", fmt.Errorf("failed to read password length: %w", err)
+	}
+	password := make([]byte, passLen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	if !s.opts.credentials().Authenticate(string(username), string(password)) {
+		conn.Write([]byte{userPassVersion, userPassFailure})
+		log.WithFields(log.Fields{
+			"clientIP": tunnel.ClientIP(conn.RemoteAddr()),
+			"user":     string(username),
+		}).Warn("SOCKS5 authentication failed")
+		s.manager.ReportAbuse(tunnel.ClientIP(conn.RemoteAddr()), tunnel.AbuseAuthFailure)
+		return "", fmt.Errorf("invalid credentials for user %q", username)
+	}
+
+	if _, err := conn.Write([]byte{userPassVersion, userPassSuccess}); err != nil {
+		return "", fmt.Errorf("failed to write auth status: %w", err)
+	}
+	return string(username), nil
+}
+
+func (s *Socks5Server) handleRequest(ctx context.Context, conn net.Conn, user string) error {
 	// Read the request header (4 bytes)
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, buf); err != nil {
@@ -156,11 +271,14 @@ func (s *Socks5Server) handleRequest(conn net.Conn) error {
 	addressType := buf[3]
 
 	if version != socks5Version {
+		s.manager.ReportAbuse(tunnel.ClientIP(conn.RemoteAddr()), tunnel.AbuseMalformed)
 		return fmt.Errorf("unsupported version in request: %d", version)
 	}
 
-	if command != cmdConnect {
-		return fmt.Errorf("only CONNECT command is supported")
+	if addressType != addrIPv4 && addressType != addrDomain && addressType != addrIPv6 {
+		s.writeReply(conn, replyAddrNotSupp, nil)
+		s.manager.ReportAbuse(tunnel.ClientIP(conn.RemoteAddr()), tunnel.AbuseMalformed)
+		return fmt.Errorf("unsupported address type: %d", addressType)
 	}
 
 	// Parse target address
@@ -172,8 +290,35 @@ func (s *Socks5Server) handleRequest(conn net.Conn) error {
 	// Clear the deadline for the connection now that we've read the request
 	conn.SetDeadline(time.Time{})
 
-	// Handle the CONNECT request
-	return s.handleConnect(conn, targetAddr)
+	switch command {
+	case cmdConnect:
+		return s.handleConnect(conn, targetAddr, user)
+	case cmdUDPAssociate:
+		return s.handleUDPAssociate(ctx, conn, targetAddr, user)
+	default:
+		// BIND and other well-formed commands are probed by ordinary
+		// clients, so they are refused without counting as abuse.
+		s.writeReply(conn, replyCmdNotSupp, nil)
+		return fmt.Errorf("unsupported command: %d", command)
+	}
+}
+
+// writeReply sends a SOCKS5 reply with the given bound address, or
+// 0.0.0.0:0 when addr is nil.
+func (s *Socks5Server) writeReply(conn net.Conn, code byte, addr net.Addr) error {
+	var ip net.IP
+	var port int
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		ip, port = udpAddr.IP, udpAddr.Port
+	} else if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	}
+
+	response := append([]byte{socks5Version, code, 0x00}, encodeAddr(ip, port)...)
+	if _, err := conn.Write(response); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
 }
 
 func (s *Socks5Server) parseAddress(conn net.Conn, addrType byte) (string, error) {
@@ -202,7 +347,7 @@ func (s *Socks5Server) parseAddress(conn net.Conn, addrType byte) (string, error
 		if _, err := io.ReadFull(conn, ip); err != nil {
 			return "", err
 		}
-		host = "[" + net.IP(ip).String() + "]" // Wrap IPv6 in brackets
+		host = net.IP(ip).String()
 	default:
 		return "", fmt.Errorf("unsupported address type: %d", addrType)
 	}
@@ -217,24 +362,51 @@ func (s *Socks5Server) parseAddress(conn net.Conn, addrType byte) (string, error
 	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
 }
 
-func (s *Socks5Server) handleConnect(clientConn net.Conn, targetAddr string) error {
+func (s *Socks5Server) handleConnect(clientConn net.Conn, targetAddr, user string) error {
 	log.Debugf("CONNECT request to %s", targetAddr)
 
-	// Send a success response (with 0.0.0.0:0 as bind address)
-	response := []byte{
-		socks5Version,
-		replySuccess,
-		0x00, // reserved
-		addrIPv4,
-		0x00, 0x00, 0x00, 0x00, // IPv4: 0.0.0.0
-		0x00, 0x00, // port: 0
+	meta := &tunnel.Metadata{
+		Inbound:  "socks5",
+		ClientIP: tunnel.ClientIP(clientConn.RemoteAddr()),
+		User:     user,
+		Target:   targetAddr,
 	}
-	if _, err := clientConn.Write(response); err != nil {
-		return fmt.Errorf("failed to write response: %w", err)
+
+	// The client only sends the bytes the domain is sniffed from after the
+	// reply, so sniffed flows are confirmed before they are dialed, once
+	// the checks that do not need the domain have passed.
+	if s.manager.ShouldSniff(meta) {
+		endSession, err := s.manager.StartFlow(meta)
+		if err != nil {
+			s.writeReply(clientConn, replyCode(err), nil)
+			return fmt.Errorf("CONNECT to %s failed: %w", targetAddr, err)
+		}
+		if err := s.writeReply(clientConn, replySuccess, nil); err != nil {
+			endSession()
+			return err
+		}
+		if err := s.manager.ForwardStarted(clientConn, meta, endSession); err != nil {
+			return fmt.Errorf("CONNECT to %s failed: %w", targetAddr, err)
+		}
+		return nil
+	}
+
+	// Dial before replying so the client learns whether the target is
+	// reachable and can fall back otherwise.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	session, err := s.manager.Connect(ctx, meta)
+	cancel()
+	if err != nil {
+		s.writeReply(clientConn, replyCode(err), nil)
+		return fmt.Errorf("CONNECT to %s failed: %w", targetAddr, err)
+	}
+	defer session.Close()
+
+	if err := s.writeReply(clientConn, replySuccess, session.BoundAddr()); err != nil {
+		return err
 	}
 
-	// Now forward the connection
-	err := s.manager.Forward(clientConn, targetAddr)
+	err = session.Relay(clientConn)
 
 	// Don't log common errors
 	if err != nil && err != io.EOF &&
@@ -246,6 +418,24 @@ func (s *Socks5Server) handleConnect(clientConn net.Conn, targetAddr string) err
 	return nil
 }
 
+// replyCode maps a tunnel.Manager error to the RFC 1928 reply field.
+func replyCode(err error) byte {
+	switch {
+	case tunnel.IsPolicyError(err):
+		return replyNotAllowed
+	case errors.Is(err, tunnel.ErrConnectionRefused):
+		return replyConnRefused
+	case errors.Is(err, tunnel.ErrHostUnreachable):
+		return replyHostUnreach
+	case errors.Is(err, tunnel.ErrNetworkUnreachable):
+		return replyNetUnreach
+	case errors.Is(err, tunnel.ErrTimeout):
+		return replyTTLExpired
+	default:
+		return replyGeneralFail
+	}
+}
+
 func (s *Socks5Server) Stop() error {
 	s.mu.Lock()
 	s.closed = true
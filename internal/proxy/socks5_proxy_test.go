@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"xengate/internal/models"
+	"xengate/internal/tunnel"
+)
+
+// remoteAddrConn reports a fixed client address, so the policies keyed by
+// client address apply to the connections of a net.Pipe.
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func newTestManager(t *testing.T) *tunnel.Manager {
+	t.Helper()
+	return tunnel.NewManager(nil, tunnel.NewAccessControl(time.Hour))
+}
+
+// serveSocks runs serveConn for a client at clientIP and returns the
+// client end of the connection, and a channel closed once serveConn
+// returns.
+func serveSocks(t *testing.T, s *Socks5Server, clientIP string) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	client, server := net.Pipe()
+	conn := &remoteAddrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP(clientIP), Port: 40000}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		s.serveConn(context.Background(), conn)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, done
+}
+
+func readN(t *testing.T, conn net.Conn, n int) []byte {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("reading %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+func TestSocks5UnsupportedCommandAbuse(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		reply   byte
+		banned  bool
+	}{
+		{"bind", []byte{socks5Version, 0x02, 0, addrIPv4, 192, 0, 2, 9, 0, 80}, replyCmdNotSupp, false},
+		{"unknown command", []byte{socks5Version, 0x09, 0, addrIPv4, 192, 0, 2, 9, 0, 80}, replyCmdNotSupp, false},
+		{"bad address type", []byte{socks5Version, cmdConnect, 0, 0x07}, replyAddrNotSupp, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			if err := manager.SetAutoBan(&models.AutoBanConfig{Enabled: true, Threshold: 1}); err != nil {
+				t.Fatal(err)
+			}
+			s := &Socks5Server{manager: manager}
+			client, done := serveSocks(t, s, "192.0.2.1")
+
+			client.Write([]byte{socks5Version, 1, authNone})
+			readN(t, client, 2)
+			client.Write(tt.request)
+			if reply := readN(t, client, 10); reply[1] != tt.reply {
+				t.Errorf("reply = %#x, want %#x", reply[1], tt.reply)
+			}
+			client.Close()
+			<-done
+			if got := manager.IsIPBlocked("192.0.2.1"); got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+}
+
+// testCredentials accepts the passwords in the map by username.
+type testCredentials map[string]string
+
+func (c testCredentials) Authenticate(username, password string) bool {
+	want, ok := c[username]
+	return ok && want == password
+}
+
+// userPassRequest is an RFC 1929 sub-negotiation request.
+func userPassRequest(version byte, username, password string) []byte {
+	req := append([]byte{version, byte(len(username))}, username...)
+	return append(append(req, byte(len(password))), password...)
+}
+
+func TestSocks5UserPassAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool
+		methods   []byte
+		method    byte
+		request   []byte // sub-negotiation, if method is authUserPass
+		status    []byte
+		banned    bool
+	}{
+		{
+			name:    "valid",
+			methods: []byte{authNone, authUserPass},
+			method:  authUserPass,
+			request: userPassRequest(userPassVersion, "alice", "secret"),
+			status:  []byte{userPassVersion, userPassSuccess},
+		},
+		{
+			name:    "wrong password",
+			methods: []byte{authUserPass},
+			method:  authUserPass,
+			request: userPassRequest(userPassVersion, "alice", "wrong"),
+			status:  []byte{userPassVersion, userPassFailure},
+			banned:  true,
+		},
+		{
+			name:    "unknown user",
+			methods: []byte{authUserPass},
+			method:  authUserPass,
+			request: userPassRequest(userPassVersion, "mallory", "secret"),
+			status:  []byte{userPassVersion, userPassFailure},
+			banned:  true,
+		},
+		{
+			name:    "bad sub-negotiation version",
+			methods: []byte{authUserPass},
+			method:  authUserPass,
+			request: userPassRequest(0x05, "alice", "secret")[:2],
+			banned:  true,
+		},
+		{
+			name:    "anonymous refused",
+			methods: []byte{authNone},
+			method:  authNoAcceptable,
+		},
+		{
+			name:      "anonymous allowed",
+			anonymous: true,
+			methods:   []byte{authNone},
+			method:    authNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			if err := manager.SetAutoBan(&models.AutoBanConfig{Enabled: true, Threshold: 1}); err != nil {
+				t.Fatal(err)
+			}
+			s := &Socks5Server{manager: manager, opts: &Options{
+				Credentials:    testCredentials{"alice": "secret"},
+				AllowAnonymous: tt.anonymous,
+			}}
+			client, done := serveSocks(t, s, "192.0.2.1")
+
+			client.Write(append([]byte{socks5Version, byte(len(tt.methods))}, tt.methods...))
+			if reply := readN(t, client, 2); reply[1] != tt.method {
+				t.Fatalf("method = %#x, want %#x", reply[1], tt.method)
+			}
+			if tt.request != nil {
+				client.Write(tt.request)
+			}
+			if tt.status != nil {
+				if status := readN(t, client, 2); string(status) != string(tt.status) {
+					t.Errorf("status = %x, want %x", status, tt.status)
+				}
+			}
+			client.Close()
+			<-done
+			if got := manager.IsIPBlocked("192.0.2.1"); got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+}
+
+func TestReplyCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want byte
+	}{
+		{fmt.Errorf("%w: 192.0.2.1", tunnel.ErrBlocked), replyNotAllowed},
+		{fmt.Errorf("%w: example.com", tunnel.ErrDestinationBlocked), replyNotAllowed},
+		{fmt.Errorf("%w: ads", tunnel.ErrRejected), replyNotAllowed},
+		{fmt.Errorf("%w for 192.0.2.1", tunnel.ErrAccessDenied), replyNotAllowed},
+		{fmt.Errorf("failed to dial: %w", tunnel.ErrConnectionRefused), replyConnRefused},
+		{fmt.Errorf("failed to dial: %w", tunnel.ErrHostUnreachable), replyHostUnreach},
+		{fmt.Errorf("failed to dial: %w", tunnel.ErrNetworkUnreachable), replyNetUnreach},
+		{fmt.Errorf("failed to dial: %w", tunnel.ErrTimeout), replyTTLExpired},
+		{tunnel.ErrNoTunnel, replyGeneralFail},
+		{errors.New("ssh: unexpected packet"), replyGeneralFail},
+	}
+
+	for _, tt := range tests {
+		if got := replyCode(tt.err); got != tt.want {
+			t.Errorf("replyCode(%v) = %#x, want %#x", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxUDPDatagram = 65535
+	udpResolveTime = 5 * time.Second
+)
+
+// handleUDPAssociate serves a UDP ASSOCIATE request. Datagrams from the
+// client are unwrapped from the SOCKS UDP header and relayed through the
+// tunnel; the association ends when the control connection closes.
+func (s *Socks5Server) handleUDPAssociate(ctx context.Context, conn net.Conn, clientAddr, user string) error {
+	clientIP := tunnel.ClientIP(conn.RemoteAddr())
+	logger := log.WithFields(log.Fields{
+		"clientIP": clientIP,
+		"user":     user,
+	})
+
+	// The relay socket is bound on the address the client reached us on.
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		s.writeReply(conn, replyGeneralFail, nil)
+		return fmt.Errorf("failed to open UDP relay: %w", err)
+	}
+	defer udpConn.Close()
+
+	relay, err := s.manager.OpenUDP(&tunnel.Metadata{
+		Inbound:  "socks5",
+		ClientIP: clientIP,
+		User:     user,
+		Target:   clientAddr,
+	})
+	if err != nil {
+		s.writeReply(conn, replyCode(err), nil)
+		return err
+	}
+	defer relay.Close()
+
+	if err := s.writeReply(conn, replySuccess, udpConn.LocalAddr()); err != nil {
+		return err
+	}
+	logger.WithField("relay", udpConn.LocalAddr().String()).Debug("UDP association established")
+
+	assoc := &udpAssociation{
+		server:   s,
+		udpConn:  udpConn,
+		relay:    relay,
+		clientIP: net.ParseIP(clientIP),
+		logger:   logger,
+	}
+	// A client that announces its port up front only gets datagrams from
+	// that port accepted.
+	if host, port, err := net.SplitHostPort(clientAddr); err == nil {
+		if p, _ := strconv.Atoi(port); p != 0 {
+			assoc.expectedPort = p
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			assoc.clientIP = ip
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assoc.clientToRemote(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		assoc.remoteToClient()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		udpConn.Close()
+		relay.Close()
+	}()
+
+	// The association lives as long as the control connection.
+	io.Copy(io.Discard, conn)
+	close(done)
+	wg.Wait()
+
+	logger.Debug("UDP association closed")
+	return nil
+}
+
+type udpAssociation struct {
+	server       *Socks5Server
+	udpConn      *net.UDPConn
+	relay        *tunnel.UDPRelay
+	clientIP     net.IP
+	expectedPort int
+	logger       *log.Entry
+
+	mu         sync.RWMutex
+	clientAddr *net.UDPAddr
+}
+
+func (a *udpAssociation) clientToRemote(ctx context.Context) {
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, from, err := a.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !from.IP.Equal(a.clientIP) || (a.expectedPort != 0 && from.Port != a.expectedPort) {
+			a.logger.WithField("from", from.String()).Debug("Dropping UDP datagram from unexpected source")
+			continue
+		}
+
+		frag, target, payload, err := parseUDPHeader(buf[:n])
+		if err != nil {
+			a.logger.WithError(err).Debug("Dropping malformed UDP datagram")
+			continue
+		}
+		// Fragmentation is optional in RFC 1928 and not supported.
+		if frag != 0 {
+			a.logger.Debug("Dropping fragmented UDP datagram")
+			continue
+		}
+
+		a.mu.Lock()
+		a.clientAddr = from
+		a.mu.Unlock()
+
+		resolveCtx, cancel := context.WithTimeout(ctx, udpResolveTime)
+		addr, err := a.server.manager.ResolveUDPAddr(resolveCtx, target)
+		cancel()
+		if err != nil {
+			a.logger.WithError(err).WithField("target", target).Debug("Failed to resolve UDP target")
+			continue
+		}
+
+		if err := a.relay.WriteTo(payload, addr, target); err != nil {
+			if tunnel.IsPolicyError(err) {
+				a.logger.WithError(err).Debug("Dropping UDP datagram")
+				continue
+			}
+			a.logger.WithError(err).Debug("Failed to relay UDP datagram")
+			return
+		}
+	}
+}
+
+func (a *udpAssociation) remoteToClient() {
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, from, err := a.relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		a.mu.RLock()
+		clientAddr := a.clientAddr
+		a.mu.RUnlock()
+		if clientAddr == nil {
+			continue
+		}
+
+		packet := append(buildUDPHeader(from), buf[:n]...)
+		if _, err := a.udpConn.WriteToUDP(packet, clientAddr); err != nil {
+			return
+		}
+	}
+}
+
+// parseUDPHeader splits a SOCKS UDP request into its fragment number,
+// destination and payload.
+func parseUDPHeader(b []byte) (byte, string, []byte, error) {
+	if len(b) < 4 {
+		return 0, "", nil, fmt.Errorf("short UDP header")
+	}
+	frag := b[2]
+	b = b[3:]
+
+	var host string
+	switch b[0] {
+	case addrIPv4:
+		if len(b) < 1+net.IPv4len+2 {
+			return 0, "", nil, fmt.Errorf("short UDP header")
+		}
+		host = net.IP(b[1 : 1+net.IPv4len]).String()
+		b = b[1+net.IPv4len:]
+	case addrIPv6:
+		if len(b) < 1+net.IPv6len+2 {
+			return 0, "", nil, fmt.Errorf("short UDP header")
+		}
+		host = net.IP(b[1 : 1+net.IPv6len]).String()
+		b = b[1+net.IPv6len:]
+	case addrDomain:
+		if len(b) < 2 || len(b) < 2+int(b[1])+2 {
+			return 0, "", nil, fmt.Errorf("short UDP header")
+		}
+		host = string(b[2 : 2+int(b[1])])
+		b = b[2+int(b[1]):]
+	default:
+		return 0, "", nil, fmt.Errorf("unsupported address type: %d", b[0])
+	}
+
+	port := binary.BigEndian.Uint16(b)
+	return frag, net.JoinHostPort(host, strconv.Itoa(int(port))), b[2:], nil
+}
+
+func buildUDPHeader(addr *net.UDPAddr) []byte {
+	header := []byte{0x00, 0x00, 0x00}
+	return append(header, encodeAddr(addr.IP, addr.Port)...)
+}
+
+// encodeAddr encodes an address as ATYP, address and port.
+func encodeAddr(ip net.IP, port int) []byte {
+	var b []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{addrIPv4}, ip4...)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		b = append([]byte{addrIPv6}, ip16...)
+	} else {
+		b = []byte{addrIPv4, 0, 0, 0, 0}
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseUDPHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		frag    byte
+		target  string
+		payload string
+		wantErr bool
+	}{
+		{
+			name:    "ipv4",
+			packet:  []byte{0, 0, 0, addrIPv4, 192, 0, 2, 1, 0, 53, 'q'},
+			target:  "192.0.2.1:53",
+			payload: "q",
+		},
+		{
+			name:    "ipv6",
+			packet:  append(append([]byte{0, 0, 0, addrIPv6}, net.ParseIP("2001:db8::1")...), 1, 187, 'q'),
+			target:  "[2001:db8::1]:443",
+			payload: "q",
+		},
+		{
+			name:    "domain",
+			packet:  append([]byte{0, 0, 0, addrDomain, 11}, append([]byte("example.com"), 0, 80, 'q', 'r')...),
+			target:  "example.com:80",
+			payload: "qr",
+		},
+		{
+			name:   "fragment",
+			packet: []byte{0, 0, 2, addrIPv4, 192, 0, 2, 1, 0, 53},
+			frag:   2,
+			target: "192.0.2.1:53",
+		},
+		{name: "short", packet: []byte{0, 0, 0}, wantErr: true},
+		{name: "short ipv4", packet: []byte{0, 0, 0, addrIPv4, 192, 0, 2, 1, 0}, wantErr: true},
+		{name: "short ipv6", packet: []byte{0, 0, 0, addrIPv6, 0x20, 0x01, 0, 53}, wantErr: true},
+		{name: "short domain", packet: []byte{0, 0, 0, addrDomain, 11, 'e', 'x', 0, 80}, wantErr: true},
+		{name: "domain without length", packet: []byte{0, 0, 0, addrDomain}, wantErr: true},
+		{name: "unknown address type", packet: []byte{0, 0, 0, 9, 192, 0, 2, 1, 0, 53}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frag, target, payload, err := parseUDPHeader(tt.packet)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseUDPHeader() = %d, %q, %q; want an error", frag, target, payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUDPHeader() error: %v", err)
+			}
+			if frag != tt.frag || target != tt.target || string(payload) != tt.payload {
+				t.Errorf("parseUDPHeader() = %d, %q, %q; want %d, %q, %q",
+					frag, target, payload, tt.frag, tt.target, tt.payload)
+			}
+		})
+	}
+}
+
+func TestBuildUDPHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		addr *net.UDPAddr
+		want []byte
+	}{
+		{
+			name: "ipv4",
+			addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53},
+			want: []byte{0, 0, 0, addrIPv4, 192, 0, 2, 1, 0, 53},
+		},
+		{
+			name: "ipv6",
+			addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			want: append(append([]byte{0, 0, 0, addrIPv6}, net.ParseIP("2001:db8::1")...), 1, 187),
+		},
+		{
+			name: "no address",
+			addr: &net.UDPAddr{Port: 9},
+			want: []byte{0, 0, 0, addrIPv4, 0, 0, 0, 0, 0, 9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := buildUDPHeader(tt.addr)
+			if !bytes.Equal(header, tt.want) {
+				t.Fatalf("buildUDPHeader() = %v, want %v", header, tt.want)
+			}
+			if tt.addr.IP == nil {
+				return
+			}
+
+			// A reply header parses back to the address it was built for.
+			_, target, payload, err := parseUDPHeader(append(header, 'r'))
+			if err != nil || target != tt.addr.String() || string(payload) != "r" {
+				t.Errorf("round trip = %q, %q, %v; want %s", target, payload, err, tt.addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,646 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tproxyChain = "XENGATE_TPROXY"
+	tproxyMark  = 0x5848
+	tproxyTable = 5848
+
+	tproxyUDPTimeout = 60 * time.Second
+	tproxyUDPQueue   = 64 // datagrams of a client waiting for the tunnel
+)
+
+// TProxy is a transparent proxy for TCP and UDP on a Linux gateway.
+// Packets are steered to it by TPROXY rules in the mangle table and a
+// routing table delivering marked packets locally, so the sockets see the
+// original destination: accepted TCP connections as their local address,
+// UDP datagrams through IP_ORIGDSTADDR. UDP is carried over the tunnel
+// UDP transport, one association per client IP shared by all its ports.
+type TProxy struct {
+	manager    *tunnel.Manager
+	listener   net.Listener
+	udpConn    *net.UDPConn
+	netManager *TProxyNetworkManager
+	wg         sync.WaitGroup
+	mu         sync.RWMutex
+	closed     bool
+	ip         string
+	port       int16
+	sessions   map[string]*tproxyUDPSession // key: client IP
+	done       chan struct{}
+}
+
+func NewTProxy(ip string, port int16, manager *tunnel.Manager, opts *Options) (*TProxy, error) {
+	if os.Geteuid() != 0 {
+		return nil, errors.New("tproxy mode requires root privileges")
+	}
+	if port == 0 {
+		return nil, errors.New("tproxy mode requires a fixed port")
+	}
+
+	var onIP net.IP
+	if parsed := net.ParseIP(ip); parsed != nil && !parsed.IsUnspecified() {
+		onIP = parsed
+	}
+
+	return &TProxy{
+		manager:    manager,
+		ip:         ip,
+		port:       port,
+		netManager: NewTProxyNetworkManager(onIP, port),
+		sessions:   make(map[string]*tproxyUDPSession),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (p *TProxy) Start(ctx context.Context) error {
+	addr := listenAddr(p.ip, p.port)
+
+	lc := net.ListenConfig{Control: transparentControl}
+	listener, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	lc.Control = tproxyUDPControl
+	packetConn, err := lc.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+	}
+
+	p.listener = listener
+	p.udpConn = packetConn.(*net.UDPConn)
+
+	if err := p.netManager.Setup(); err != nil {
+		listener.Close()
+		p.udpConn.Close()
+		return fmt.Errorf("failed to set up tproxy rules: %w", err)
+	}
+
+	log.Infof("TPROXY listening on %s (tcp/udp)", addr)
+
+	p.wg.Add(3)
+	go p.acceptLoop()
+	go p.udpLoop()
+	go p.expireSessions()
+
+	// Shutdown handler
+	go func() {
+		<-ctx.Done()
+		p.Stop()
+	}()
+
+	return nil
+}
+
+func (p *TProxy) isClosed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
+}
+
+func (p *TProxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			log.Errorf("Accept error: %v", err)
+			continue
+		}
+
+		release, ok := admit(p.manager, conn, "tproxy")
+		if !ok {
+			continue
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer release()
+			p.handleConnection(conn)
+		}()
+	}
+}
+
+func (p *TProxy) handleConnection(conn net.Conn) {
+	defer p.wg.Done()
+
+	// The accepted socket carries the original destination as its local
+	// address.
+	target := conn.LocalAddr().(*net.TCPAddr)
+	meta := &tunnel.Metadata{
+		Inbound:  "tproxy",
+		ClientIP: tunnel.ClientIP(conn.RemoteAddr()),
+		Target:   unmapAddr(target.IP, target.Port).String(),
+	}
+	if err := p.manager.ForwardMetadata(conn, meta); err != nil {
+		log.WithFields(log.Fields{
+			"client": conn.RemoteAddr(),
+			"target": meta.Target,
+		}).WithError(err).Debug("TPROXY forward failed")
+	}
+}
+
+func (p *TProxy) udpLoop() {
+	defer p.wg.Done()
+
+	buf := make([]byte, maxUDPDatagram)
+	oob := make([]byte, 1024)
+	for {
+		n, oobn, _, from, err := p.udpConn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			if p.isClosed() {
+				return
+			}
+			log.WithError(err).Debug("TPROXY UDP read failed")
+			continue
+		}
+
+		dst, err := origDstFromOOB(oob[:oobn])
+		if err != nil {
+			log.WithError(err).Debug("Dropping UDP datagram without original destination")
+			continue
+		}
+
+		client := unmapAddr(from.IP, from.Port)
+		session := p.udpSession(client)
+		if session == nil {
+			return
+		}
+		session.send(&tproxyDatagram{
+			data: append([]byte(nil), buf[:n]...),
+			src:  client,
+			dst:  dst,
+		})
+	}
+}
+
+// udpSession returns the association of the IP of client, starting one
+// for its first datagram, or nil once the proxy is stopped. The tunnel is
+// reached by the session itself, so a slow dial holds up neither the
+// other clients nor the lock.
+func (p *TProxy) udpSession(client *net.UDPAddr) *tproxyUDPSession {
+	key := client.IP.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	if session, ok := p.sessions[key]; ok {
+		return session
+	}
+
+	session := &tproxyUDPSession{
+		proxy:   p,
+		key:     key,
+		queue:   make(chan *tproxyDatagram, tproxyUDPQueue),
+		done:    make(chan struct{}),
+		replies: make(map[string]*net.UDPConn),
+	}
+	session.touch()
+	p.sessions[key] = session
+
+	p.wg.Add(1)
+	go session.clientToRemote()
+	return session
+}
+
+// expireSessions closes UDP associations without traffic in either
+// direction. Tunnel channels have no deadlines, so this is done here.
+func (p *TProxy) expireSessions() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(tproxyUDPTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		var idle []*tproxyUDPSession
+		p.mu.RLock()
+		for _, session := range p.sessions {
+			if session.idle() >= tproxyUDPTimeout {
+				idle = append(idle, session)
+			}
+		}
+		p.mu.RUnlock()
+
+		for _, session := range idle {
+			session.Close()
+		}
+	}
+}
+
+func (p *TProxy) Stop() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	sessions := make([]*tproxyUDPSession, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		sessions = append(sessions, session)
+	}
+	p.mu.Unlock()
+
+	if err := p.netManager.Cleanup(); err != nil {
+		log.WithError(err).Warn("Failed to clean up tproxy rules")
+	}
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	if p.udpConn != nil {
+		p.udpConn.Close()
+	}
+	for _, session := range sessions {
+		session.Close()
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// tproxyDatagram is a datagram of a client waiting to be relayed.
+type tproxyDatagram struct {
+	data     []byte
+	src, dst *net.UDPAddr
+}
+
+// tproxyUDPSession relays the datagrams of one client IP. Every port of
+// the client keeps its own connection ids on the shared relay, so replies
+// find their way back to it. Replies are sent from a transparent socket
+// bound to the address they came from, so the client sees them as coming
+// from the real peer.
+type tproxyUDPSession struct {
+	proxy      *TProxy
+	key        string
+	queue      chan *tproxyDatagram
+	done       chan struct{}
+	lastActive atomic.Int64
+
+	mu      sync.Mutex
+	relay   *tunnel.UDPRelay
+	replies map[string]*net.UDPConn
+	once    sync.Once
+}
+
+// send queues a datagram for the tunnel. Datagrams are dropped while
+// the queue is full, as a congested link would.
+func (s *tproxyUDPSession) send(datagram *tproxyDatagram) {
+	s.touch()
+	select {
+	case s.queue <- datagram:
+	default:
+		log.WithField("client", s.key).Debug("Dropping UDP datagram, association is busy")
+	}
+}
+
+// clientToRemote opens the association for the first datagram and then
+// relays the queued datagrams through it.
+func (s *tproxyUDPSession) clientToRemote() {
+	defer s.proxy.wg.Done()
+	defer s.Close()
+
+	var first *tproxyDatagram
+	select {
+	case first = <-s.queue:
+	case <-s.done:
+		return
+	}
+
+	relay, err := s.proxy.manager.OpenUDP(&tunnel.Metadata{
+		Inbound:  "tproxy",
+		ClientIP: s.key,
+		Target:   first.dst.String(),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"client": first.src.String(),
+			"target": first.dst.String(),
+		}).WithError(err).Debug("Failed to open UDP association")
+		return
+	}
+
+	s.mu.Lock()
+	if s.replies == nil {
+		s.mu.Unlock()
+		relay.Close()
+		return
+	}
+	s.relay = relay
+	s.mu.Unlock()
+
+	s.proxy.wg.Add(1)
+	go s.remoteToClient(relay)
+
+	for datagram := first; ; {
+		if err := relay.WriteToFrom(datagram.data, datagram.src, datagram.dst, datagram.dst.String()); err != nil {
+			if !tunnel.IsPolicyError(err) {
+				log.WithError(err).Debug("Failed to relay UDP datagram")
+				return
+			}
+			log.WithError(err).Debug("Dropping UDP datagram")
+		}
+
+		select {
+		case datagram = <-s.queue:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *tproxyUDPSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *tproxyUDPSession) idle() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+func (s *tproxyUDPSession) remoteToClient(relay *tunnel.UDPRelay) {
+	defer s.proxy.wg.Done()
+	defer s.Close()
+
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, from, client, err := relay.ReadFromTo(buf)
+		if err != nil {
+			return
+		}
+		if client == nil {
+			// The port it answers has been idle too long to be known.
+			continue
+		}
+		s.touch()
+
+		reply, err := s.replyConn(unmapAddr(from.IP, from.Port))
+		if err != nil {
+			log.WithError(err).WithField("from", from.String()).Debug("Failed to open UDP reply socket")
+			continue
+		}
+		if _, err := reply.WriteToUDP(buf[:n], client); err != nil {
+			log.WithError(err).Debug("Failed to send UDP reply")
+		}
+	}
+}
+
+func (s *tproxyUDPSession) replyConn(from *net.UDPAddr) (*net.UDPConn, error) {
+	key := from.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replies == nil {
+		return nil, net.ErrClosed
+	}
+	if conn, ok := s.replies[key]; ok {
+		return conn, nil
+	}
+
+	network := "udp4"
+	if from.IP.To4() == nil {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{Control: transparentControl}
+	packetConn, err := lc.ListenPacket(context.Background(), network, key)
+	if err != nil {
+		return nil, err
+	}
+	conn := packetConn.(*net.UDPConn)
+	s.replies[key] = conn
+	return conn, nil
+}
+
+func (s *tproxyUDPSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		if s.relay != nil {
+			s.relay.Close()
+		}
+		for _, conn := range s.replies {
+			conn.Close()
+		}
+		s.replies = nil
+		s.mu.Unlock()
+
+		s.proxy.mu.Lock()
+		if s.proxy.sessions[s.key] == s {
+			delete(s.proxy.sessions, s.key)
+		}
+		s.proxy.mu.Unlock()
+	})
+	return nil
+}
+
+func tproxyUDPControl(network, address string, c syscall.RawConn) error {
+	if err := transparentControl(network, address, c); err != nil {
+		return err
+	}
+
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		// A dual-stack socket needs both to see IPv4 destinations.
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1)
+		if sockErr == nil && network == "udp6" {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// origDstFromOOB extracts the original destination from the control
+// messages of a datagram.
+func origDstFromOOB(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_ORIGDSTADDR &&
+			len(msg.Data) >= unix.SizeofSockaddrInet4:
+			sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&msg.Data[0]))
+			return &net.UDPAddr{
+				IP:   net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]).To4(),
+				Port: ntohs(sa.Port),
+			}, nil
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_ORIGDSTADDR &&
+			len(msg.Data) >= unix.SizeofSockaddrInet6:
+			sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&msg.Data[0]))
+			return unmapAddr(net.IP(sa.Addr[:]), ntohs(sa.Port)), nil
+		}
+	}
+	return nil, errors.New("no original destination in control message")
+}
+
+// unmapAddr returns the address with v4-mapped IPv6 addresses of
+// dual-stack sockets turned back into IPv4.
+func unmapAddr(ip net.IP, port int) *net.UDPAddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else {
+		ip = append(net.IP(nil), ip...)
+	}
+	return &net.UDPAddr{IP: ip, Port: port}
+}
+
+// TProxyNetworkManager installs the mangle table rules and the policy
+// routing of the tproxy mode. Marked packets are looked up in their own
+// routing table, which delivers everything locally. What a crashed run
+// left behind is removed by RecoverNetwork at startup, and by Setup
+// before it installs anything.
+type TProxyNetworkManager struct {
+	onIP net.IP
+	port int16
+	mu   sync.Mutex
+}
+
+func NewTProxyNetworkManager(onIP net.IP, port int16) *TProxyNetworkManager {
+	return &TProxyNetworkManager{
+		onIP: onIP,
+		port: port,
+	}
+}
+
+func (nm *TProxyNetworkManager) Setup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.cleanupLocked()
+
+	installed := false
+	for _, family := range netFamilies {
+		if nm.onIP != nil && (nm.onIP.To4() == nil) != family.ipv6 {
+			continue
+		}
+		if err := nm.setupFamily(family); err != nil {
+			if family.ipv6 {
+				log.WithError(err).Warn("IPv6 traffic will not be proxied transparently")
+				continue
+			}
+			nm.cleanupLocked()
+			return err
+		}
+		installed = true
+	}
+	if !installed {
+		nm.cleanupLocked()
+		return errors.New("no tproxy rules could be installed")
+	}
+	return nil
+}
+
+func (nm *TProxyNetworkManager) setupFamily(family netFamily) error {
+	mark := strconv.Itoa(tproxyMark)
+	table := strconv.Itoa(tproxyTable)
+
+	if err := runCommand("ip", family.ipFlag, "rule", "add", "fwmark", mark, "lookup", table); err != nil {
+		return err
+	}
+	if err := runCommand("ip", family.ipFlag, "route", "replace", "local", family.defaultRoute, "dev", "lo", "table", table); err != nil {
+		return err
+	}
+
+	if err := runCommand(family.iptables, "-t", "mangle", "-N", tproxyChain); err != nil {
+		return err
+	}
+
+	var rules [][]string
+	rules = append(rules, []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", "RETURN"})
+	for _, cidr := range family.reserved {
+		rules = append(rules, []string{"-d", cidr, "-j", "RETURN"})
+	}
+	for _, proto := range []string{"tcp", "udp"} {
+		rule := []string{"-p", proto, "-j", "TPROXY", "--on-port", strconv.Itoa(int(nm.port))}
+		if nm.onIP != nil {
+			rule = append(rule, "--on-ip", nm.onIP.String())
+		}
+		rules = append(rules, append(rule, "--tproxy-mark", mark))
+	}
+
+	for _, rule := range rules {
+		args := append([]string{"-t", "mangle", "-A", tproxyChain}, rule...)
+		if err := runCommand(family.iptables, args...); err != nil {
+			return err
+		}
+	}
+
+	if err := runCommand(family.iptables, "-t", "mangle", "-A", "PREROUTING", "-j", tproxyChain); err != nil {
+		return err
+	}
+
+	log.Debugf("%s tproxy rules installed", family.iptables)
+	return nil
+}
+
+func (nm *TProxyNetworkManager) Cleanup() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.cleanupLocked()
+	return nil
+}
+
+// cleanupLocked removes the rules of both families. It does not track
+// what was installed, so it also clears leftovers of an earlier run;
+// errors for rules that are not there are expected.
+func (nm *TProxyNetworkManager) cleanupLocked() {
+	mark := strconv.Itoa(tproxyMark)
+	table := strconv.Itoa(tproxyTable)
+
+	for _, family := range netFamilies {
+		for runCommand(family.iptables, "-t", "mangle", "-D", "PREROUTING", "-j", tproxyChain) == nil {
+			// the hook may have been added more than once
+		}
+		if runCommand(family.iptables, "-t", "mangle", "-F", tproxyChain) == nil {
+			if err := runCommand(family.iptables, "-t", "mangle", "-X", tproxyChain); err != nil {
+				log.WithError(err).Warnf("Failed to delete %s chain", family.iptables)
+			}
+		}
+
+		for runCommand("ip", family.ipFlag, "rule", "del", "fwmark", mark, "lookup", table) == nil {
+			// same for the policy rule
+		}
+		runCommand("ip", family.ipFlag, "route", "flush", "table", table)
+	}
+}
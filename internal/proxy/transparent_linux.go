@@ -0,0 +1,118 @@
+//go:build linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// directMark marks sockets of direct routes so the transparent modes do
+// not capture them again.
+const directMark = 0x5847
+
+// netFamily holds what the transparent modes need to know about an
+// address family to install their rules.
+type netFamily struct {
+	iptables     string
+	ipFlag       string
+	ipv6         bool
+	defaultRoute string
+	reserved     []string
+}
+
+var netFamilies = []netFamily{
+	{
+		iptables:     "iptables",
+		ipFlag:       "-4",
+		defaultRoute: "0.0.0.0/0",
+		reserved: []string{
+			"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+			"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+		},
+	},
+	{
+		iptables:     "ip6tables",
+		ipFlag:       "-6",
+		ipv6:         true,
+		defaultRoute: "::/0",
+		reserved:     []string{"::1/128", "fc00::/7", "fe80::/10", "ff00::/8"},
+	},
+}
+
+// resolveBypass resolves host:port bypass addresses. SSH servers given by
+// name are excluded with every address they resolve to.
+func resolveBypass(hostPorts []string) []*net.TCPAddr {
+	var addrs []*net.TCPAddr
+	for _, hostPort := range hostPorts {
+		host, portStr, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			log.WithError(err).Warnf("Invalid bypass address %q", hostPort)
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			log.WithError(err).Warnf("Invalid bypass port %q", hostPort)
+			continue
+		}
+
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			if ips, err = net.LookupIP(host); err != nil {
+				log.WithError(err).Warnf("Failed to resolve %s, its traffic may be captured", host)
+				continue
+			}
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, &net.TCPAddr{IP: ip, Port: port})
+		}
+	}
+	return addrs
+}
+
+// transparentControl lets a socket bind to and accept traffic for
+// addresses that are not local, as TPROXY requires.
+func transparentControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			sockErr = err
+			return
+		}
+		if strings.HasSuffix(network, "6") {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func markSocket(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, directMark)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func runCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
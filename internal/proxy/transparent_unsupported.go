@@ -0,0 +1,28 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+
+	"xengate/internal/models"
+	"xengate/internal/tunnel"
+)
+
+func NewRedirectProxy(ip string, port int16, manager *tunnel.Manager, opts *Options) (Proxy, error) {
+	return nil, errors.New("redirect mode is only supported on Linux")
+}
+
+func NewTProxy(ip string, port int16, manager *tunnel.Manager, opts *Options) (Proxy, error) {
+	return nil, errors.New("tproxy mode is only supported on Linux")
+}
+
+func NewTunTapProxy(config *models.TunConfig, manager *tunnel.Manager, opts *Options) (Proxy, error) {
+	return nil, errors.New("tun mode is only supported on Linux")
+}
+
+// RecoverNetwork has nothing to roll back where the TUN and transparent
+// modes are not supported.
+func RecoverNetwork(stateDir string) error {
+	return nil
+}
@@ -0,0 +1,149 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+)
+
+const (
+	fakeIPStateFile    = "fakeip.json"
+	fakeIPSaveInterval = time.Minute
+	dnsPort            = 53
+)
+
+// isFakeDNS reports whether a flow goes to the fake IP responder.
+func (t *TunTapProxy) isFakeDNS(addr tcpip.Address) bool {
+	return t.fakeIP != nil && net.IP(addr.AsSlice()).Equal(t.gateway)
+}
+
+// flowTarget returns the target of a flow to addr:port for the manager. A
+// fake address is mapped back to the name it was handed out for; ok is
+// false if it is not mapped anymore.
+func (t *TunTapProxy) flowTarget(addr tcpip.Address, port uint16) (string, bool) {
+	host := addr.String()
+	if ip := net.IP(addr.AsSlice()); t.fakeIP != nil && t.fakeIP.Contains(ip) {
+		name, ok := t.fakeIP.Lookup(ip)
+		if !ok {
+			return "", false
+		}
+		host = name
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), true
+}
+
+// serveFakeDNS answers the queries of a UDP flow to the fake IP responder
+// until it is idle for tunUDPTimeout.
+func (t *TunTapProxy) serveFakeDNS(conn *gonet.UDPConn, clientIP string) {
+	defer conn.Close()
+
+	buf := make([]byte, maxDNSMsgSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tunUDPTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		if resp := t.dnsServer.handleQuery(context.Background(), clientIP, query, true); resp != nil {
+			if _, err := conn.Write(resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (t *TunTapProxy) fakeIPStatePath() string {
+	if t.stateDir == "" {
+		return ""
+	}
+	return filepath.Join(t.stateDir, fakeIPStateFile)
+}
+
+// loadFakeIP restores the mappings of the previous run, so clients that
+// still cache a fake address reach the same name.
+func (t *TunTapProxy) loadFakeIP() {
+	path := t.fakeIPStatePath()
+	if path == "" {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).Warn("خطا در خواندن نگاشت IP‌های جعلی")
+		}
+		return
+	}
+	defer f.Close()
+
+	if err := t.fakeIP.Load(f); err != nil {
+		log.WithError(err).Warn("خطا در خواندن نگاشت IP‌های جعلی")
+		return
+	}
+	log.Debugf("%d نگاشت IP جعلی بازیابی شد", t.fakeIP.Len())
+}
+
+// saveFakeIP writes the mappings to the state directory.
+func (t *TunTapProxy) saveFakeIP() error {
+	path := t.fakeIPStatePath()
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(t.stateDir, 0700); err != nil {
+		return err
+	}
+	return replaceFile(path, func(tmp string) error {
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if err := t.fakeIP.Save(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// saveFakeIPLoop saves new mappings periodically, so a crash loses at most
+// fakeIPSaveInterval of them, and once more when ctx is done.
+func (t *TunTapProxy) saveFakeIPLoop(ctx context.Context) {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(fakeIPSaveInterval)
+	defer ticker.Stop()
+
+	saved := t.fakeIP.Changes()
+	save := func() {
+		changes := t.fakeIP.Changes()
+		if changes == saved {
+			return
+		}
+		if err := t.saveFakeIP(); err != nil {
+			log.WithError(err).Warn("خطا در ذخیره نگاشت IP‌های جعلی")
+			return
+		}
+		saved = changes
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			save()
+		case <-ctx.Done():
+			save()
+			return
+		}
+	}
+}
@@ -0,0 +1,374 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"xengate/internal/tunnel"
+
+	log "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	defaultTunMTU     = 1500
+	tunNICID          = 1
+	tunQueueSize      = 1024
+	tunTCPMaxInFlight = 1024
+	tunConnectTimeout = 30 * time.Second
+	tunUDPTimeout     = 60 * time.Second
+)
+
+// newTunStack creates the userspace network stack that terminates the
+// flows read from the TUN device. The NIC is promiscuous and spoofing, so
+// it accepts packets for any destination and answers as that destination;
+// the forwarders turn every new TCP connection and UDP flow into a
+// connection handed to the manager.
+func (t *TunTapProxy) newTunStack() (*stack.Stack, *channel.Endpoint, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
+	})
+
+	endpoint := channel.New(tunQueueSize, uint32(t.mtu), "")
+	if err := s.CreateNIC(tunNICID, endpoint); err != nil {
+		s.Close()
+		return nil, nil, fmt.Errorf("failed to create NIC: %s", err)
+	}
+	if err := s.SetPromiscuousMode(tunNICID, true); err != nil {
+		s.Close()
+		return nil, nil, fmt.Errorf("failed to enable promiscuous mode: %s", err)
+	}
+	if err := s.SetSpoofing(tunNICID, true); err != nil {
+		s.Close()
+		return nil, nil, fmt.Errorf("failed to enable spoofing: %s", err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: tunNICID},
+		{Destination: header.IPv6EmptySubnet, NIC: tunNICID},
+	})
+
+	sack := tcpip.TCPSACKEnabled(true)
+	s.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+
+	tcpForwarder := tcp.NewForwarder(s, 0, tunTCPMaxInFlight, t.handleTCP)
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpForwarder.HandlePacket)
+	udpForwarder := udp.NewForwarder(s, t.handleUDP)
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, udpForwarder.HandlePacket)
+
+	return s, endpoint, nil
+}
+
+// readDevice injects the packets read from the TUN device into the stack.
+func (t *TunTapProxy) readDevice() {
+	defer t.wg.Done()
+
+	packet := make([]byte, t.mtu)
+	for {
+		n, err := t.ifce.Read(packet)
+		if err != nil {
+			if !isNormalError(err) {
+				log.Errorf("خطا در خواندن از TUN: %v", err)
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		atomic.AddInt64(&t.totalBytes, int64(n))
+
+		var protocol tcpip.NetworkProtocolNumber
+		switch header.IPVersion(packet[:n]) {
+		case header.IPv4Version:
+			protocol = header.IPv4ProtocolNumber
+		case header.IPv6Version:
+			protocol = header.IPv6ProtocolNumber
+		default:
+			continue
+		}
+
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(append([]byte(nil), packet[:n]...)),
+		})
+		t.endpoint.InjectInbound(protocol, pkt)
+		pkt.DecRef()
+	}
+}
+
+// writeDevice writes the packets sent by the stack to the TUN device.
+func (t *TunTapProxy) writeDevice(ctx context.Context) {
+	defer t.wg.Done()
+
+	for {
+		pkt := t.endpoint.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+
+		view := pkt.ToView()
+		n, err := t.ifce.Write(view.AsSlice())
+		view.Release()
+		pkt.DecRef()
+		if err != nil {
+			if !isNormalError(err) {
+				log.Debugf("خطا در نوشتن در TUN: %v", err)
+			}
+			continue
+		}
+		atomic.AddInt64(&t.totalBytes, int64(n))
+	}
+}
+
+// handleTCP connects to the destination of a new TCP flow before the
+// handshake with the client completes, so failures reach the client as a
+// reset.
+func (t *TunTapProxy) handleTCP(r *tcp.ForwarderRequest) {
+	id := r.ID()
+	if t.isFakeDNS(id.LocalAddress) {
+		t.handleFakeDNS(r)
+		return
+	}
+
+	// The local side of the flow is the destination the client dialed.
+	target, ok := t.flowTarget(id.LocalAddress, id.LocalPort)
+	if !ok {
+		log.Debugf("آدرس جعلی %s نگاشتی ندارد", id.LocalAddress)
+		r.Complete(true)
+		return
+	}
+	meta := &tunnel.Metadata{
+		Inbound:  "tun",
+		ClientIP: id.RemoteAddress.String(),
+		Target:   target,
+	}
+
+	// Denied clients are reset before the handshake completes.
+	release, err := t.manager.AdmitConnection(meta.ClientIP, meta.Inbound)
+	if err != nil {
+		log.Debugf("اتصال TCP از %s رد شد: %v", meta.ClientIP, err)
+		r.Complete(true)
+		return
+	}
+	defer release()
+
+	if t.manager.ShouldSniff(meta) {
+		t.handleSniffedTCP(r, meta)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tunConnectTimeout)
+	session, err := t.manager.Connect(ctx, meta)
+	cancel()
+	if err != nil {
+		log.Debugf("خطا در اتصال TCP به %s: %v", meta.Target, err)
+		r.Complete(true)
+		return
+	}
+	defer session.Close()
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		log.Debugf("خطا در ایجاد اتصال TCP: %s", tcpErr)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	conn := gonet.NewTCPConn(&wq, ep)
+	defer conn.Close()
+
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
+
+	if err := session.Relay(conn); err != nil && !isNormalError(err) {
+		log.Debugf("خطا در انتقال TCP: %v", err)
+	}
+}
+
+// handleSniffedTCP completes the handshake first, since the client only
+// sends the bytes the domain is sniffed from afterwards. Clients refused
+// by the checks that do not need the domain are still reset; failures to
+// connect then close the connection instead.
+func (t *TunTapProxy) handleSniffedTCP(r *tcp.ForwarderRequest, meta *tunnel.Metadata) {
+	endSession, err := t.manager.StartFlow(meta)
+	if err != nil {
+		log.Debugf("اتصال TCP به %s رد شد: %v", meta.Target, err)
+		r.Complete(true)
+		return
+	}
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		log.Debugf("خطا در ایجاد اتصال TCP: %s", tcpErr)
+		endSession()
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	conn := gonet.NewTCPConn(&wq, ep)
+	defer conn.Close()
+
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
+
+	if err := t.manager.ForwardStarted(conn, meta, endSession); err != nil && !isNormalError(err) {
+		log.Debugf("خطا در انتقال TCP به %s: %v", meta.Target, err)
+	}
+}
+
+// handleFakeDNS answers the DNS queries of a TCP connection to the fake IP
+// responder. Other ports of the gateway are refused.
+func (t *TunTapProxy) handleFakeDNS(r *tcp.ForwarderRequest) {
+	id := r.ID()
+	if id.LocalPort != dnsPort {
+		r.Complete(true)
+		return
+	}
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		log.Debugf("خطا در ایجاد اتصال TCP: %s", tcpErr)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	t.dnsServer.serveStream(context.Background(), gonet.NewTCPConn(&wq, ep))
+}
+
+// handleUDP creates the endpoint of a new UDP flow. It runs on the stack's
+// packet path, so the relay runs in its own goroutine.
+func (t *TunTapProxy) handleUDP(r *udp.ForwarderRequest) {
+	id := r.ID()
+
+	fakeDNS := t.isFakeDNS(id.LocalAddress)
+	if fakeDNS && id.LocalPort != dnsPort {
+		return
+	}
+	target, ok := t.flowTarget(id.LocalAddress, id.LocalPort)
+	if !ok {
+		log.Debugf("آدرس جعلی %s نگاشتی ندارد", id.LocalAddress)
+		return
+	}
+
+	// Denied clients get no endpoint, so their datagrams are dropped.
+	release := func() {}
+	if !fakeDNS {
+		var err error
+		release, err = t.manager.AdmitConnection(id.RemoteAddress.String(), "tun")
+		if err != nil {
+			log.Debugf("جریان UDP از %s رد شد: %v", id.RemoteAddress, err)
+			return
+		}
+	}
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		log.Debugf("خطا در ایجاد اتصال UDP: %s", tcpErr)
+		release()
+		return
+	}
+	conn := gonet.NewUDPConn(&wq, ep)
+
+	if fakeDNS {
+		go t.serveFakeDNS(conn, id.RemoteAddress.String())
+		return
+	}
+
+	meta := &tunnel.Metadata{
+		Inbound:  "tun",
+		ClientIP: id.RemoteAddress.String(),
+		Target:   target,
+	}
+	go func() {
+		defer release()
+		t.relayUDP(conn, meta)
+	}()
+}
+
+// relayUDP carries one UDP flow over the tunnel UDP transport until it is
+// idle for tunUDPTimeout. Names mapped back from fake addresses are
+// resolved through the tunnel.
+func (t *TunTapProxy) relayUDP(conn *gonet.UDPConn, meta *tunnel.Metadata) {
+	defer conn.Close()
+
+	relay, err := t.manager.OpenUDP(meta)
+	if err != nil {
+		log.Debugf("خطا در انتقال UDP به %s: %v", meta.Target, err)
+		return
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tunConnectTimeout)
+	dst, err := t.manager.ResolveUDPAddr(ctx, meta.Target)
+	cancel()
+	if err != nil {
+		log.Debugf("خطا در انتقال UDP به %s: %v", meta.Target, err)
+		return
+	}
+
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
+
+	var lastActive atomic.Int64
+	touch := func() { lastActive.Store(time.Now().UnixNano()) }
+	touch()
+
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, maxUDPDatagram)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// The endpoint can only answer as the flow destination.
+			if !from.IP.Equal(dst.IP) || from.Port != dst.Port {
+				continue
+			}
+			touch()
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tunUDPTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() &&
+				time.Since(time.Unix(0, lastActive.Load())) < tunUDPTimeout {
+				continue
+			}
+			return
+		}
+		touch()
+		if err := relay.WriteTo(buf[:n], dst, meta.Target); err != nil {
+			log.Debugf("خطا در انتقال UDP به %s: %v", meta.Target, err)
+			return
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build linux
+
 package proxy
 
 import (
@@ -6,442 +8,274 @@ import (
 	"io"
 	"net"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
 
+	"xengate/internal/dns"
+	"xengate/internal/models"
 	"xengate/internal/tunnel"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/songgao/water"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
 type TunTapProxy struct {
-	manager    *tunnel.Manager
-	ifce       *water.Interface
-	netManager *NetworkManager
-	wg         sync.WaitGroup
-	mu         sync.RWMutex
-	closed     bool
-	ip         string
-	port       int16
-	name       string
-	active     int64
-	totalBytes int64
+	manager       *tunnel.Manager
+	ifce          *water.Interface
+	netManager    *NetworkManager
+	stack         *stack.Stack
+	endpoint      *channel.Endpoint
+	fakeIP        *dns.FakeIPPool
+	dnsServer     *DNSServer
+	gateway       net.IP
+	stateDir      string
+	cancel        context.CancelFunc
+	releaseDialer func()
+	wg            sync.WaitGroup
+	mu            sync.RWMutex
+	closed        bool
+	name          string
+	mtu           int
+	active        int64
+	totalBytes    int64
 }
 
-type NetworkManager struct {
-	iface         string
-	ip            string
-	origSysctl    map[string]string
-	iptablesRules []string
-	routes        []string
-	mu            sync.Mutex
-}
+const (
+	defaultTunDevice  = "tun0"
+	defaultTunAddress = "10.0.0.1/24"
+	// defaultTunIPv6 is the IPv6 address of the TUN interface. The stack
+	// answers for every destination, so any unique local address works.
+	defaultTunIPv6 = "fd00:7867::1"
+)
 
 func init() {
 	// تنظیم سطح لاگ
 	log.SetLevel(log.DebugLevel)
 }
 
-func NewTunTapProxy(name, ip string, port int16, manager *tunnel.Manager) (*TunTapProxy, error) {
+// NewTunTapProxy creates the TUN inbound described by config; a nil config
+// uses the defaults.
+func NewTunTapProxy(config *models.TunConfig, manager *tunnel.Manager, opts *Options) (*TunTapProxy, error) {
 	// چک کردن دسترسی root
 	if os.Geteuid() != 0 {
 		return nil, fmt.Errorf("این برنامه باید با دسترسی root اجرا شود")
 	}
 
-	netManager := NewNetworkManager(name, ip)
-	return &TunTapProxy{
-		manager:    manager,
-		ip:         ip,
-		port:       port,
-		name:       name,
-		netManager: netManager,
-	}, nil
-}
+	config, err := normalizeTunConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
-func NewNetworkManager(iface, ip string) *NetworkManager {
-	return &NetworkManager{
-		iface:      iface,
-		ip:         ip,
-		origSysctl: make(map[string]string),
-		iptablesRules: []string{
-			"INPUT -i %s -j ACCEPT",
-			"OUTPUT -o %s -j ACCEPT",
-			"nat -t nat -A POSTROUTING -o eth0 -j MASQUERADE",
-			"FORWARD -i %s -o eth0 -j ACCEPT",
-			"FORWARD -i eth0 -o %s -m state --state RELATED,ESTABLISHED -j ACCEPT",
-		},
-		routes: []string{
-			"0.0.0.0/0 via %s dev %s",
-		},
+	t := &TunTapProxy{
+		manager:    manager,
+		name:       config.DeviceName,
+		mtu:        config.MTU,
+		stateDir:   opts.stateDir(),
+		netManager: NewNetworkManager(config, defaultTunIPv6, opts.bypass(), opts.stateDir()),
 	}
+	if config.FakeIP {
+		t.fakeIP, err = dns.NewFakeIPPool(config.FakeIPRange, dns.DefaultFakeIPSize)
+		if err != nil {
+			return nil, err
+		}
+		t.gateway = net.ParseIP(config.Gateway).To4()
+	}
+	return t, nil
 }
 
-func (t *TunTapProxy) Start(ctx context.Context) error {
-	log.Info("شروع پروکسی TUN/TAP...")
-
-	// ایجاد رابط TUN
-	config := water.Config{
-		DeviceType: water.TUN,
-		PlatformSpecificParams: water.PlatformSpecificParams{
-			Name: t.name,
-		},
+// normalizeTunConfig validates config and returns a copy with the defaults
+// filled in. A plain address gets a /24 network; plain route addresses
+// become host routes.
+func normalizeTunConfig(config *models.TunConfig) (*models.TunConfig, error) {
+	c := models.TunConfig{}
+	if config != nil {
+		c = *config
 	}
 
-	ifce, err := water.New(config)
-	if err != nil {
-		return fmt.Errorf("خطا در ایجاد رابط TUN: %w", err)
+	c.DeviceName = strings.TrimSpace(c.DeviceName)
+	if c.DeviceName == "" {
+		c.DeviceName = defaultTunDevice
 	}
-
-	t.ifce = ifce
-	log.Infof("رابط TUN %s ایجاد شد", ifce.Name())
-
-	// تنظیم پیکربندی شبکه
-	if err := t.netManager.Setup(); err != nil {
-		t.ifce.Close()
-		return fmt.Errorf("خطا در تنظیم شبکه: %w", err)
+	if len(c.DeviceName) >= 16 {
+		return nil, fmt.Errorf("نام رابط TUN بیش از حد طولانی است: %s", c.DeviceName)
 	}
 
-	// تنظیم آدرس IP
-	if err := t.configureInterface(); err != nil {
-		t.netManager.Cleanup()
-		t.ifce.Close()
-		return fmt.Errorf("خطا در تنظیم رابط: %w", err)
+	c.Address = strings.TrimSpace(c.Address)
+	if c.Address == "" {
+		c.Address = defaultTunAddress
 	}
-
-	// شروع پردازش بسته‌ها
-	t.wg.Add(1)
-	go t.handlePackets(ctx)
-
-	// مدیریت خاموش شدن
-	go func() {
-		<-ctx.Done()
-		t.Stop()
-	}()
-
-	log.Info("پروکسی TUN/TAP با موفقیت شروع شد")
-	return nil
-}
-
-func (t *TunTapProxy) configureInterface() error {
-	cmd := exec.Command("ip", "addr", "add", t.ip+"/24", "dev", t.name)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("خطا در تنظیم آدرس IP: %w", err)
+	if !strings.Contains(c.Address, "/") {
+		c.Address += "/24"
 	}
-
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "up")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("خطا در فعال کردن رابط: %w", err)
+	if ip, _, err := net.ParseCIDR(c.Address); err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("آدرس TUN نامعتبر: %s", c.Address)
 	}
 
-	return nil
-}
-
-func (nm *NetworkManager) Setup() error {
-	nm.mu.Lock()
-	defer nm.mu.Unlock()
-
-	log.Info("تنظیم پیکربندی شبکه...")
-
-	// ذخیره مقادیر اصلی sysctl
-	sysctls := []string{
-		"net.ipv4.ip_forward",
-		"net.core.rmem_max",
-		"net.core.wmem_max",
+	if c.MTU == 0 {
+		c.MTU = defaultTunMTU
+	}
+	if c.MTU < 576 || c.MTU > 65535 {
+		return nil, fmt.Errorf("MTU نامعتبر: %d", c.MTU)
 	}
 
-	for _, s := range sysctls {
-		val, err := nm.getSysctl(s)
+	if c.FakeIP {
+		if c.FakeIPRange == "" {
+			c.FakeIPRange = dns.DefaultFakeIPRange
+		}
+		gateway, err := tunGateway(c.Address, c.Gateway)
 		if err != nil {
-			return fmt.Errorf("خطا در خواندن %s: %w", s, err)
+			return nil, err
 		}
-		nm.origSysctl[s] = val
-		log.Debugf("مقدار اصلی %s = %s ذخیره شد", s, val)
+		c.Gateway = gateway
 	}
 
-	// فعال کردن IP forwarding و تنظیم پارامترهای شبکه
-	if err := nm.setSysctl("net.ipv4.ip_forward", "1"); err != nil {
-		return err
-	}
-	if err := nm.setSysctl("net.core.rmem_max", "26214400"); err != nil {
-		return err
+	var err error
+	if c.DNSServers, err = parseTunList(c.DNSServers, false); err != nil {
+		return nil, err
 	}
-	if err := nm.setSysctl("net.core.wmem_max", "26214400"); err != nil {
-		return err
-	}
-
-	// تنظیم قوانین iptables
-	for _, rule := range nm.iptablesRules {
-		rule = fmt.Sprintf(rule, nm.iface)
-		if strings.Contains(rule, "nat") {
-			args := strings.Split(rule, " ")
-			cmd := exec.Command("iptables", args...)
-			if output, err := cmd.CombinedOutput(); err != nil {
-				log.WithError(err).Errorf("خطا در اجرای دستور iptables: %s", string(output))
-				return fmt.Errorf("خطا در تنظیم قانون iptables: %w", err)
-			}
-		} else {
-			args := append([]string{"-A"}, strings.Split(rule, " ")...)
-			cmd := exec.Command("iptables", args...)
-			if output, err := cmd.CombinedOutput(); err != nil {
-				log.WithError(err).Errorf("خطا در اجرای دستور iptables: %s", string(output))
-				return fmt.Errorf("خطا در تنظیم قانون iptables: %w", err)
-			}
-		}
-		log.Debugf("قانون iptables اضافه شد: %s", rule)
+	if c.IncludeRoutes, err = parseTunList(c.IncludeRoutes, true); err != nil {
+		return nil, err
 	}
-
-	// تنظیم مسیریابی
-	for _, route := range nm.routes {
-		route = fmt.Sprintf(route, nm.ip, nm.iface)
-		args := append([]string{"route", "add"}, strings.Split(route, " ")...)
-		cmd := exec.Command("ip", args...)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			log.WithError(err).Errorf("خطا در اجرای دستور ip route: %s", string(output))
-			return fmt.Errorf("خطا در تنظیم مسیریابی: %w", err)
-		}
-		log.Debugf("مسیر اضافه شد: %s", route)
+	if c.ExcludeRoutes, err = parseTunList(c.ExcludeRoutes, true); err != nil {
+		return nil, err
 	}
-
-	log.Info("پیکربندی شبکه با موفقیت انجام شد")
-	return nil
+	return &c, nil
 }
 
-func (nm *NetworkManager) Cleanup() error {
-	nm.mu.Lock()
-	defer nm.mu.Unlock()
-
-	log.Info("پاکسازی پیکربندی شبکه...")
-
-	// بازگرداندن مقادیر اصلی sysctl
-	for k, v := range nm.origSysctl {
-		if err := nm.setSysctl(k, v); err != nil {
-			log.WithError(err).Warnf("خطا در بازگرداندن %s", k)
-		} else {
-			log.Debugf("مقدار %s به %s بازگردانده شد", k, v)
-		}
-	}
-
-	// حذف قوانین iptables
-	for _, rule := range nm.iptablesRules {
-		rule = fmt.Sprintf(rule, nm.iface)
-		if strings.Contains(rule, "nat") {
-			args := strings.Split(strings.Replace(rule, "-A", "-D", 1), " ")
-			cmd := exec.Command("iptables", args...)
-			if err := cmd.Run(); err != nil {
-				log.WithError(err).Warnf("خطا در حذف قانون iptables: %s", rule)
-			}
-		} else {
-			args := append([]string{"-D"}, strings.Split(rule, " ")...)
-			cmd := exec.Command("iptables", args...)
-			if err := cmd.Run(); err != nil {
-				log.WithError(err).Warnf("خطا در حذف قانون iptables: %s", rule)
+// tunGateway validates the gateway of the TUN network, the address of the
+// fake IP responder. It defaults to the address after that of the
+// interface; the interface address itself would be answered by the kernel.
+func tunGateway(address, gateway string) (string, error) {
+	ip, network, _ := net.ParseCIDR(address)
+	ip = ip.To4()
+
+	gateway = strings.TrimSpace(gateway)
+	if gateway == "" {
+		next := make(net.IP, net.IPv4len)
+		copy(next, ip)
+		for i := len(next) - 1; i >= 0; i-- {
+			if next[i]++; next[i] != 0 {
+				break
 			}
 		}
-		log.Debugf("قانون iptables حذف شد: %s", rule)
-	}
-
-	// حذف مسیرها
-	for _, route := range nm.routes {
-		route = fmt.Sprintf(route, nm.ip, nm.iface)
-		args := append([]string{"route", "del"}, strings.Split(route, " ")...)
-		cmd := exec.Command("ip", args...)
-		if err := cmd.Run(); err != nil {
-			log.WithError(err).Warnf("خطا در حذف مسیر: %s", route)
-		} else {
-			log.Debugf("مسیر حذف شد: %s", route)
-		}
+		gateway = next.String()
 	}
 
-	log.Info("پاکسازی شبکه تکمیل شد")
-	return nil
-}
-
-func (nm *NetworkManager) setSysctl(key, value string) error {
-	log.Debugf("تنظیم sysctl %s = %s", key, value)
-	cmd := exec.Command("sysctl", "-w", fmt.Sprintf("%s=%s", key, value))
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("خطا در تنظیم sysctl: %w: %s", err, string(output))
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = network.IP[i] | ^network.Mask[i]
 	}
-	return nil
-}
 
-func (nm *NetworkManager) getSysctl(key string) (string, error) {
-	cmd := exec.Command("sysctl", "-n", key)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("خطا در خواندن sysctl: %w: %s", err, string(output))
+	gw := net.ParseIP(gateway).To4()
+	if gw == nil || gw.Equal(ip) || !network.Contains(gw) ||
+		gw.Equal(network.IP) || gw.Equal(broadcast) {
+		return "", fmt.Errorf("دروازه TUN نامعتبر: %s", gateway)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return gw.String(), nil
 }
 
-func (t *TunTapProxy) handlePackets(ctx context.Context) {
-	defer t.wg.Done()
-
-	packet := make([]byte, 2048)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			n, err := t.ifce.Read(packet)
-			if err != nil {
-				if err != io.EOF && !strings.Contains(err.Error(), "closed") {
-					log.Errorf("خطا در خواندن از TUN: %v", err)
-				}
-				return
-			}
-
-			atomic.AddInt64(&t.totalBytes, int64(n))
-
-			if n < 20 {
-				continue // خیلی کوتاه برای هدر IP
-			}
-
-			version := packet[0] >> 4
-			if version != 4 {
-				continue // فقط IPv4 پشتیبانی می‌شود
+// parseTunList trims and validates a list of addresses, or of CIDRs when
+// cidr is set. Empty items are dropped.
+func parseTunList(items []string, cidr bool) ([]string, error) {
+	var out []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !cidr {
+			if net.ParseIP(item) == nil {
+				return nil, fmt.Errorf("آدرس DNS نامعتبر: %s", item)
 			}
+			out = append(out, item)
+			continue
+		}
 
-			protocol := packet[9]
-			srcIP := net.IP(packet[12:16])
-			dstIP := net.IP(packet[16:20])
-
-			switch protocol {
-			case 6: // TCP
-				t.handleTCP(packet[:n], srcIP, dstIP)
-			case 17: // UDP
-				t.handleUDP(packet[:n], srcIP, dstIP)
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
 			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("مسیر نامعتبر: %s", item)
 		}
+		out = append(out, ipNet.String())
 	}
+	return out, nil
 }
 
-func (t *TunTapProxy) handleTCP(packet []byte, srcIP, dstIP net.IP) {
-	srcPort := uint16(packet[20])<<8 | uint16(packet[21])
-	dstPort := uint16(packet[22])<<8 | uint16(packet[23])
-
-	target := fmt.Sprintf("%s:%d", dstIP.String(), dstPort)
-	log.Debugf("TCP: %s:%d -> %s", srcIP, srcPort, target)
-
-	clientConn, serverConn := net.Pipe()
-
-	atomic.AddInt64(&t.active, 1)
-	defer atomic.AddInt64(&t.active, -1)
-
-	go func() {
-		defer clientConn.Close()
-		defer serverConn.Close()
-
-		if err := t.manager.Forward(serverConn, target); err != nil {
-			if !isNormalError(err) {
-				log.Debugf("خطا در انتقال TCP: %v", err)
-			}
-		}
-	}()
-
-	go func() {
-		if _, err := clientConn.Write(packet[20:]); err != nil && !isNormalError(err) {
-			log.Debugf("خطا در نوشتن در تونل: %v", err)
-			return
-		}
-	}()
+func (t *TunTapProxy) Start(ctx context.Context) error {
+	log.Info("شروع پروکسی TUN/TAP...")
 
-	response := make([]byte, 1500)
-	for {
-		n, err := clientConn.Read(response[20:])
+	if t.fakeIP != nil {
+		dnsServer, err := newFakeIPServer(t.manager, t.fakeIP)
 		if err != nil {
-			if !isNormalError(err) {
-				log.Debugf("خطا در خواندن از تونل: %v", err)
-			}
-			return
+			return err
 		}
+		t.dnsServer = dnsServer
+		t.loadFakeIP()
+	}
 
-		t.buildIPHeader(response[:20], dstIP, srcIP, 6, uint16(n))
-
-		if _, err := t.ifce.Write(response[:20+n]); err != nil && !isNormalError(err) {
-			log.Debugf("خطا در نوشتن در TUN: %v", err)
-			return
-		}
+	// ایجاد رابط TUN
+	config := water.Config{
+		DeviceType: water.TUN,
+		PlatformSpecificParams: water.PlatformSpecificParams{
+			Name: t.name,
+		},
 	}
-}
 
-func (t *TunTapProxy) handleUDP(packet []byte, srcIP, dstIP net.IP) {
-	srcPort := uint16(packet[20])<<8 | uint16(packet[21])
-	dstPort := uint16(packet[22])<<8 | uint16(packet[23])
+	ifce, err := water.New(config)
+	if err != nil {
+		return fmt.Errorf("خطا در ایجاد رابط TUN: %w", err)
+	}
 
-	target := fmt.Sprintf("%s:%d", dstIP.String(), dstPort)
-	log.Debugf("UDP: %s:%d -> %s", srcIP, srcPort, target)
+	t.ifce = ifce
+	log.Infof("رابط TUN %s ایجاد شد", ifce.Name())
 
-	clientConn, serverConn := net.Pipe()
+	// تنظیم رابط و پیکربندی شبکه
+	if err := t.netManager.Setup(); err != nil {
+		t.netManager.Cleanup()
+		t.ifce.Close()
+		return fmt.Errorf("خطا در تنظیم شبکه: %w", err)
+	}
 
-	atomic.AddInt64(&t.active, 1)
-	defer atomic.AddInt64(&t.active, -1)
+	// راه‌اندازی پشته شبکه
+	s, endpoint, err := t.newTunStack()
+	if err != nil {
+		t.netManager.Cleanup()
+		t.ifce.Close()
+		return fmt.Errorf("خطا در ایجاد پشته شبکه: %w", err)
+	}
+	t.stack = s
+	t.endpoint = endpoint
 
-	go func() {
-		defer clientConn.Close()
-		defer serverConn.Close()
+	// اتصالات مستقیم علامت می‌خورند تا از مسیر اصلی بروند و به TUN برنگردند
+	t.releaseDialer = t.manager.UseDirectDialer(&net.Dialer{Control: markSocket})
 
-		if err := t.manager.Forward(serverConn, target); err != nil {
-			if !isNormalError(err) {
-				log.Debugf("خطا در انتقال UDP: %v", err)
-			}
-		}
-	}()
+	// شروع پردازش بسته‌ها
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(2)
+	go t.readDevice()
+	go t.writeDevice(ctx)
+	if t.fakeIP != nil {
+		t.wg.Add(1)
+		go t.saveFakeIPLoop(ctx)
+	}
 
+	// مدیریت خاموش شدن
 	go func() {
-		if _, err := clientConn.Write(packet[20:]); err != nil && !isNormalError(err) {
-			log.Debugf("خطا در نوشتن در تونل: %v", err)
-			return
-		}
+		<-ctx.Done()
+		t.Stop()
 	}()
 
-	response := make([]byte, 1500)
-	for {
-		n, err := clientConn.Read(response[20:])
-		if err != nil {
-			if !isNormalError(err) {
-				log.Debugf("خطا در خواندن از تونل: %v", err)
-			}
-			return
-		}
-
-		t.buildIPHeader(response[:20], dstIP, srcIP, 17, uint16(n))
-
-		if _, err := t.ifce.Write(response[:20+n]); err != nil && !isNormalError(err) {
-			log.Debugf("خطا در نوشتن در TUN: %v", err)
-			return
-		}
-	}
-}
-
-func (t *TunTapProxy) buildIPHeader(header []byte, srcIP, dstIP net.IP, protocol uint8, length uint16) {
-	header[0] = 0x45 // نسخه 4، طول هدر 5 کلمه
-	header[1] = 0x00 // DSCP/ECN
-	header[2] = byte(length >> 8)
-	header[3] = byte(length)
-	header[4] = 0x00 // شناسه
-	header[5] = 0x00
-	header[6] = 0x00 // پرچم‌ها/آفست قطعه
-	header[7] = 0x00
-	header[8] = 64 // TTL
-	header[9] = protocol
-	header[10] = 0x00 // چکسام (باید محاسبه شود)
-	header[11] = 0x00
-	copy(header[12:16], srcIP.To4())
-	copy(header[16:20], dstIP.To4())
-
-	// محاسبه چکسام
-	var sum uint32
-	for i := 0; i < 20; i += 2 {
-		sum += uint32(header[i])<<8 | uint32(header[i+1])
-	}
-	sum = (sum >> 16) + (sum & 0xffff)
-	sum += sum >> 16
-	header[10] = byte(^sum >> 8)
-	header[11] = byte(^sum)
+	log.Info("پروکسی TUN/TAP با موفقیت شروع شد")
+	return nil
 }
 
 func (t *TunTapProxy) Stop() error {
@@ -458,14 +292,27 @@ func (t *TunTapProxy) Stop() error {
 	if err := t.netManager.Cleanup(); err != nil {
 		log.WithError(err).Warn("خطا در پاکسازی پیکربندی شبکه")
 	}
+	if t.releaseDialer != nil {
+		t.releaseDialer()
+	}
 
+	if t.cancel != nil {
+		t.cancel()
+	}
 	if t.ifce != nil {
 		if err := t.ifce.Close(); err != nil {
 			log.WithError(err).Warn("خطا در بستن رابط TUN")
 		}
 	}
+	if t.stack != nil {
+		// بستن پشته همه اتصالات باز را می‌بندد
+		t.stack.Close()
+	}
 
 	t.wg.Wait()
+	if t.stack != nil {
+		t.stack.Wait()
+	}
 	log.Info("پروکسی TUN/TAP متوقف شد")
 	return nil
 }
@@ -701,7 +548,7 @@ func isNormalError(err error) bool {
 //     totalBytes int64
 // }
 
-// func NewTunTapProxy(name, ip string, port int16, manager *tunnel.Manager) (*TunTapProxy, error) {
+// func NewTunTapProxy(name, ip string, port int16, manager *tunnel.Manager, opts *Options) (*TunTapProxy, error) {
 //     // چک کردن دسترسی root
 //     if os.Geteuid() != 0 {
 //         return nil, fmt.Errorf("این برنامه باید با دسترسی root اجرا شود")
@@ -0,0 +1,333 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	caCertName     = "ca.pem"
+	caKeyName      = "ca-key.pem"
+	serverCertName = "server.pem"
+	serverKeyName  = "server-key.pem"
+
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+	renewBefore    = 30 * 24 * time.Hour
+	reloadInterval = 10 * time.Second
+)
+
+// CertManager serves the certificate of TLS listeners. It either loads
+// user supplied PEM files or issues one from a local CA kept in dir, and
+// picks up changes to the files without restarting the listeners.
+type CertManager struct {
+	dir       string
+	certFile  string
+	keyFile   string
+	generated bool
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func NewCertManager(dir, certFile, keyFile string) (*CertManager, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+
+	c := &CertManager{
+		dir:      dir,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if certFile == "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+		}
+		c.generated = true
+		c.certFile = filepath.Join(dir, serverCertName)
+		c.keyFile = filepath.Join(dir, serverKeyName)
+		if err := c.ensureServerCert(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CAFile returns the path of the local CA certificate, which clients have
+// to trust when the certificate is self-issued.
+func (c *CertManager) CAFile() string {
+	if !c.generated {
+		return ""
+	}
+	return filepath.Join(c.dir, caCertName)
+}
+
+// TLSConfig returns a server configuration for a listener. Client
+// certificates are verified against cfg.ClientCAFile, or the local CA when
+// it is empty.
+func (c *CertManager) TLSConfig(cfg *models.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+
+	if cfg == nil || cfg.ClientAuth == models.ClientAuthNone {
+		return tlsConfig, nil
+	}
+
+	caFile := cfg.ClientCAFile
+	if caFile == "" {
+		if err := c.ensureCA(); err != nil {
+			return nil, err
+		}
+		caFile = filepath.Join(c.dir, caCertName)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	tlsConfig.ClientCAs = pool
+
+	switch cfg.ClientAuth {
+	case models.ClientAuthRequest:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case models.ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported client auth mode: %s", cfg.ClientAuth)
+	}
+	return tlsConfig, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The files are
+// checked for changes at most every reloadInterval.
+func (c *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= reloadInterval {
+		c.lastCheck = time.Now()
+		c.reloadLocked()
+	}
+	return c.cert, nil
+}
+
+func (c *CertManager) reloadLocked() {
+	if c.generated && c.cert.Leaf != nil && time.Until(c.cert.Leaf.NotAfter) < renewBefore {
+		if err := c.ensureServerCert(); err != nil {
+			log.WithError(err).Warn("Failed to renew proxy certificate")
+		}
+	}
+
+	modTime, err := c.filesModTime()
+	if err != nil || !modTime.After(c.modTime) {
+		return
+	}
+
+	if err := c.loadLocked(); err != nil {
+		// Keep serving the old certificate, the files may be mid-update.
+		log.WithError(err).Warn("Failed to reload proxy certificate")
+		return
+	}
+	log.WithField("cert", c.certFile).Info("Reloaded proxy certificate")
+}
+
+func (c *CertManager) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCheck = time.Now()
+	return c.loadLocked()
+}
+
+func (c *CertManager) loadLocked() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return fmt.Errorf("failed to stat certificate: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// filesModTime returns the latest modification time of the certificate
+// and key files.
+func (c *CertManager) filesModTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// ensureCA creates the local CA unless it already exists.
+func (c *CertManager) ensureCA() error {
+	certPath := filepath.Join(c.dir, caCertName)
+	keyPath := filepath.Join(c.dir, caKeyName)
+	if fileExists(certPath) && fileExists(keyPath) {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "XenGate Local CA", Organization: []string{"XenGate"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	if err := writeKeyPair(certPath, keyPath, der, key); err != nil {
+		return err
+	}
+
+	log.WithField("ca", certPath).Info("Generated local CA for TLS listeners")
+	return nil
+}
+
+// ensureServerCert issues a server certificate from the local CA for
+// localhost, the hostname and all local addresses, unless a valid one
+// exists.
+func (c *CertManager) ensureServerCert() error {
+	if err := c.ensureCA(); err != nil {
+		return err
+	}
+
+	if cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil &&
+			time.Until(leaf.NotAfter) > renewBefore {
+			return nil
+		}
+	}
+
+	ca, err := tls.LoadX509KeyPair(filepath.Join(c.dir, caCertName), filepath.Join(c.dir, caKeyName))
+	if err != nil {
+		return fmt.Errorf("failed to load local CA: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse local CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "XenGate Proxy", Organization: []string{"XenGate"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  localIPs(),
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create server certificate: %w", err)
+	}
+	return writeKeyPair(c.certFile, c.keyFile, der, key)
+}
+
+func writeKeyPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// Key first, so a reload never pairs a new certificate with an old key.
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", keyPath, err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", certPath, err)
+	}
+	return nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func localIPs() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"sync"
+
+	"xengate/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CredentialStore validates proxy usernames and passwords. It is shared by
+// all listeners.
+type CredentialStore interface {
+	Authenticate(username, password string) bool
+}
+
+// dummyHash is compared against for unknown users so that lookups for
+// existing and missing accounts take the same time.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("xengate"), bcrypt.DefaultCost)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+type StaticCredentialStore struct {
+	mu    sync.RWMutex
+	users map[string]*models.ProxyUser
+}
+
+func NewStaticCredentialStore(users []*models.ProxyUser) *StaticCredentialStore {
+	s := &StaticCredentialStore{}
+	s.SetUsers(users)
+	return s
+}
+
+func (s *StaticCredentialStore) SetUsers(users []*models.ProxyUser) {
+	byName := make(map[string]*models.ProxyUser, len(users))
+	for _, u := range users {
+		if u != nil && u.Username != "" {
+			byName[u.Username] = u
+		}
+	}
+
+	s.mu.Lock()
+	s.users = byName
+	s.mu.Unlock()
+}
+
+func (s *StaticCredentialStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+func (s *StaticCredentialStore) Authenticate(username, password string) bool {
+	s.mu.RLock()
+	user, ok := s.users[username]
+	s.mu.RUnlock()
+
+	if !ok || user.Disabled {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
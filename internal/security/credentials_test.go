@@ -0,0 +1,45 @@
+package security
+
+import (
+	"testing"
+
+	"xengate/internal/models"
+)
+
+func TestStaticCredentialStore(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStaticCredentialStore([]*models.ProxyUser{
+		{Username: "alice", PasswordHash: hash},
+		{Username: "bob", PasswordHash: hash, Disabled: true},
+		{Username: "", PasswordHash: hash},
+		nil,
+	})
+	if store.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", store.Len())
+	}
+
+	tests := []struct {
+		username, password string
+		want               bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"Alice", "secret", false},
+		{"bob", "secret", false}, // disabled
+		{"carol", "secret", false},
+		{"", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := store.Authenticate(tt.username, tt.password); got != tt.want {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+
+	store.SetUsers([]*models.ProxyUser{{Username: "carol", PasswordHash: hash}})
+	if store.Authenticate("alice", "secret") || !store.Authenticate("carol", "secret") {
+		t.Error("SetUsers did not replace the users")
+	}
+}
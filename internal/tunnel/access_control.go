@@ -34,6 +34,7 @@ type AccessControl struct {
 	mu           sync.RWMutex
 	rules        map[string]*models.AccessRule // key: rule ID
 	rulesByIP    map[string]string             // key: IP, value: rule ID
+	rulesByUser  map[string]string             // key: username, value: rule ID
 	status       map[string]*AccessStatus      // key: rule ID
 	defaultLimit time.Duration
 	// configManager common.ConfigManager
@@ -51,6 +52,7 @@ func NewAccessControl(defaultLimit time.Duration) *AccessControl {
 	ac := &AccessControl{
 		rules:        make(map[string]*models.AccessRule),
 		rulesByIP:    make(map[string]string),
+		rulesByUser:  make(map[string]string),
 		status:       make(map[string]*AccessStatus),
 		defaultLimit: defaultLimit,
 	}
@@ -124,15 +126,18 @@ func (ac *AccessControl) AddRule(rule *models.AccessRule) error {
 		ID:          rule.ID,
 		Title:       rule.Title,
 		IP:          rule.IP,
+		Username:    rule.Username,
 		IsMaster:    rule.IsMaster,
 		DailyLimit:  rule.DailyLimit,
 		Description: rule.Description,
+		DNSPolicy:   rule.DNSPolicy,
+		BlockLists:  rule.BlockLists,
 		CreatedAt:   rule.CreatedAt,
 		UpdatedAt:   rule.UpdatedAt,
 	}
 
 	ac.rules[newRule.ID] = newRule
-	ac.rulesByIP[newRule.IP] = newRule.ID
+	ac.indexRuleLocked(newRule)
 	ac.status[newRule.ID] = &AccessStatus{
 		RuleID:    newRule.ID,
 		ResetTime: time.Now(),
@@ -143,19 +148,37 @@ func (ac *AccessControl) AddRule(rule *models.AccessRule) error {
 	return ac.SaveRules()
 }
 
+func (ac *AccessControl) indexRuleLocked(rule *models.AccessRule) {
+	if rule.IP != "" {
+		ac.rulesByIP[NormalizeIP(rule.IP)] = rule.ID
+	}
+	if rule.Username != "" {
+		ac.rulesByUser[rule.Username] = rule.ID
+	}
+}
+
+func (ac *AccessControl) unindexRuleLocked(rule *models.AccessRule) {
+	if ip := NormalizeIP(rule.IP); ac.rulesByIP[ip] == rule.ID {
+		delete(ac.rulesByIP, ip)
+	}
+	if ac.rulesByUser[rule.Username] == rule.ID {
+		delete(ac.rulesByUser, rule.Username)
+	}
+}
+
 func (ac *AccessControl) UpdateRule(rule *models.AccessRule) error {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 
 	if existing, ok := ac.rules[rule.ID]; ok {
-		// Remove old IP mapping
-		delete(ac.rulesByIP, existing.IP)
+		// Remove old IP and user mappings
+		ac.unindexRuleLocked(existing)
 
 		// Update rule
 		rule.CreatedAt = existing.CreatedAt
 		rule.UpdatedAt = time.Now()
 		ac.rules[rule.ID] = rule
-		ac.rulesByIP[rule.IP] = rule.ID
+		ac.indexRuleLocked(rule)
 	}
 
 	return ac.SaveRules()
@@ -166,7 +189,7 @@ func (ac *AccessControl) DeleteRule(ruleID string) error {
 	defer ac.mu.Unlock()
 
 	if rule, ok := ac.rules[ruleID]; ok {
-		delete(ac.rulesByIP, rule.IP)
+		ac.unindexRuleLocked(rule)
 		delete(ac.rules, ruleID)
 		delete(ac.status, ruleID)
 	}
@@ -201,12 +224,29 @@ func (ac *AccessControl) GetRuleByIP(ip string) (*models.AccessRule, *AccessStat
 	ac.mu.RLock()
 	defer ac.mu.RUnlock()
 
-	if ruleID, ok := ac.rulesByIP[ip]; ok {
+	if ruleID, ok := ac.rulesByIP[NormalizeIP(ip)]; ok {
 		return ac.rules[ruleID], ac.status[ruleID]
 	}
 	return nil, nil
 }
 
+// HasRule reports whether a rule applies to the client.
+func (ac *AccessControl) HasRule(ip, user string) bool {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	rule, _ := ac.getRuleLocked(ip, user)
+	return rule != nil
+}
+
+// HasUserRules reports whether any rule is mapped to a username, so a
+// client without a rule for its IP may still have one once authenticated.
+func (ac *AccessControl) HasUserRules() bool {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	return len(ac.rulesByUser) > 0
+}
+
 func (ac *AccessControl) GetAllRules() []*models.AccessRule {
 	ac.mu.RLock()
 	defer ac.mu.RUnlock()
@@ -219,10 +259,17 @@ func (ac *AccessControl) GetAllRules() []*models.AccessRule {
 }
 
 func (ac *AccessControl) StartSession(ip string) bool {
+	return ac.StartUserSession(ip, "")
+}
+
+// StartUserSession starts a session for an authenticated user. A rule
+// mapped to the username takes precedence over one mapped to the IP, so
+// quotas follow the user across devices.
+func (ac *AccessControl) StartUserSession(ip, user string) bool {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 
-	rule, status := ac.getRuleByIPLocked(ip)
+	rule, status := ac.getRuleLocked(ip, user)
 	if rule == nil {
 		return true // No rule means no restriction
 	}
@@ -242,11 +289,64 @@ func (ac *AccessControl) StartSession(ip string) bool {
 	return false
 }
 
+// CheckSession reports whether StartSession would admit the IP, without
+// starting a session. The returned status is a copy.
+func (ac *AccessControl) CheckSession(ip, user string) (*models.AccessRule, *AccessStatus, bool) {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	rule, status := ac.getRuleLocked(ip, user)
+	if rule == nil {
+		return nil, nil, true
+	}
+	statusCopy := *status
+
+	if rule.IsMaster {
+		return rule, &statusCopy, true
+	}
+
+	allowed := statusCopy.ActiveSince == nil && !statusCopy.IsBlocked && statusCopy.UsedTime < rule.DailyLimit
+	return rule, &statusCopy, allowed
+}
+
+// DNSPolicy returns the DNS policy for the client. Clients whose daily
+// limit is used up are blocked from DNS as well.
+func (ac *AccessControl) DNSPolicy(ip string) string {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	rule, status := ac.getRuleLocked(ip, "")
+	if rule == nil {
+		return models.DNSPolicyForward
+	}
+	if !rule.IsMaster && status.IsBlocked {
+		return models.DNSPolicyBlock
+	}
+	return rule.DNSPolicy
+}
+
+// BlockLists returns the destination lists assigned to the rule of the
+// client, in addition to the global ones.
+func (ac *AccessControl) BlockLists(ip, user string) []string {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	rule, _ := ac.getRuleLocked(ip, user)
+	if rule == nil {
+		return nil
+	}
+	return rule.BlockLists
+}
+
 func (ac *AccessControl) EndSession(ip string) {
+	ac.EndUserSession(ip, "")
+}
+
+func (ac *AccessControl) EndUserSession(ip, user string) {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 
-	rule, status := ac.getRuleByIPLocked(ip)
+	rule, status := ac.getRuleLocked(ip, user)
 	if rule == nil || rule.IsMaster {
 		return
 	}
@@ -265,8 +365,13 @@ func (ac *AccessControl) EndSession(ip string) {
 	}
 }
 
-func (ac *AccessControl) getRuleByIPLocked(ip string) (*models.AccessRule, *AccessStatus) {
-	if ruleID, ok := ac.rulesByIP[ip]; ok {
+func (ac *AccessControl) getRuleLocked(ip, user string) (*models.AccessRule, *AccessStatus) {
+	if user != "" {
+		if ruleID, ok := ac.rulesByUser[user]; ok {
+			return ac.rules[ruleID], ac.status[ruleID]
+		}
+	}
+	if ruleID, ok := ac.rulesByIP[NormalizeIP(ip)]; ok {
 		fmt.Printf("******** Checking rule for IP: %+v\n", ac.rulesByIP)
 		fmt.Printf("******** Rule ID: %+v\n", ruleID)
 		fmt.Printf("******** Rule: %+v\n", ac.rules[ruleID])
@@ -0,0 +1,196 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRejectedClients bounds the rejected clients kept for approval, so a
+// scan does not grow the list without end.
+const maxRejectedClients = 256
+
+// RejectedClient is a client turned away by the allowlist, kept so it can
+// be approved later.
+type RejectedClient struct {
+	IP        string
+	User      string
+	Inbound   string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int
+}
+
+// ClientAllowlist admits, when enabled, only the clients on the list or
+// with an access rule; every other client is rejected.
+type ClientAllowlist struct {
+	mu          sync.RWMutex
+	enabled     bool
+	prefixes    []netip.Prefix
+	users       map[string]bool
+	rejected    map[string]*RejectedClient // key: IP and user
+	subscribers []func(RejectedClient)
+}
+
+func NewClientAllowlist() *ClientAllowlist {
+	return &ClientAllowlist{
+		users:    make(map[string]bool),
+		rejected: make(map[string]*RejectedClient),
+	}
+}
+
+// Set replaces the entries and turns the allowlist on or off. Invalid
+// addresses are skipped and reported in the returned error.
+func (l *ClientAllowlist) Set(enabled bool, entries []*models.AllowedClient) error {
+	var prefixes []netip.Prefix
+	users := make(map[string]bool)
+	var errs []error
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		if entry.Username != "" {
+			users[entry.Username] = true
+		}
+		if entry.Address != "" {
+			prefix, err := ParseBlockPrefix(entry.Address)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("allowlist: %w", err))
+				continue
+			}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	l.mu.Lock()
+	l.enabled = enabled
+	l.prefixes = prefixes
+	l.users = users
+	l.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+func (l *ClientAllowlist) Enabled() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.enabled
+}
+
+// allowsAddress reports whether the address is on the list. The machine
+// itself is always allowed.
+func (l *ClientAllowlist) allowsAddress(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.IsLoopback() {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ClientAllowlist) allowsUser(user string) bool {
+	if user == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.users[user]
+}
+
+func (l *ClientAllowlist) hasUsers() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.users) > 0
+}
+
+// Subscribe registers fn to be called, outside the lock, for every
+// rejected connection.
+func (l *ClientAllowlist) Subscribe(fn func(RejectedClient)) {
+	l.mu.Lock()
+	l.subscribers = append(l.subscribers, fn)
+	l.mu.Unlock()
+}
+
+// Reject records a rejected connection of the client.
+func (l *ClientAllowlist) Reject(ip, user, inbound string) {
+	ip = NormalizeIP(ip)
+	key := ip + "|" + user
+	now := time.Now()
+
+	l.mu.Lock()
+	client, seen := l.rejected[key]
+	if !seen {
+		if len(l.rejected) >= maxRejectedClients {
+			l.evictOldestLocked()
+		}
+		client = &RejectedClient{IP: ip, User: user, FirstSeen: now}
+		l.rejected[key] = client
+	}
+	client.Inbound = inbound
+	client.LastSeen = now
+	client.Count++
+	event := *client
+	subscribers := l.subscribers
+	l.mu.Unlock()
+
+	logger := log.WithFields(log.Fields{
+		"clientIP": ip,
+		"user":     user,
+		"inbound":  inbound,
+	})
+	if seen {
+		logger.Debug("Connection rejected by allowlist")
+	} else {
+		logger.Info("New client rejected by allowlist")
+	}
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+func (l *ClientAllowlist) evictOldestLocked() {
+	var oldest string
+	for key, client := range l.rejected {
+		if oldest == "" || client.LastSeen.Before(l.rejected[oldest].LastSeen) {
+			oldest = key
+		}
+	}
+	delete(l.rejected, oldest)
+}
+
+// Rejected returns the clients rejected since they were last forgotten.
+func (l *ClientAllowlist) Rejected() []RejectedClient {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	clients := make([]RejectedClient, 0, len(l.rejected))
+	for _, client := range l.rejected {
+		clients = append(clients, *client)
+	}
+	return clients
+}
+
+// Forget removes a client from the rejected clients, once it has been
+// approved or dismissed.
+func (l *ClientAllowlist) Forget(ip, user string) {
+	l.mu.Lock()
+	delete(l.rejected, NormalizeIP(ip)+"|"+user)
+	l.mu.Unlock()
+}
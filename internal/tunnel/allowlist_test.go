@@ -0,0 +1,141 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"xengate/internal/models"
+)
+
+func TestClientAllowlistSet(t *testing.T) {
+	l := NewClientAllowlist()
+	err := l.Set(true, []*models.AllowedClient{
+		{Address: "192.0.2.10"},
+		{Address: "198.51.100.0/24"},
+		{Address: "2001:db8::/32"},
+		{Username: "alice"},
+		nil,
+		{Address: "not-an-address"},
+	})
+	if err == nil {
+		t.Error("Set() did not report the invalid address")
+	}
+	if !l.Enabled() {
+		t.Error("Set(true) left the allowlist disabled")
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "192.0.2.10", want: true},
+		{ip: "::ffff:192.0.2.10", want: true},
+		{ip: "192.0.2.11", want: false},
+		{ip: "198.51.100.200", want: true},
+		{ip: "2001:db8::1", want: true},
+		{ip: "127.0.0.1", want: true},
+		{ip: "::1", want: true},
+		{ip: "203.0.113.1", want: false},
+		{ip: "bogus", want: false},
+	}
+	for _, tt := range tests {
+		if got := l.allowsAddress(tt.ip); got != tt.want {
+			t.Errorf("allowsAddress(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if !l.allowsUser("alice") || l.allowsUser("bob") || l.allowsUser("") {
+		t.Error("allowsUser() does not match the listed users")
+	}
+	if !l.hasUsers() {
+		t.Error("hasUsers() = false with a listed user")
+	}
+
+	if err := l.Set(false, nil); err != nil || l.Enabled() || l.allowsAddress("192.0.2.10") || l.hasUsers() {
+		t.Error("Set(false, nil) kept the previous entries")
+	}
+}
+
+func TestClientAllowlistReject(t *testing.T) {
+	l := NewClientAllowlist()
+	var events []RejectedClient
+	l.Subscribe(func(c RejectedClient) { events = append(events, c) })
+
+	l.Reject("::ffff:203.0.113.1", "", "socks5")
+	l.Reject("203.0.113.1", "", "http")
+	l.Reject("203.0.113.1", "bob", "socks5")
+
+	if len(events) != 3 {
+		t.Fatalf("subscriber called %d times, want 3", len(events))
+	}
+	rejected := l.Rejected()
+	if len(rejected) != 2 {
+		t.Fatalf("Rejected() = %v, want one entry per address and user", rejected)
+	}
+	for _, c := range rejected {
+		if c.User == "" && (c.IP != "203.0.113.1" || c.Count != 2 || c.Inbound != "http") {
+			t.Errorf("anonymous client = %+v, want 2 rejections, last on http", c)
+		}
+	}
+
+	l.Forget("203.0.113.1", "")
+	if rejected := l.Rejected(); len(rejected) != 1 || rejected[0].User != "bob" {
+		t.Errorf("Rejected() after Forget = %v, want only bob", rejected)
+	}
+}
+
+func TestClientAllowlistRejectBounded(t *testing.T) {
+	l := NewClientAllowlist()
+	for i := 0; i < maxRejectedClients+10; i++ {
+		l.Reject("203.0.113.1", fmt.Sprintf("user%d", i), "socks5")
+	}
+	if n := len(l.Rejected()); n != maxRejectedClients {
+		t.Errorf("kept %d rejected clients, want %d", n, maxRejectedClients)
+	}
+}
+
+func TestManagerAllowlist(t *testing.T) {
+	m := NewManager(nil, NewAccessControl(time.Hour))
+	if !m.IsClientAllowed("203.0.113.1", "") {
+		t.Error("client refused with the allowlist off")
+	}
+
+	if err := m.SetAllowlist(true, []*models.AllowedClient{{Address: "192.0.2.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.AdmitAddress("192.0.2.5", "socks5") {
+		t.Error("listed address refused")
+	}
+	if m.AdmitAddress("203.0.113.1", "socks5") {
+		t.Error("unlisted address admitted without user entries")
+	}
+	if _, err := m.AdmitConnection("203.0.113.1", "http"); !errors.Is(err, ErrNotAllowlisted) {
+		t.Errorf("AdmitConnection() = %v, want ErrNotAllowlisted", err)
+	}
+
+	// With a user entry the address check has to wait for authentication.
+	if err := m.SetAllowlist(true, []*models.AllowedClient{{Username: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.AdmitAddress("203.0.113.2", "socks5") {
+		t.Error("address refused before its user could authenticate")
+	}
+	if _, err := m.StartFlow(&Metadata{ClientIP: "203.0.113.2", User: "bob", Inbound: "socks5"}); !errors.Is(err, ErrNotAllowlisted) {
+		t.Errorf("StartFlow() for an unlisted user = %v, want ErrNotAllowlisted", err)
+	}
+	if !m.IsClientAllowed("203.0.113.2", "alice") {
+		t.Error("listed user refused")
+	}
+
+	found := false
+	for _, c := range m.RejectedClients() {
+		if c.IP == "203.0.113.2" && c.User == "bob" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("RejectedClients() = %v, want bob from 203.0.113.2", m.RejectedClients())
+	}
+}
@@ -0,0 +1,280 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultAutoBanThreshold  = 5
+	DefaultAutoBanWindow     = 10 * time.Minute
+	DefaultAutoBanTime       = time.Hour
+	DefaultAutoBanMaxBanTime = 7 * 24 * time.Hour
+)
+
+type AbuseSignal string
+
+const (
+	AbuseAuthFailure AbuseSignal = "failed authentication"
+	AbuseMalformed   AbuseSignal = "malformed handshake"
+)
+
+// offender tracks the recent signals of a client.
+type offender struct {
+	strikes []time.Time
+	conns   []time.Time
+	bans    int
+	lastBan time.Time // end of the last ban
+}
+
+// AutoBanner bans clients that fail authentication, send malformed
+// handshakes or open connections in bursts, by adding them to the IP
+// blocklist with an expiry that grows with every ban.
+type AutoBanner struct {
+	mu        sync.Mutex
+	config    models.AutoBanConfig
+	allowlist []netip.Prefix
+	offenders map[netip.Addr]*offender
+	lastSweep time.Time
+	blocklist *IPBlocklist
+}
+
+func NewAutoBanner(blocklist *IPBlocklist) *AutoBanner {
+	return &AutoBanner{
+		offenders: make(map[netip.Addr]*offender),
+		blocklist: blocklist,
+	}
+}
+
+// SetConfig replaces the configuration; nil disables banning. Invalid
+// allowlist entries are skipped and reported in the returned error.
+func (a *AutoBanner) SetConfig(config *models.AutoBanConfig) error {
+	var c models.AutoBanConfig
+	if config != nil {
+		c = *config
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = DefaultAutoBanThreshold
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultAutoBanWindow
+	}
+	if c.BanTime <= 0 {
+		c.BanTime = DefaultAutoBanTime
+	}
+	if c.MaxBanTime < c.BanTime {
+		c.MaxBanTime = max(DefaultAutoBanMaxBanTime, c.BanTime)
+	}
+
+	var allowlist []netip.Prefix
+	var err error
+	for _, entry := range c.Allowlist {
+		prefix, parseErr := ParseBlockPrefix(entry)
+		if parseErr != nil {
+			err = fmt.Errorf("auto-ban allowlist: %w", parseErr)
+			continue
+		}
+		allowlist = append(allowlist, prefix)
+	}
+
+	a.mu.Lock()
+	a.config = c
+	a.allowlist = allowlist
+	if !c.Enabled {
+		a.offenders = make(map[netip.Addr]*offender)
+	}
+	a.mu.Unlock()
+	return err
+}
+
+// Config returns the configuration with defaults filled in.
+func (a *AutoBanner) Config() models.AutoBanConfig {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.config
+}
+
+// History returns the escalation state of the clients banned within the
+// last MaxBanTime, the clients whose next ban is longer than BanTime.
+func (a *AutoBanner) History() []models.AutoBanRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	var records []models.AutoBanRecord
+	for addr, o := range a.offenders {
+		if o.bans == 0 || now.Sub(o.lastBan) > a.config.MaxBanTime {
+			continue
+		}
+		records = append(records, models.AutoBanRecord{
+			IP:      addr.String(),
+			Bans:    o.bans,
+			LastBan: o.lastBan,
+		})
+	}
+	return records
+}
+
+// RestoreHistory brings back the escalation state saved with History. It
+// has to be called after SetConfig; records older than MaxBanTime are
+// skipped.
+func (a *AutoBanner) RestoreHistory(records []*models.AutoBanRecord) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, record := range records {
+		if record == nil || record.Bans <= 0 || now.Sub(record.LastBan) > a.config.MaxBanTime {
+			continue
+		}
+		addr, err := netip.ParseAddr(record.IP)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap().WithZone("")
+
+		o := a.offenders[addr]
+		if o == nil {
+			o = &offender{}
+			a.offenders[addr] = o
+		}
+		if record.LastBan.After(o.lastBan) {
+			o.bans = record.Bans
+			o.lastBan = record.LastBan
+		}
+	}
+}
+
+// Report counts a signal against the client and bans it once it reaches
+// the threshold within the window.
+func (a *AutoBanner) Report(ip string, signal AbuseSignal) {
+	a.record(ip, func(o *offender, now time.Time) string {
+		o.strikes = append(trimWindow(o.strikes, now, a.config.Window), now)
+		if len(o.strikes) < a.config.Threshold {
+			return ""
+		}
+		return fmt.Sprintf("auto-ban: %s (%d in %s)", signal, len(o.strikes), a.config.Window)
+	})
+}
+
+// ReportConnection counts a new connection of the client and bans it if
+// it exceeds the connection burst within the window.
+func (a *AutoBanner) ReportConnection(ip string) {
+	a.record(ip, func(o *offender, now time.Time) string {
+		if a.config.ConnectionBurst <= 0 {
+			return ""
+		}
+		o.conns = append(trimWindow(o.conns, now, a.config.Window), now)
+		if len(o.conns) <= a.config.ConnectionBurst {
+			return ""
+		}
+		return fmt.Sprintf("auto-ban: connection burst (%d in %s)", len(o.conns), a.config.Window)
+	})
+}
+
+// record applies update to the offender state of ip under the lock and
+// bans the client if update returns a reason.
+func (a *AutoBanner) record(ip string, update func(*offender, time.Time) string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap().WithZone("")
+	now := time.Now()
+
+	a.mu.Lock()
+	if !a.config.Enabled || a.exemptLocked(addr) {
+		a.mu.Unlock()
+		return
+	}
+	a.sweepLocked(now)
+
+	o := a.offenders[addr]
+	if o == nil {
+		o = &offender{}
+		a.offenders[addr] = o
+	}
+	reason := update(o, now)
+	if reason == "" {
+		a.mu.Unlock()
+		return
+	}
+
+	// A client that stayed clean for MaxBanTime after its last ban starts
+	// over at BanTime.
+	if !o.lastBan.IsZero() && now.Sub(o.lastBan) > a.config.MaxBanTime {
+		o.bans = 0
+	}
+	duration := a.config.BanTime
+	for i := 0; i < o.bans && duration < a.config.MaxBanTime; i++ {
+		duration *= 2
+	}
+	duration = min(duration, a.config.MaxBanTime)
+	o.bans++
+	o.lastBan = now.Add(duration)
+	o.strikes = nil
+	o.conns = nil
+	a.mu.Unlock()
+
+	if _, blocked := a.blocklist.Lookup(addr.String()); blocked {
+		return
+	}
+	a.blocklist.Add(BlockedEntry{
+		Prefix:  netip.PrefixFrom(addr, addr.BitLen()),
+		Added:   now,
+		Expires: now.Add(duration),
+		Reason:  reason,
+	})
+	log.WithFields(log.Fields{
+		"clientIP": addr.String(),
+		"duration": duration,
+		"reason":   reason,
+	}).Warn("Client banned automatically")
+}
+
+// exemptLocked reports whether addr may never be banned: loopback clients
+// and the allowlist.
+func (a *AutoBanner) exemptLocked(addr netip.Addr) bool {
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, prefix := range a.allowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepLocked forgets clients without recent signals, at most once per
+// window, so scanners passing by do not pile up.
+func (a *AutoBanner) sweepLocked(now time.Time) {
+	if now.Sub(a.lastSweep) < a.config.Window {
+		return
+	}
+	a.lastSweep = now
+
+	for addr, o := range a.offenders {
+		o.strikes = trimWindow(o.strikes, now, a.config.Window)
+		o.conns = trimWindow(o.conns, now, a.config.Window)
+		if len(o.strikes) == 0 && len(o.conns) == 0 &&
+			(o.lastBan.IsZero() || now.Sub(o.lastBan) > a.config.MaxBanTime) {
+			delete(a.offenders, addr)
+		}
+	}
+}
+
+// trimWindow drops the times older than window.
+func trimWindow(times []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > window {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,184 @@
+package tunnel
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"xengate/internal/models"
+)
+
+func newTestAutoBanner(t *testing.T, config models.AutoBanConfig) (*AutoBanner, *IPBlocklist) {
+	t.Helper()
+	blocklist := NewIPBlocklist()
+	a := NewAutoBanner(blocklist)
+	config.Enabled = true
+	if err := a.SetConfig(&config); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	return a, blocklist
+}
+
+// banTime returns how long ip is banned for, or zero if it is not banned.
+func banTime(blocklist *IPBlocklist, ip string) time.Duration {
+	entry, blocked := blocklist.Lookup(ip)
+	if !blocked {
+		return 0
+	}
+	return entry.Expires.Sub(entry.Added).Round(time.Minute)
+}
+
+func TestAutoBannerEscalation(t *testing.T) {
+	a, blocklist := newTestAutoBanner(t, models.AutoBanConfig{
+		Threshold:  3,
+		BanTime:    time.Hour,
+		MaxBanTime: 3 * time.Hour,
+	})
+	const ip = "192.0.2.1"
+
+	tests := []struct {
+		reports int
+		want    time.Duration
+	}{
+		{2, 0},
+		{1, time.Hour},
+		{3, 2 * time.Hour},
+		{3, 3 * time.Hour}, // capped at MaxBanTime
+		{3, 3 * time.Hour},
+	}
+
+	for i, tt := range tests {
+		for j := 0; j < tt.reports; j++ {
+			a.Report(ip, AbuseAuthFailure)
+		}
+		if got := banTime(blocklist, ip); got != tt.want {
+			t.Fatalf("step %d: banned for %s, want %s", i, got, tt.want)
+		}
+		// Lift the ban, as if it expired, so the next strikes count.
+		blocklist.Remove(netip.MustParsePrefix(ip + "/32"))
+	}
+}
+
+func TestAutoBannerExemptions(t *testing.T) {
+	a, blocklist := newTestAutoBanner(t, models.AutoBanConfig{
+		Threshold: 1,
+		Allowlist: []string{"198.51.100.0/24", "2001:db8::1"},
+	})
+
+	tests := []struct {
+		ip     string
+		banned bool
+	}{
+		{"192.0.2.1", true},
+		{"::ffff:192.0.2.2", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"198.51.100.20", false},
+		{"2001:db8::1", false},
+		{"2001:db8::2", true},
+		{"not an ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			a.Report(tt.ip, AbuseMalformed)
+			if got := blocklist.IsBlocked(tt.ip); got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+}
+
+func TestAutoBannerConnectionBurst(t *testing.T) {
+	tests := []struct {
+		name   string
+		burst  int
+		conns  int
+		banned bool
+	}{
+		{"disabled", 0, 50, false},
+		{"within burst", 5, 5, false},
+		{"over burst", 5, 6, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, blocklist := newTestAutoBanner(t, models.AutoBanConfig{ConnectionBurst: tt.burst})
+			for i := 0; i < tt.conns; i++ {
+				a.ReportConnection("192.0.2.1")
+			}
+			if got := blocklist.IsBlocked("192.0.2.1"); got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+}
+
+func TestAutoBannerDisabled(t *testing.T) {
+	blocklist := NewIPBlocklist()
+	a := NewAutoBanner(blocklist)
+	if err := a.SetConfig(&models.AutoBanConfig{Threshold: 1}); err != nil {
+		t.Fatal(err)
+	}
+	a.Report("192.0.2.1", AbuseAuthFailure)
+	if blocklist.IsBlocked("192.0.2.1") {
+		t.Error("client banned while auto-ban is disabled")
+	}
+}
+
+func TestAutoBannerConfigDefaults(t *testing.T) {
+	a := NewAutoBanner(NewIPBlocklist())
+	err := a.SetConfig(&models.AutoBanConfig{
+		Enabled:   true,
+		BanTime:   30 * 24 * time.Hour,
+		Allowlist: []string{"192.0.2.0/24", "bogus"},
+	})
+	if err == nil {
+		t.Error("SetConfig accepted an invalid allowlist entry")
+	}
+
+	config := a.Config()
+	if config.Threshold != DefaultAutoBanThreshold || config.Window != DefaultAutoBanWindow {
+		t.Errorf("threshold, window = %d, %s; want the defaults", config.Threshold, config.Window)
+	}
+	if config.MaxBanTime != config.BanTime {
+		t.Errorf("MaxBanTime = %s, want at least BanTime %s", config.MaxBanTime, config.BanTime)
+	}
+}
+
+func TestAutoBannerHistory(t *testing.T) {
+	config := models.AutoBanConfig{
+		Threshold:  1,
+		BanTime:    time.Hour,
+		MaxBanTime: 8 * time.Hour,
+	}
+	a, _ := newTestAutoBanner(t, config)
+	a.Report("192.0.2.1", AbuseAuthFailure)
+
+	history := a.History()
+	if len(history) != 1 || history[0].IP != "192.0.2.1" || history[0].Bans != 1 {
+		t.Fatalf("History() = %+v, want one ban of 192.0.2.1", history)
+	}
+
+	// A restart keeps escalating from the saved state; stale records and
+	// invalid addresses are dropped.
+	restored, blocklist := newTestAutoBanner(t, config)
+	restored.RestoreHistory([]*models.AutoBanRecord{
+		&history[0],
+		{IP: "192.0.2.2", Bans: 3, LastBan: time.Now().Add(-9 * time.Hour)},
+		{IP: "bogus", Bans: 1, LastBan: time.Now()},
+		nil,
+	})
+	restored.Report("192.0.2.1", AbuseAuthFailure)
+	restored.Report("192.0.2.2", AbuseAuthFailure)
+
+	if got := banTime(blocklist, "192.0.2.1"); got != 2*time.Hour {
+		t.Errorf("restored client banned for %s, want 2h", got)
+	}
+	if got := banTime(blocklist, "192.0.2.2"); got != time.Hour {
+		t.Errorf("stale client banned for %s, want 1h", got)
+	}
+	if got := len(restored.History()); got != 2 {
+		t.Errorf("len(History()) = %d, want 2", got)
+	}
+}
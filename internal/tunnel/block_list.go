@@ -2,47 +2,232 @@
 package tunnel
 
 import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type BlocklistEventType string
+
+const (
+	BlocklistAdded   BlocklistEventType = "added"
+	BlocklistRemoved BlocklistEventType = "removed"
+	BlocklistExpired BlocklistEventType = "expired"
 )
 
+// BlockedEntry is an address or range on the IPBlocklist.
+type BlockedEntry struct {
+	Prefix  netip.Prefix
+	Added   time.Time
+	Expires time.Time // zero if the entry does not expire
+	Reason  string
+}
+
+// String returns the address of a single-address entry and the CIDR of a
+// range.
+func (e BlockedEntry) String() string {
+	if e.Prefix.IsSingleIP() {
+		return e.Prefix.Addr().String()
+	}
+	return e.Prefix.String()
+}
+
+func (e BlockedEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+type BlocklistEvent struct {
+	Type  BlocklistEventType
+	Entry BlockedEntry
+}
+
+// IPBlocklist denies clients by address or range. Lookups cost one map
+// access per distinct prefix length on the list, whatever its size.
 type IPBlocklist struct {
-	mu      sync.RWMutex
-	blocked map[string]time.Time
+	mu          sync.RWMutex
+	blocked     map[netip.Prefix]BlockedEntry
+	lengths     []int // distinct prefix lengths, longest first
+	timer       *time.Timer
+	subscribers []func(BlocklistEvent)
 }
 
 func NewIPBlocklist() *IPBlocklist {
 	return &IPBlocklist{
-		blocked: make(map[string]time.Time),
+		blocked: make(map[netip.Prefix]BlockedEntry),
 	}
 }
 
-func (bl *IPBlocklist) Add(ip string) {
+// ParseBlockPrefix parses an IP address or CIDR range. IPv4-mapped IPv6
+// addresses are turned into IPv4, so both forms of a client match.
+func ParseBlockPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+		}
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", s)
+	}
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	return netip.PrefixFrom(addr, bits).Masked(), nil
+}
+
+// Subscribe registers fn to be called, outside the lock, for every entry
+// that is added, removed or expires.
+func (bl *IPBlocklist) Subscribe(fn func(BlocklistEvent)) {
 	bl.mu.Lock()
-	bl.blocked[ip] = time.Now()
+	bl.subscribers = append(bl.subscribers, fn)
 	bl.mu.Unlock()
 }
 
-func (bl *IPBlocklist) Remove(ip string) {
+func (bl *IPBlocklist) emit(events []BlocklistEvent) {
+	bl.mu.RLock()
+	subscribers := bl.subscribers
+	bl.mu.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+}
+
+// Add puts entry on the list, replacing an entry for the same range.
+// Entries that have already expired are ignored.
+func (bl *IPBlocklist) Add(entry BlockedEntry) {
+	if entry.expired(time.Now()) {
+		return
+	}
+	if entry.Added.IsZero() {
+		entry.Added = time.Now()
+	}
+
 	bl.mu.Lock()
-	delete(bl.blocked, ip)
+	bl.blocked[entry.Prefix] = entry
+	bl.updateLocked()
 	bl.mu.Unlock()
+
+	bl.emit([]BlocklistEvent{{Type: BlocklistAdded, Entry: entry}})
+}
+
+// Remove takes the range off the list. It reports whether it was on it.
+func (bl *IPBlocklist) Remove(prefix netip.Prefix) bool {
+	bl.mu.Lock()
+	entry, exists := bl.blocked[prefix]
+	if exists {
+		delete(bl.blocked, prefix)
+		bl.updateLocked()
+	}
+	bl.mu.Unlock()
+
+	if exists {
+		bl.emit([]BlocklistEvent{{Type: BlocklistRemoved, Entry: entry}})
+	}
+	return exists
 }
 
 func (bl *IPBlocklist) IsBlocked(ip string) bool {
+	_, blocked := bl.Lookup(ip)
+	return blocked
+}
+
+// Lookup returns the most specific entry that blocks the IP.
+func (bl *IPBlocklist) Lookup(ip string) (BlockedEntry, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return BlockedEntry{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	now := time.Now()
+
 	bl.mu.RLock()
 	defer bl.mu.RUnlock()
-	_, exists := bl.blocked[ip]
-	return exists
+
+	for _, bits := range bl.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		// An entry past its expiry stays until the timer removes it.
+		if entry, ok := bl.blocked[prefix]; ok && !entry.expired(now) {
+			return entry, true
+		}
+	}
+	return BlockedEntry{}, false
 }
 
-func (bl *IPBlocklist) GetAll() map[string]time.Time {
+func (bl *IPBlocklist) GetAll() []BlockedEntry {
 	bl.mu.RLock()
 	defer bl.mu.RUnlock()
 
-	result := make(map[string]time.Time, len(bl.blocked))
-	for ip, timestamp := range bl.blocked {
-		result[ip] = timestamp
+	result := make([]BlockedEntry, 0, len(bl.blocked))
+	for _, entry := range bl.blocked {
+		result = append(result, entry)
 	}
 	return result
 }
+
+// updateLocked recomputes the prefix lengths and schedules the removal of
+// the next entry to expire.
+func (bl *IPBlocklist) updateLocked() {
+	bl.lengths = bl.lengths[:0]
+	var next time.Time
+	for prefix, entry := range bl.blocked {
+		if !slices.Contains(bl.lengths, prefix.Bits()) {
+			bl.lengths = append(bl.lengths, prefix.Bits())
+		}
+		if !entry.Expires.IsZero() && (next.IsZero() || entry.Expires.Before(next)) {
+			next = entry.Expires
+		}
+	}
+	slices.SortFunc(bl.lengths, func(a, b int) int { return b - a })
+
+	if bl.timer != nil {
+		bl.timer.Stop()
+		bl.timer = nil
+	}
+	if !next.IsZero() {
+		bl.timer = time.AfterFunc(time.Until(next), bl.expire)
+	}
+}
+
+// expire removes the entries whose expiry has passed.
+func (bl *IPBlocklist) expire() {
+	now := time.Now()
+	var events []BlocklistEvent
+
+	bl.mu.Lock()
+	for prefix, entry := range bl.blocked {
+		if entry.expired(now) {
+			delete(bl.blocked, prefix)
+			events = append(events, BlocklistEvent{Type: BlocklistExpired, Entry: entry})
+		}
+	}
+	bl.updateLocked()
+	bl.mu.Unlock()
+
+	for _, event := range events {
+		log.WithFields(log.Fields{
+			"ip":     event.Entry.String(),
+			"reason": event.Entry.Reason,
+		}).Info("IP block expired")
+	}
+	bl.emit(events)
+}
@@ -0,0 +1,127 @@
+package tunnel
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseBlockPrefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "192.0.2.1", want: "192.0.2.1/32"},
+		{in: " 192.0.2.1 ", want: "192.0.2.1/32"},
+		{in: "::ffff:192.0.2.1", want: "192.0.2.1/32"},
+		{in: "fe80::1%eth0", want: "fe80::1/128"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "192.0.2.77/24", want: "192.0.2.0/24"},
+		{in: "::ffff:192.0.2.0/120", want: "192.0.2.0/24"},
+		{in: "2001:db8::/32", want: "2001:db8::/32"},
+		{in: "example.com", wantErr: true},
+		{in: "192.0.2.0/33", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBlockPrefix(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseBlockPrefix(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("ParseBlockPrefix(%q) = %v, %v; want %s", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPBlocklistLookup(t *testing.T) {
+	bl := NewIPBlocklist()
+	for _, entry := range []string{"192.0.2.0/24", "192.0.2.7", "10.0.0.0/8", "2001:db8::/32"} {
+		prefix, err := ParseBlockPrefix(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl.Add(BlockedEntry{Prefix: prefix, Reason: entry})
+	}
+	bl.Add(BlockedEntry{
+		Prefix:  netip.MustParsePrefix("198.51.100.1/32"),
+		Expires: time.Now().Add(-time.Minute),
+	})
+
+	tests := []struct {
+		ip   string
+		want string // reason of the matching entry, "" if not blocked
+	}{
+		{"192.0.2.7", "192.0.2.7"},
+		{"192.0.2.8", "192.0.2.0/24"},
+		{"::ffff:192.0.2.8", "192.0.2.0/24"},
+		{"10.200.3.4", "10.0.0.0/8"},
+		{"2001:db8:1::5", "2001:db8::/32"},
+		{"192.0.3.1", ""},
+		{"2001:db9::1", ""},
+		{"198.51.100.1", ""}, // already expired when added
+		{"not an ip", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			entry, blocked := bl.Lookup(tt.ip)
+			if blocked != (tt.want != "") || entry.Reason != tt.want {
+				t.Errorf("Lookup(%s) = %q, %v; want %q", tt.ip, entry.Reason, blocked, tt.want)
+			}
+		})
+	}
+
+	if !bl.Remove(netip.MustParsePrefix("192.0.2.7/32")) {
+		t.Fatal("Remove() = false for an entry on the list")
+	}
+	if entry, _ := bl.Lookup("192.0.2.7"); entry.Reason != "192.0.2.0/24" {
+		t.Errorf("after Remove, Lookup matched %q, want the enclosing range", entry.Reason)
+	}
+	if bl.Remove(netip.MustParsePrefix("192.0.2.7/32")) {
+		t.Error("Remove() = true for an entry no longer on the list")
+	}
+}
+
+func TestIPBlocklistExpiry(t *testing.T) {
+	bl := NewIPBlocklist()
+	events := make(chan BlocklistEvent, 4)
+	bl.Subscribe(func(event BlocklistEvent) {
+		events <- event
+	})
+
+	prefix := netip.MustParsePrefix("192.0.2.1/32")
+	bl.Add(BlockedEntry{Prefix: prefix, Expires: time.Now().Add(50 * time.Millisecond)})
+	bl.Add(BlockedEntry{Prefix: netip.MustParsePrefix("192.0.2.2/32")})
+
+	for _, want := range []BlocklistEventType{BlocklistAdded, BlocklistAdded} {
+		if event := <-events; event.Type != want {
+			t.Fatalf("event = %s, want %s", event.Type, want)
+		}
+	}
+	if !bl.IsBlocked("192.0.2.1") {
+		t.Fatal("entry not blocked before it expires")
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != BlocklistExpired || event.Entry.Prefix != prefix {
+			t.Errorf("event = %s %s, want %s %s", event.Type, event.Entry, BlocklistExpired, prefix)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("entry did not expire")
+	}
+	if bl.IsBlocked("192.0.2.1") {
+		t.Error("expired entry still blocked")
+	}
+	if !bl.IsBlocked("192.0.2.2") || len(bl.GetAll()) != 1 {
+		t.Errorf("entries = %v, want only the permanent entry", bl.GetAll())
+	}
+}
@@ -0,0 +1,332 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hostsAliases are the names hosts files map to the loopback and broadcast
+// addresses of the machine itself, never meant as blocked destinations.
+var hostsAliases = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// destinationList is a loaded DestinationList.
+type destinationList struct {
+	config  *models.DestinationList
+	domains map[string]struct{}
+	cidrs   []*net.IPNet
+	ports   map[int]struct{}
+	hits    atomic.Int64
+}
+
+// DestinationListStats describes a loaded list.
+type DestinationListStats struct {
+	Name    string
+	Path    string
+	Global  bool
+	Entries int
+	Hits    int64
+}
+
+// DestinationBlocklist denies flows by destination domain, address or
+// port. It is checked in addition to IPBlocklist, which denies clients.
+type DestinationBlocklist struct {
+	mu    sync.RWMutex
+	lists []*destinationList
+}
+
+func NewDestinationBlocklist() *DestinationBlocklist {
+	return &DestinationBlocklist{}
+}
+
+// SetLists loads the files of lists and replaces the current lists. Lists
+// that fail to load are left out and reported in the returned error; the
+// hit counters of lists that are kept carry over.
+func (b *DestinationBlocklist) SetLists(lists []*models.DestinationList) error {
+	b.mu.RLock()
+	previous := make(map[string]*destinationList, len(b.lists))
+	for _, l := range b.lists {
+		previous[l.config.Name] = l
+	}
+	b.mu.RUnlock()
+
+	var loaded []*destinationList
+	var errs []error
+	for _, config := range lists {
+		if config == nil || config.Name == "" {
+			continue
+		}
+		l, err := loadDestinationList(config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("destination list %s: %w", config.Name, err))
+			continue
+		}
+		if prev, ok := previous[config.Name]; ok {
+			l.hits.Store(prev.hits.Load())
+		}
+		log.WithFields(log.Fields{
+			"list":    config.Name,
+			"entries": l.entries(),
+		}).Info("Loaded destination list")
+		loaded = append(loaded, l)
+	}
+
+	b.mu.Lock()
+	b.lists = loaded
+	b.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Match returns the name of the first list that blocks the destination:
+// a global list or one of names. hosts are the target name and the
+// sniffed domain, if any, ips the target addresses.
+func (b *DestinationBlocklist) Match(names []string, hosts []string, ips []net.IP, port int) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range b.lists {
+		if !l.config.Global && !containsString(names, l.config.Name) {
+			continue
+		}
+		if l.matches(hosts, ips, port) {
+			return l.config.Name, true
+		}
+	}
+	return "", false
+}
+
+// Hit counts a flow denied by the named list.
+func (b *DestinationBlocklist) Hit(name string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range b.lists {
+		if l.config.Name == name {
+			l.hits.Add(1)
+			return
+		}
+	}
+}
+
+// NeedsIP reports whether any list blocks addresses, so hostname targets
+// have to be resolved before matching.
+func (b *DestinationBlocklist) NeedsIP() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range b.lists {
+		if len(l.cidrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *DestinationBlocklist) Stats() []DestinationListStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make([]DestinationListStats, 0, len(b.lists))
+	for _, l := range b.lists {
+		stats = append(stats, DestinationListStats{
+			Name:    l.config.Name,
+			Path:    l.config.Path,
+			Global:  l.config.Global,
+			Entries: l.entries(),
+			Hits:    l.hits.Load(),
+		})
+	}
+	return stats
+}
+
+func (l *destinationList) entries() int {
+	return len(l.domains) + len(l.cidrs) + len(l.ports)
+}
+
+func (l *destinationList) matches(hosts []string, ips []net.IP, port int) bool {
+	if _, ok := l.ports[port]; ok {
+		return true
+	}
+	for _, host := range hosts {
+		// example.com also blocks www.example.com
+		for name := host; name != ""; {
+			if _, ok := l.domains[name]; ok {
+				return true
+			}
+			_, parent, found := strings.Cut(name, ".")
+			if !found {
+				break
+			}
+			name = parent
+		}
+	}
+	for _, ip := range ips {
+		for _, cidr := range l.cidrs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadDestinationList(config *models.DestinationList) (*destinationList, error) {
+	f, err := os.Open(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &destinationList{config: config}
+	if err := l.parse(f); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parse reads the entries of a list. The format is detected per line, so
+// hosts, adblock and plain entries can be mixed; lines that are none of
+// them, such as adblock cosmetic filters, are skipped.
+func (l *destinationList) parse(r io.Reader) error {
+	l.domains = make(map[string]struct{})
+	l.ports = make(map[int]struct{})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "||") {
+			l.addAdblock(line)
+			continue
+		}
+		line = stripComment(line)
+
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			// hosts file: address followed by names
+			for _, name := range fields[1:] {
+				l.addDomain(name)
+			}
+			continue
+		}
+		if len(fields) == 1 {
+			l.addPlain(fields[0])
+		}
+	}
+	return scanner.Err()
+}
+
+// stripComment removes a trailing comment. Comments follow whitespace; a
+// '#' inside an entry is filter syntax, as in the cosmetic filter
+// example.com##.banner.
+func stripComment(line string) string {
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return line
+}
+
+// addAdblock adds the domain of a "||domain^" rule. Rules with options or
+// paths apply to parts of a site, which a proxy cannot tell apart.
+func (l *destinationList) addAdblock(line string) {
+	rule := strings.TrimPrefix(line, "||")
+	end := strings.IndexAny(rule, "^$/|*")
+	if end < 0 {
+		l.addDomain(rule)
+		return
+	}
+	if rule[end] != '^' || strings.TrimRight(rule[end:], "^|") != "" {
+		return
+	}
+	l.addDomain(rule[:end])
+}
+
+func (l *destinationList) addPlain(entry string) {
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		l.cidrs = append(l.cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return
+	}
+	if strings.Contains(entry, "/") {
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			l.cidrs = append(l.cidrs, cidr)
+		}
+		return
+	}
+	if strings.HasPrefix(entry, ":") {
+		if port, err := strconv.Atoi(entry[1:]); err == nil && port > 0 && port < 65536 {
+			l.ports[port] = struct{}{}
+		}
+		return
+	}
+	l.addDomain(strings.TrimPrefix(entry, "*."))
+}
+
+func (l *destinationList) addDomain(name string) {
+	name = normalizeDomain(name)
+	if !isDomainName(name) || hostsAliases[name] || net.ParseIP(name) != nil {
+		return
+	}
+	l.domains[name] = struct{}{}
+}
+
+// isDomainName reports whether name only has the characters of a host
+// name, which leaves out adblock exceptions and other filter syntax.
+func isDomainName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeDomain(name string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(name), "."))
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xengate/internal/models"
+)
+
+const testDestinationList = `# hosts file
+0.0.0.0 ads.example.com tracker.example.net # inline comment
+127.0.0.1 localhost
+::1 ip6-localhost
+
+! adblock
+||adserver.example.org^
+||cdn.example.org^$third-party
+||example.info/path^
+@@||allowed.example.org^
+example.com##.banner
+
+[Adblock Plus 2.0]
+*.wildcard.example
+Plain.Example.EDU.
+198.51.100.7
+203.0.113.0/24
+2001:db8::/32
+:25
+:70000
+not a domain!
+`
+
+func TestDestinationListParse(t *testing.T) {
+	l := &destinationList{}
+	if err := l.parse(strings.NewReader(testDestinationList)); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	var domains []string
+	for name := range l.domains {
+		domains = append(domains, name)
+	}
+	wantDomains := []string{
+		"ads.example.com", "tracker.example.net", "adserver.example.org",
+		"wildcard.example", "plain.example.edu",
+	}
+	if len(domains) != len(wantDomains) {
+		t.Errorf("domains = %v, want %v", domains, wantDomains)
+	}
+	for _, name := range wantDomains {
+		if _, ok := l.domains[name]; !ok {
+			t.Errorf("domain %s missing", name)
+		}
+	}
+
+	if len(l.cidrs) != 3 {
+		t.Errorf("cidrs = %v, want 3 ranges", l.cidrs)
+	}
+	if _, ok := l.ports[25]; !ok || len(l.ports) != 1 {
+		t.Errorf("ports = %v, want only 25", l.ports)
+	}
+}
+
+func TestDestinationListMatches(t *testing.T) {
+	l := &destinationList{}
+	if err := l.parse(strings.NewReader(testDestinationList)); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		hosts []string
+		ips   []string
+		port  int
+		want  bool
+	}{
+		{"domain", []string{"ads.example.com"}, nil, 443, true},
+		{"subdomain", []string{"a.b.ads.example.com"}, nil, 443, true},
+		{"parent not blocked", []string{"example.com"}, nil, 443, false},
+		{"suffix is not a subdomain", []string{"badads.example.com"}, nil, 443, false},
+		{"sniffed domain", []string{"192.0.2.1", "www.adserver.example.org"}, nil, 443, true},
+		{"adblock rule with options", []string{"cdn.example.org"}, nil, 443, false},
+		{"adblock exception", []string{"allowed.example.org"}, nil, 443, false},
+		{"single address", nil, []string{"198.51.100.7"}, 443, true},
+		{"range", nil, []string{"203.0.113.200"}, 443, true},
+		{"ipv6 range", nil, []string{"2001:db8::42"}, 443, true},
+		{"resolved addresses", []string{"mail.example.com"}, []string{"192.0.2.1", "203.0.113.5"}, 443, true},
+		{"address outside", nil, []string{"198.51.100.8"}, 443, false},
+		{"port", []string{"mail.example.com"}, nil, 25, true},
+		{"hosts alias", []string{"localhost"}, nil, 80, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, ip := range tt.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			if got := l.matches(tt.hosts, ips, tt.port); got != tt.want {
+				t.Errorf("matches(%v, %v, %d) = %v, want %v", tt.hosts, tt.ips, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationBlocklistMatch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	b := NewDestinationBlocklist()
+	err := b.SetLists([]*models.DestinationList{
+		{Name: "malware", Path: write("malware.txt", "malware.example\n"), Global: true},
+		{Name: "social", Path: write("social.txt", "social.example\n")},
+		{Name: "missing", Path: filepath.Join(dir, "missing.txt")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("SetLists error = %v, want the missing list reported", err)
+	}
+
+	tests := []struct {
+		name  string
+		lists []string
+		host  string
+		want  string
+	}{
+		{"global list", nil, "malware.example", "malware"},
+		{"assigned list", []string{"social"}, "social.example", "social"},
+		{"unassigned list", []string{"other"}, "social.example", ""},
+		{"not listed", []string{"social"}, "example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, blocked := b.Match(tt.lists, []string{tt.host}, nil, 443)
+			if list != tt.want || blocked != (tt.want != "") {
+				t.Errorf("Match() = %q, %v; want %q", list, blocked, tt.want)
+			}
+		})
+	}
+
+	if b.NeedsIP() {
+		t.Error("NeedsIP() = true for lists without addresses")
+	}
+}
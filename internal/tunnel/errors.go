@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors returned by Manager so that inbounds can report the reason of a
+// failed flow to their clients. They are always wrapped, use errors.Is.
+var (
+	ErrBlocked            = errors.New("client is blocked")
+	ErrNotAllowlisted     = errors.New("client is not on the allowlist")
+	ErrDestinationBlocked = errors.New("destination is blocked")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrRejected           = errors.New("rejected by routing rule")
+	ErrNoTunnel           = errors.New("no available tunnels")
+	ErrConnectionRefused  = errors.New("connection refused")
+	ErrHostUnreachable    = errors.New("host unreachable")
+	ErrNetworkUnreachable = errors.New("network unreachable")
+	ErrTimeout            = errors.New("connection timed out")
+	ErrConnectionLimit    = errors.New("connection limit reached")
+)
+
+// IsPolicyError reports whether err is a denial by the blocklists, access
+// control or routing rules, as opposed to a network failure.
+func IsPolicyError(err error) bool {
+	return errors.Is(err, ErrBlocked) || errors.Is(err, ErrNotAllowlisted) || errors.Is(err, ErrDestinationBlocked) ||
+		errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrRejected)
+}
+
+// dialError wraps a failed dial to addr with the matching typed error. The
+// SSH server only reports the failure as text in the channel open
+// rejection, so that message is matched the same way as local errors.
+func dialError(addr string, err error) error {
+	var kind error
+	var openErr *ssh.OpenChannelError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, syscall.ETIMEDOUT):
+		kind = ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		kind = ErrConnectionRefused
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		kind = ErrHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		kind = ErrNetworkUnreachable
+	case errors.As(err, &openErr):
+		if openErr.Reason == ssh.Prohibited {
+			kind = ErrRejected
+		} else {
+			kind = classifyMessage(openErr.Message)
+		}
+	default:
+		var netErr net.Error
+		var dnsErr *net.DNSError
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			kind = ErrTimeout
+		} else if errors.As(err, &dnsErr) {
+			kind = ErrHostUnreachable
+		} else {
+			kind = classifyMessage(err.Error())
+		}
+	}
+
+	if kind == nil {
+		return fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	return fmt.Errorf("failed to dial %s: %w: %w", addr, kind, err)
+}
+
+func classifyMessage(msg string) error {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "refused"):
+		return ErrConnectionRefused
+	case strings.Contains(msg, "timed out"), strings.Contains(msg, "timeout"):
+		return ErrTimeout
+	case strings.Contains(msg, "network is unreachable"):
+		return ErrNetworkUnreachable
+	case strings.Contains(msg, "no route to host"), strings.Contains(msg, "unreachable"),
+		strings.Contains(msg, "name or service not known"), strings.Contains(msg, "no address"),
+		strings.Contains(msg, "not known"):
+		return ErrHostUnreachable
+	}
+	return nil
+}
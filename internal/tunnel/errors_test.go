@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error // nil if the error stays unclassified
+	}{
+		{"deadline", context.DeadlineExceeded, ErrTimeout},
+		{"refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ErrConnectionRefused},
+		{"host unreachable", &net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}, ErrHostUnreachable},
+		{"network unreachable", &net.OpError{Op: "dial", Err: syscall.ENETUNREACH}, ErrNetworkUnreachable},
+		{"dns", &net.DNSError{Err: "no such host", Name: "missing.example"}, ErrHostUnreachable},
+		{"prohibited", &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "administratively prohibited"}, ErrRejected},
+		{"remote refused", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Connection refused"}, ErrConnectionRefused},
+		{"remote timeout", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Connection timed out"}, ErrTimeout},
+		{"remote no route", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "No route to host"}, ErrHostUnreachable},
+		{"remote network", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Network is unreachable"}, ErrNetworkUnreachable},
+		{"unknown", errors.New("ssh: unexpected packet"), nil},
+	}
+
+	kinds := []error{ErrTimeout, ErrConnectionRefused, ErrHostUnreachable, ErrNetworkUnreachable, ErrRejected}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dialError("192.0.2.1:443", tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("dialError() = %v, lost the original error", err)
+			}
+			for _, kind := range kinds {
+				if got := errors.Is(err, kind); got != (kind == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, kind, got)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPolicyError(t *testing.T) {
+	for _, err := range []error{ErrBlocked, ErrNotAllowlisted, ErrDestinationBlocked, ErrAccessDenied, ErrRejected} {
+		if !IsPolicyError(fmt.Errorf("wrapped: %w", err)) {
+			t.Errorf("IsPolicyError(%v) = false", err)
+		}
+	}
+	for _, err := range []error{ErrNoTunnel, ErrTimeout, ErrConnectionLimit, errors.New("other")} {
+		if IsPolicyError(err) {
+			t.Errorf("IsPolicyError(%v) = true", err)
+		}
+	}
+}
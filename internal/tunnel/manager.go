@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"xengate/internal/dns"
 	"xengate/internal/models"
 
 	"fyne.io/fyne/v2"
@@ -19,14 +23,31 @@ type Manager struct {
 	mu            sync.RWMutex
 	wg            sync.WaitGroup
 	blocklist     *IPBlocklist
+	autoBan       *AutoBanner
+	allowlist     *ClientAllowlist
+	connections   atomic.Int64
+	connLimit     atomic.Int64
+	destinations  *DestinationBlocklist
+	userStats     *userStatsTable
 	accessControl *AccessControl
+	router        *Router
+	resolver      *dns.Resolver
+	directDialer  *net.Dialer
+	directUsers   int
+	sniffing      bool
 }
 
 func NewManager(app fyne.App, accessControl *AccessControl) *Manager {
+	blocklist := NewIPBlocklist()
 	return &Manager{
 		pools:         make(map[string]*ConnectionPool),
-		blocklist:     NewIPBlocklist(),
+		blocklist:     blocklist,
+		autoBan:       NewAutoBanner(blocklist),
+		allowlist:     NewClientAllowlist(),
+		destinations:  NewDestinationBlocklist(),
+		userStats:     newUserStatsTable(),
 		accessControl: accessControl,
+		router:        NewRouter(),
 	}
 }
 
@@ -118,62 +139,268 @@ func (m *Manager) StopAll() {
 	}()
 }
 
+// Metadata describes where a proxied flow came from and where it is going.
+type Metadata struct {
+	Inbound  string
+	ClientIP string
+	User     string // authenticated username, if any
+	Target   string
+	Domain   string // sniffed from the flow when Target is an address
+}
+
 func (m *Manager) Forward(localConn net.Conn, targetAddr string) error {
+	return m.ForwardMetadata(localConn, &Metadata{
+		ClientIP: ClientIP(localConn.RemoteAddr()),
+		Target:   targetAddr,
+	})
+}
+
+// ClientIP extracts the IP part of a client address. IPv4 clients of
+// dual-stack listeners are reported as IPv4.
+func ClientIP(addr net.Addr) string {
+	if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+		return ap.Addr().Unmap().WithZone("").String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// NormalizeIP returns the canonical form of an IP address, so that lists
+// keyed by address match however the address was written. Anything that
+// is not an IP address is returned trimmed.
+func NormalizeIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().WithZone("").String()
+	}
+	return ip
+}
+
+// ForwardMetadata connects to the flow target and relays localConn to it
+// until either side closes.
+func (m *Manager) ForwardMetadata(localConn net.Conn, meta *Metadata) error {
+	endSession, err := m.StartFlow(meta)
+	if err != nil {
+		localConn.Close()
+		return err
+	}
+	return m.ForwardStarted(localConn, meta, endSession)
+}
+
+// ForwardStarted is ForwardMetadata for a flow that StartFlow admitted:
+// it sniffs the domain, connects and relays. It takes over endSession.
+func (m *Manager) ForwardStarted(localConn net.Conn, meta *Metadata, endSession func()) error {
+	localConn = m.SniffFlow(localConn, meta)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	session, err := m.dial(ctx, meta, endSession)
+	cancel()
+	if err != nil {
+		localConn.Close()
+		return err
+	}
+	defer session.Close()
+
+	return session.Relay(localConn)
+}
+
+// Session is an outbound connection opened for a flow. The access session
+// of the client lasts until it is closed.
+type Session struct {
+	Metadata *Metadata
+	Route    *models.RoutingRule
+	Pool     string // empty for direct connections
+
+	remote  net.Conn
+	tunnel  *Tunnel
+	onClose func()
+	once    sync.Once
+}
+
+// BoundAddr is the local address of the outbound connection.
+func (s *Session) BoundAddr() net.Addr {
+	return s.remote.LocalAddr()
+}
+
+func (s *Session) Relay(localConn net.Conn) error {
+	if s.tunnel != nil {
+		return s.tunnel.Relay(localConn, s.remote)
+	}
+	_, err := relay(localConn, s.remote)
+	return err
+}
+
+func (s *Session) Close() error {
+	err := s.remote.Close()
+	s.once.Do(s.onClose)
+	return err
+}
+
+// Conn returns the outbound connection for callers that speak the
+// protocol themselves instead of relaying. Closing it closes the session.
+func (s *Session) Conn() net.Conn {
+	return &sessionConn{Conn: s.remote, session: s}
+}
+
+type sessionConn struct {
+	net.Conn
+	session *Session
+}
+
+func (c *sessionConn) Close() error {
+	return c.session.Close()
+}
+
+// Connect runs the blocklist, access control and routing checks for the
+// flow and dials its target. Errors wrap one of the Err* values.
+func (m *Manager) Connect(ctx context.Context, meta *Metadata) (*Session, error) {
+	endSession, err := m.StartFlow(meta)
+	if err != nil {
+		return nil, err
+	}
+	return m.dial(ctx, meta, endSession)
+}
+
+// StartFlow runs the checks of Connect that do not depend on the target,
+// the blocklist, the allowlist and the access session of the client, so
+// inbounds that confirm a flow before its domain is sniffed can refuse
+// it first. On success the caller owns the returned function, which ends
+// the access session, and hands it to ForwardStarted.
+func (m *Manager) StartFlow(meta *Metadata) (func(), error) {
 	logger := log.WithFields(log.Fields{
-		"localAddr":  localConn.LocalAddr().String(),
-		"remoteAddr": localConn.RemoteAddr().String(),
+		"clientIP":   meta.ClientIP,
+		"targetAddr": meta.Target,
+		"inbound":    meta.Inbound,
+		"user":       meta.User,
+	})
+	clientIP := meta.ClientIP
+
+	// چک کردن بلک لیست با IP کلاینت
+	if entry, blocked := m.blocklist.Lookup(clientIP); blocked {
+		logger.WithField("entry", entry.String()).Warn("Connection blocked by IP blocklist")
+		return nil, fmt.Errorf("%w: %s is on the IP blocklist", ErrBlocked, clientIP)
+	}
+
+	if !m.IsClientAllowed(clientIP, meta.User) {
+		m.allowlist.Reject(clientIP, meta.User, meta.Inbound)
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowlisted, clientIP)
+	}
+
+	// چک کردن و شروع سشن با IP کلاینت
+	if !m.accessControl.StartUserSession(clientIP, meta.User) {
+		logger.Debug("Access denied (time limit exceeded)")
+		m.accessControl.EndUserSession(clientIP, meta.User)
+		return nil, fmt.Errorf("%w for %s (time limit exceeded)", ErrAccessDenied, clientIP)
+	}
+
+	return func() {
+		m.accessControl.EndUserSession(clientIP, meta.User)
+	}, nil
+}
+
+// dial runs the destination and routing checks of a started flow and
+// opens its outbound connection. endSession is called if it fails, and
+// when the session is closed otherwise.
+func (m *Manager) dial(ctx context.Context, meta *Metadata, endSession func()) (*Session, error) {
+	targetAddr := meta.Target
+	logger := log.WithFields(log.Fields{
+		"clientIP":   meta.ClientIP,
 		"targetAddr": targetAddr,
+		"inbound":    meta.Inbound,
+		"user":       meta.User,
 	})
+	if meta.Domain != "" {
+		logger = logger.WithField("domain", meta.Domain)
+	}
 	logger.Debug("Forwarding connection")
 
-	// استخراج IP کلاینت از RemoteAddr
-	clientIP := strings.Split(localConn.RemoteAddr().String(), ":")[0]
+	route, ips := m.matchRoute(meta, true)
+	if list, blocked := m.matchDestination(meta, ips); blocked {
+		m.destinations.Hit(list)
+		logger.WithField("list", list).Info("Connection blocked by destination list")
+		endSession()
+		return nil, fmt.Errorf("connection to %s %w by list %q", targetAddr, ErrDestinationBlocked, list)
+	}
+	if route != nil {
+		logger = logger.WithField("route", route.Title)
+		switch route.Action {
+		case models.RouteActionReject:
+			logger.Info("Connection rejected by routing rule")
+			endSession()
+			return nil, fmt.Errorf("connection to %s %w %q", targetAddr, ErrRejected, route.Title)
+		case models.RouteActionDirect:
+			logger.Debug("Forwarding directly (bypassing tunnels)")
+			remote, err := m.dialDirect(ctx, targetAddr, ips)
+			if err != nil {
+				endSession()
+				return nil, dialError(targetAddr, err)
+			}
+			remote = m.userStats.track(meta.User, remote)
+			return &Session{Metadata: meta, Route: route, remote: remote, onClose: endSession}, nil
+		}
+	}
 
-	// چک کردن بلک لیست با IP کلاینت
-	if m.IsIPBlocked(clientIP) {
-		log.WithFields(log.Fields{
-			"clientIP": clientIP,
-		}).Warn("Connection blocked by IP blocklist")
-		localConn.Close()
-		return fmt.Errorf("connection blocked by IP blocklist: %s", clientIP)
+	selectedPool, err := m.selectPool(route)
+	if err != nil {
+		logger.WithError(err).Error("No pool available")
+		endSession()
+		return nil, err
 	}
 
-	// چک کردن و شروع سشن با IP کلاینت
-	if !m.accessControl.StartSession(clientIP) {
-		log.WithFields(log.Fields{
-			"clientIP": clientIP,
-		}).Debug("Access denied (time limit exceeded)")
-		localConn.Close()
-		m.accessControl.EndSession(clientIP)
-		return fmt.Errorf("access denied for %s (time limit exceeded)", clientIP)
+	stats := selectedPool.GetStats()
+	logger = logger.WithFields(log.Fields{
+		"pool":        selectedPool.server.Name,
+		"activeConns": stats.ActiveConnections,
+	})
+	logger.Debug("Selected pool for forwarding")
+
+	tunnel, remote, err := selectedPool.Open(ctx, "tcp", targetAddr)
+	if err != nil {
+		logger.WithError(err).Debug("Dial through tunnel failed")
+		endSession()
+		return nil, err
 	}
 
-	defer m.accessControl.EndSession(clientIP)
+	return &Session{
+		Metadata: meta,
+		Route:    route,
+		Pool:     selectedPool.server.Name,
+		remote:   m.userStats.track(meta.User, remote),
+		tunnel:   tunnel,
+		onClose:  endSession,
+	}, nil
+}
 
+// selectPool returns the pool pinned by the routing rule, or the connected
+// pool with the fewest active connections.
+func (m *Manager) selectPool(route *models.RoutingRule) (*ConnectionPool, error) {
 	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if len(m.pools) == 0 {
-		m.mu.RUnlock()
-		logger.Error("No available connection pools")
-		return fmt.Errorf("no available connection pools")
+		return nil, fmt.Errorf("%w: no connection pools running", ErrNoTunnel)
 	}
 
-	availablePools := make([]*ConnectionPool, 0, len(m.pools))
-	for _, pool := range m.pools {
-		if tunnel := pool.GetTunnel(); tunnel != nil && tunnel.IsConnected() {
-			availablePools = append(availablePools, pool)
+	if route != nil && route.Pool != "" {
+		pool, exists := m.pools[route.Pool]
+		if !exists {
+			return nil, fmt.Errorf("%w: pool %s required by routing rule %q is not running", ErrNoTunnel, route.Pool, route.Title)
 		}
-	}
-	m.mu.RUnlock()
-
-	if len(availablePools) == 0 {
-		logger.Error("No available tunnels")
-		return fmt.Errorf("no available tunnels")
+		if tunnel := pool.GetTunnel(); tunnel == nil || !tunnel.IsConnected() {
+			return nil, fmt.Errorf("%w for server %s", ErrNoTunnel, route.Pool)
+		}
+		return pool, nil
 	}
 
 	var selectedPool *ConnectionPool
 	minActive := int64(^uint64(0) >> 1)
 
-	for _, pool := range availablePools {
+	for _, pool := range m.pools {
+		if tunnel := pool.GetTunnel(); tunnel == nil || !tunnel.IsConnected() {
+			continue
+		}
 		stats := pool.GetStats()
 		if stats.ActiveConnections < minActive {
 			minActive = stats.ActiveConnections
@@ -181,32 +408,233 @@ func (m *Manager) Forward(localConn net.Conn, targetAddr string) error {
 		}
 	}
 
-	logger = logger.WithFields(log.Fields{
-		"pool":        selectedPool.server.Name,
-		"activeConns": minActive,
-	})
-	logger.Debug("Selected pool for forwarding")
+	if selectedPool == nil {
+		return nil, ErrNoTunnel
+	}
+	return selectedPool, nil
+}
 
-	errCh := make(chan error, 1)
-	timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// matchRoute finds the routing rule for the flow, resolving hostname
+// targets locally when a rule needs to match on addresses. A sniffed
+// domain is matched together with the target address.
+func (m *Manager) matchRoute(meta *Metadata, resolve bool) (*models.RoutingRule, []net.IP) {
+	if meta.Domain != "" {
+		if host, port, err := net.SplitHostPort(meta.Target); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				return m.router.Match(meta.Inbound, net.JoinHostPort(meta.Domain, port), []net.IP{ip}), nil
+			}
+		}
+	}
 
-	go func() {
-		errCh <- selectedPool.Forward(localConn, targetAddr)
-	}()
+	var ips []net.IP
 
-	select {
-	case err := <-errCh:
-		if err != nil {
-			logger.WithError(err).Error("Forward operation failed")
-		} else {
-			logger.Debug("Forward operation completed successfully")
+	m.mu.RLock()
+	resolver := m.resolver
+	m.mu.RUnlock()
+
+	if resolve && resolver != nil && m.needsResolve(meta.Target) {
+		if host, _, err := net.SplitHostPort(meta.Target); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ips, err = resolver.LookupIP(ctx, host)
+			cancel()
+			if err != nil {
+				log.WithError(err).WithField("host", host).Debug("Failed to resolve target for routing")
+			}
 		}
-		return err
-	case <-timeoutCtx.Done():
-		logger.Error("Forward operation timed out")
-		return fmt.Errorf("forward operation timed out")
 	}
+
+	return m.router.Match(meta.Inbound, meta.Target, ips), ips
+}
+
+// needsResolve reports whether matching target takes its addresses: it is
+// a hostname and some routing rule or destination list matches addresses.
+func (m *Manager) needsResolve(target string) bool {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil || net.ParseIP(host) != nil {
+		return false
+	}
+	return m.router.NeedsIP() || m.destinations.NeedsIP()
+}
+
+// dialDirect connects to target without a tunnel. Names are resolved with
+// the configured resolver rather than the system one, which may be the
+// fake IP responder of the TUN mode; ips are the addresses already looked
+// up for routing, if any.
+func (m *Manager) dialDirect(ctx context.Context, target string, ips []net.IP) (net.Conn, error) {
+	dialer := m.DirectDialer()
+	resolver := m.Resolver()
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || net.ParseIP(host) != nil || resolver == nil {
+		return dialer.DialContext(ctx, "tcp", target)
+	}
+
+	if len(ips) == 0 {
+		if ips, err = resolver.LookupIP(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// matchDestination checks the target of the flow, its sniffed domain and
+// its resolved addresses against the global destination lists and those of
+// the client's access rule.
+func (m *Manager) matchDestination(meta *Metadata, resolved []net.IP) (string, bool) {
+	host, portStr, err := net.SplitHostPort(meta.Target)
+	if err != nil {
+		host = meta.Target
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	var hosts []string
+	ips := resolved
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		hosts = append(hosts, normalizeDomain(host))
+	}
+	if meta.Domain != "" {
+		hosts = append(hosts, meta.Domain)
+	}
+
+	names := m.accessControl.BlockLists(meta.ClientIP, meta.User)
+	return m.destinations.Match(names, hosts, ips, port)
+}
+
+// SetDestinationLists loads the destination blocklists. Lists that fail to
+// load are reported in the error and left out.
+func (m *Manager) SetDestinationLists(lists []*models.DestinationList) error {
+	return m.destinations.SetLists(lists)
+}
+
+func (m *Manager) DestinationListStats() []DestinationListStats {
+	return m.destinations.Stats()
+}
+
+// UserStats returns the traffic of every user that authenticated since
+// the manager was created, sorted by name.
+func (m *Manager) UserStats() []UserStats {
+	return m.userStats.all()
+}
+
+// Dial opens a connection to addr through the least busy pool.
+func (m *Manager) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	pool, err := m.selectPool(nil)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Dial(ctx, network, addr)
+}
+
+func (m *Manager) SetResolver(resolver *dns.Resolver) {
+	m.mu.Lock()
+	m.resolver = resolver
+	m.mu.Unlock()
+}
+
+func (m *Manager) Resolver() *dns.Resolver {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.resolver
+}
+
+// SetSniffing enables reading the domain of flows to bare addresses from
+// their TLS ClientHello or HTTP Host header.
+func (m *Manager) SetSniffing(enabled bool) {
+	m.mu.Lock()
+	m.sniffing = enabled
+	m.mu.Unlock()
+}
+
+func (m *Manager) Sniffing() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sniffing
+}
+
+// ShouldSniff reports whether the domain of the flow is to be sniffed:
+// sniffing is enabled and the target is an address.
+func (m *Manager) ShouldSniff(meta *Metadata) bool {
+	if meta.Domain != "" || !m.Sniffing() {
+		return false
+	}
+	host, _, err := net.SplitHostPort(meta.Target)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+// SniffFlow sets meta.Domain from the first bytes of conn when the flow is
+// to be sniffed. The returned connection has to be used in place of conn.
+func (m *Manager) SniffFlow(conn net.Conn, meta *Metadata) net.Conn {
+	if !m.ShouldSniff(meta) {
+		return conn
+	}
+	conn, meta.Domain = Sniff(conn, SniffTimeout)
+	if meta.Domain != "" {
+		log.WithFields(log.Fields{
+			"target": meta.Target,
+			"domain": meta.Domain,
+		}).Debug("Sniffed flow domain")
+	}
+	return conn
+}
+
+// UseDirectDialer makes dialer the dialer of direct routes until release
+// is called, e.g. to mark sockets that transparent proxy rules must not
+// capture again. Inbounds using it at the same time share the first one,
+// which stays until the last of them releases it.
+func (m *Manager) UseDirectDialer(dialer *net.Dialer) (release func()) {
+	m.mu.Lock()
+	if m.directUsers == 0 {
+		m.directDialer = dialer
+	}
+	m.directUsers++
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			m.directUsers--
+			if m.directUsers == 0 {
+				m.directDialer = nil
+			}
+			m.mu.Unlock()
+		})
+	}
+}
+
+func (m *Manager) DirectDialer() *net.Dialer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.directDialer == nil {
+		return &net.Dialer{}
+	}
+	return m.directDialer
+}
+
+func (m *Manager) AccessControl() *AccessControl {
+	return m.accessControl
+}
+
+func (m *Manager) SetRoutingRules(rules []*models.RoutingRule) {
+	m.router.SetRules(rules)
+}
+
+func (m *Manager) GetRoutingRules() []*models.RoutingRule {
+	return m.router.GetRules()
 }
 
 func (m *Manager) GetStats() map[string]PoolStats {
@@ -238,29 +666,205 @@ func (m *Manager) GetBlockedIPs() []models.BlockedIPInfo {
 	blocked := m.blocklist.GetAll()
 	items := make([]models.BlockedIPInfo, 0, len(blocked))
 
-	for ip, timestamp := range blocked {
-		items = append(items, models.BlockedIPInfo{
-			IP:        ip,
-			Timestamp: timestamp,
-		})
+	for _, entry := range blocked {
+		items = append(items, blockedIPInfo(entry))
 	}
 	return items
 }
 
-func (m *Manager) BlockIP(ip string) {
-	m.blocklist.Add(ip)
+func blockedIPInfo(entry BlockedEntry) models.BlockedIPInfo {
+	info := models.BlockedIPInfo{
+		IP:        entry.String(),
+		Timestamp: entry.Added,
+		Reason:    entry.Reason,
+	}
+	if !entry.Expires.IsZero() {
+		expires := entry.Expires
+		info.ExpiresAt = &expires
+	}
+	return info
+}
+
+// BlockIP blocks an address or CIDR range, for duration or until it is
+// unblocked if duration is zero.
+func (m *Manager) BlockIP(ip string, duration time.Duration, reason string) error {
+	prefix, err := ParseBlockPrefix(ip)
+	if err != nil {
+		return err
+	}
+	entry := BlockedEntry{Prefix: prefix, Added: time.Now(), Reason: reason}
+	if duration > 0 {
+		entry.Expires = entry.Added.Add(duration)
+	}
+	m.blocklist.Add(entry)
+
 	log.WithFields(log.Fields{
-		"ip": ip,
+		"ip":       entry.String(),
+		"duration": duration,
+		"reason":   reason,
 	}).Info("IP address blocked")
+	return nil
+}
+
+// RestoreBlockedIP puts a saved entry back on the blocklist, keeping its
+// timestamp and expiry. Entries that expired meanwhile are dropped.
+func (m *Manager) RestoreBlockedIP(info *models.BlockedIPInfo) error {
+	prefix, err := ParseBlockPrefix(info.IP)
+	if err != nil {
+		return err
+	}
+	entry := BlockedEntry{Prefix: prefix, Added: info.Timestamp, Reason: info.Reason}
+	if info.ExpiresAt != nil {
+		entry.Expires = *info.ExpiresAt
+	}
+	m.blocklist.Add(entry)
+	return nil
 }
 
 func (m *Manager) UnblockIP(ip string) {
-	m.blocklist.Remove(ip)
+	prefix, err := ParseBlockPrefix(ip)
+	if err != nil || !m.blocklist.Remove(prefix) {
+		return
+	}
 	log.WithFields(log.Fields{
 		"ip": ip,
 	}).Info("IP address unblocked")
 }
 
+// SetAutoBan configures the automatic banning of abusive clients; nil
+// disables it.
+func (m *Manager) SetAutoBan(config *models.AutoBanConfig) error {
+	return m.autoBan.SetConfig(config)
+}
+
+// AutoBanConfig returns the auto-ban configuration with defaults filled in.
+func (m *Manager) AutoBanConfig() models.AutoBanConfig {
+	return m.autoBan.Config()
+}
+
+// AutoBanHistory returns the escalation state of recently banned
+// clients, to be saved with the blocklist.
+func (m *Manager) AutoBanHistory() []models.AutoBanRecord {
+	return m.autoBan.History()
+}
+
+// RestoreAutoBanHistory brings back the state saved with AutoBanHistory,
+// after SetAutoBan.
+func (m *Manager) RestoreAutoBanHistory(records []*models.AutoBanRecord) {
+	m.autoBan.RestoreHistory(records)
+}
+
+// ReportAbuse counts a failed authentication or malformed handshake of the
+// client towards an automatic ban.
+func (m *Manager) ReportAbuse(ip string, signal AbuseSignal) {
+	m.autoBan.Report(ip, signal)
+}
+
+// ReportConnection counts a new connection of the client towards the
+// connection burst limit of the auto-ban.
+func (m *Manager) ReportConnection(ip string) {
+	m.autoBan.ReportConnection(ip)
+}
+
+// SetAllowlist turns the allowlist-only mode on or off and replaces the
+// allowlist entries.
+func (m *Manager) SetAllowlist(enabled bool, entries []*models.AllowedClient) error {
+	return m.allowlist.Set(enabled, entries)
+}
+
+// IsClientAllowed reports whether the client may connect: in allowlist-only
+// mode it has to be on the allowlist or have an access rule.
+func (m *Manager) IsClientAllowed(ip, user string) bool {
+	if !m.allowlist.Enabled() {
+		return true
+	}
+	return m.allowlist.allowsAddress(ip) || m.allowlist.allowsUser(user) ||
+		m.accessControl.HasRule(ip, user)
+}
+
+// AdmitConnection decides on a connection an inbound has just accepted,
+// before any protocol byte is exchanged. Blocked clients, clients the
+// allowlist rejects by address, clients banned for a connection burst and
+// connections beyond the global limit are refused. release has to be
+// called once an admitted connection is closed.
+func (m *Manager) AdmitConnection(ip, inbound string) (release func(), err error) {
+	if entry, blocked := m.blocklist.Lookup(ip); blocked {
+		return nil, fmt.Errorf("%w: %s is on the IP blocklist as %s", ErrBlocked, ip, entry)
+	}
+	if !m.AdmitAddress(ip, inbound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowlisted, ip)
+	}
+
+	m.ReportConnection(ip)
+	if _, blocked := m.blocklist.Lookup(ip); blocked {
+		return nil, fmt.Errorf("%w: %s was banned for a connection burst", ErrBlocked, ip)
+	}
+
+	limit := m.connLimit.Load()
+	if n := m.connections.Add(1); limit > 0 && n > limit {
+		m.connections.Add(-1)
+		log.WithFields(log.Fields{
+			"clientIP": ip,
+			"inbound":  inbound,
+			"limit":    limit,
+		}).Warn("Connection refused: connection limit reached")
+		return nil, fmt.Errorf("%w (%d)", ErrConnectionLimit, limit)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { m.connections.Add(-1) })
+	}, nil
+}
+
+// SetConnectionLimit limits the connections open at once over all
+// inbounds; zero means no limit.
+func (m *Manager) SetConnectionLimit(limit int) {
+	m.connLimit.Store(int64(max(limit, 0)))
+}
+
+// ActiveConnections returns the number of admitted connections that are
+// still open.
+func (m *Manager) ActiveConnections() int {
+	return int(m.connections.Load())
+}
+
+// AdmitAddress decides on a new connection before its handshake, when only
+// the client address is known. Clients without an entry for the address
+// are let through only if a username could still admit them, and are
+// rejected after authentication otherwise.
+func (m *Manager) AdmitAddress(ip, inbound string) bool {
+	if m.IsClientAllowed(ip, "") ||
+		m.allowlist.hasUsers() || m.accessControl.HasUserRules() {
+		return true
+	}
+	m.allowlist.Reject(ip, "", inbound)
+	return false
+}
+
+// RejectedClients returns the clients turned away by the allowlist that
+// have not been approved or dismissed yet.
+func (m *Manager) RejectedClients() []RejectedClient {
+	return m.allowlist.Rejected()
+}
+
+// ForgetRejectedClient drops a client from RejectedClients.
+func (m *Manager) ForgetRejectedClient(ip, user string) {
+	m.allowlist.Forget(ip, user)
+}
+
+// OnClientRejected registers fn to be called for every connection rejected
+// by the allowlist.
+func (m *Manager) OnClientRejected(fn func(RejectedClient)) {
+	m.allowlist.Subscribe(fn)
+}
+
+// OnBlocklistChange registers fn to be called when an entry is added to,
+// removed from or expires on the IP blocklist.
+func (m *Manager) OnBlocklistChange(fn func(BlocklistEvent)) {
+	m.blocklist.Subscribe(fn)
+}
+
 func (m *Manager) IsIPBlocked(ip string) bool {
 	return m.blocklist.IsBlocked(ip)
 }
@@ -0,0 +1,30 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUseDirectDialerShared(t *testing.T) {
+	m := NewManager(nil, NewAccessControl(time.Hour))
+	tun := &net.Dialer{Timeout: time.Second}
+	redirect := &net.Dialer{Timeout: 2 * time.Second}
+
+	releaseTun := m.UseDirectDialer(tun)
+	releaseRedirect := m.UseDirectDialer(redirect)
+	if m.DirectDialer() != tun {
+		t.Error("second user replaced the dialer in use")
+	}
+
+	releaseTun()
+	releaseTun()
+	if m.DirectDialer() != tun {
+		t.Error("dialer dropped while still in use")
+	}
+
+	releaseRedirect()
+	if d := m.DirectDialer(); d == tun || d == redirect {
+		t.Error("dialer kept after every user released it")
+	}
+}
@@ -60,7 +60,7 @@ func (p *ConnectionPool) Start(ctx context.Context) error {
 		return fmt.Errorf("invalid port number: %w", err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", p.server.Address, port)
+	addr := net.JoinHostPort(p.server.Address, strconv.Itoa(port))
 	logger.Info("Starting connection pool")
 
 	errCh := make(chan error, p.server.Config.Connections)
@@ -197,12 +197,32 @@ func (p *ConnectionPool) GetTunnel() *Tunnel {
 func (p *ConnectionPool) Forward(localConn net.Conn, targetAddr string) error {
 	tunnel := p.GetTunnel()
 	if tunnel == nil {
-		return fmt.Errorf("no available tunnels for server %s", p.server.Name)
+		return fmt.Errorf("%w for server %s", ErrNoTunnel, p.server.Name)
 	}
 
 	return tunnel.Forward(localConn, targetAddr)
 }
 
+func (p *ConnectionPool) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	_, conn, err := p.Open(ctx, network, addr)
+	return conn, err
+}
+
+// Open dials addr and also returns the tunnel used, so the caller can relay
+// through it.
+func (p *ConnectionPool) Open(ctx context.Context, network, addr string) (*Tunnel, net.Conn, error) {
+	tunnel := p.GetTunnel()
+	if tunnel == nil {
+		return nil, nil, fmt.Errorf("%w for server %s", ErrNoTunnel, p.server.Name)
+	}
+
+	conn, err := tunnel.Dial(ctx, network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tunnel, conn, nil
+}
+
 // func (p *ConnectionPool) monitorConnections(ctx context.Context) {
 // 	ticker := time.NewTicker(10 * time.Second)
 // 	defer ticker.Stop()
@@ -0,0 +1,163 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type compiledRule struct {
+	rule  *models.RoutingRule
+	cidrs []*net.IPNet
+}
+
+type Router struct {
+	mu    sync.RWMutex
+	rules []compiledRule
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+func (r *Router) SetRules(rules []*models.RoutingRule) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		c := compiledRule{rule: rule}
+		for _, cidr := range rule.CIDRs {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"rule": rule.Title,
+					"cidr": cidr,
+				}).Warn("Ignoring invalid CIDR in routing rule")
+				continue
+			}
+			c.cidrs = append(c.cidrs, ipNet)
+		}
+		compiled = append(compiled, c)
+	}
+
+	r.mu.Lock()
+	r.rules = compiled
+	r.mu.Unlock()
+}
+
+func (r *Router) GetRules() []*models.RoutingRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rules := make([]*models.RoutingRule, 0, len(r.rules))
+	for _, c := range r.rules {
+		rules = append(rules, c.rule)
+	}
+	return rules
+}
+
+// NeedsIP reports whether any rule matches on CIDRs, so hostname targets
+// have to be resolved before matching.
+func (r *Router) NeedsIP() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.rules {
+		if len(c.cidrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first rule that applies to the flow, or nil when the
+// flow should use the default pool selection. resolved holds the addresses
+// of a hostname target, if known, for CIDR matching.
+func (r *Router) Match(inbound, targetAddr string, resolved []net.IP) *models.RoutingRule {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		host = targetAddr
+	}
+	port, _ := strconv.Atoi(portStr)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips = resolved
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.rules {
+		if c.matches(inbound, host, ips, port) {
+			return c.rule
+		}
+	}
+	return nil
+}
+
+func (c compiledRule) matches(inbound, host string, ips []net.IP, port int) bool {
+	rule := c.rule
+
+	if rule.Inbound != "" && rule.Inbound != inbound {
+		return false
+	}
+
+	if len(rule.Ports) > 0 {
+		found := false
+		for _, p := range rule.Ports {
+			if p == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(rule.Domains) > 0 && !matchDomain(rule.Domains, host) {
+		return false
+	}
+
+	if len(rule.CIDRs) > 0 {
+		found := false
+		for _, ip := range ips {
+			for _, ipNet := range c.cidrs {
+				if ipNet.Contains(ip) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchDomain reports whether host equals one of the domains or is a
+// subdomain of it.
+func matchDomain(domains []string, host string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		if d == "" {
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"xengate/internal/models"
+)
+
+func TestRouterMatch(t *testing.T) {
+	r := NewRouter()
+	r.SetRules([]*models.RoutingRule{
+		{Title: "socks web", Inbound: "socks5", Ports: []int{80, 443}, Domains: []string{"web.example"}},
+		{Title: "domain", Domains: []string{" Example.COM. "}},
+		{Title: "lan", CIDRs: []string{"192.168.0.0/16", "bogus", "2001:db8::/32"}},
+		{Title: "dns", Ports: []int{53}},
+		nil,
+	})
+	if got := len(r.GetRules()); got != 4 {
+		t.Errorf("len(GetRules()) = %d, want 4", got)
+	}
+
+	tests := []struct {
+		name     string
+		inbound  string
+		target   string
+		resolved []net.IP
+		want     string // title, "" for no match
+	}{
+		{"inbound and port", "socks5", "www.web.example:443", nil, "socks web"},
+		{"other inbound", "http", "www.web.example:443", nil, ""},
+		{"other port", "socks5", "www.web.example:8080", nil, ""},
+		{"exact domain", "http", "example.com:443", nil, "domain"},
+		{"subdomain", "http", "A.Example.com.:80", nil, "domain"},
+		{"suffix is not a subdomain", "http", "badexample.com:80", nil, ""},
+		{"ip", "http", "192.168.1.10:22", nil, "lan"},
+		{"ipv6", "http", "[2001:db8::1]:22", nil, "lan"},
+		{"resolved", "http", "nas.local:22", []net.IP{net.ParseIP("192.168.1.20")}, "lan"},
+		{"unresolved", "http", "nas.local:22", nil, ""},
+		{"port only", "tun", "192.0.2.1:53", nil, "dns"},
+		{"no port", "http", "192.0.2.1", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if rule := r.Match(tt.inbound, tt.target, tt.resolved); rule != nil {
+				got = rule.Title
+			}
+			if got != tt.want {
+				t.Errorf("Match(%q, %q) = %q, want %q", tt.inbound, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterNeedsIP(t *testing.T) {
+	r := NewRouter()
+	r.SetRules([]*models.RoutingRule{{Domains: []string{"example.com"}}, {CIDRs: []string{"bogus"}}})
+	if r.NeedsIP() {
+		t.Error("NeedsIP() = true without valid address rules")
+	}
+	r.SetRules([]*models.RoutingRule{{CIDRs: []string{"10.0.0.0/8"}}})
+	if !r.NeedsIP() {
+		t.Error("NeedsIP() = false with an address rule")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	m := NewManager(nil, NewAccessControl(time.Hour))
+	m.SetRoutingRules([]*models.RoutingRule{
+		{Title: "ads", Domains: []string{"ads.example"}, Action: models.RouteActionReject},
+		{Title: "lan", CIDRs: []string{"192.168.0.0/16"}, Action: models.RouteActionDirect},
+	})
+	if err := m.BlockIP("198.51.100.7", 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		clientIP string
+		target   string
+		allowed  bool
+		action   string
+		rule     string
+		lastStep string
+	}{
+		{"blocked client", "198.51.100.7", "192.168.1.1:80", false, models.RouteActionReject, "", "Blocklist"},
+		{"rejected", "192.0.2.1", "ads.example:443", false, models.RouteActionReject, "ads", "Routing"},
+		{"direct", "192.0.2.1", "192.168.1.1:80", true, models.RouteActionDirect, "lan", "Pool"},
+		{"no pool", "192.0.2.1", "203.0.113.1:443", false, models.RouteActionReject, "", "Pool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := m.Explain(&Metadata{Inbound: "http", ClientIP: tt.clientIP, Target: tt.target})
+			var rule string
+			if d.RoutingRule != nil {
+				rule = d.RoutingRule.Title
+			}
+			if d.Allowed != tt.allowed || d.Action != tt.action || rule != tt.rule {
+				t.Errorf("Explain() = allowed %v, action %q, rule %q; want %v, %q, %q",
+					d.Allowed, d.Action, rule, tt.allowed, tt.action, tt.rule)
+			}
+			if last := d.Steps[len(d.Steps)-1]; last.Stage != tt.lastStep {
+				t.Errorf("last step = %+v, want stage %q", last, tt.lastStep)
+			}
+		})
+	}
+}
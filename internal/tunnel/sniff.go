@@ -0,0 +1,215 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// SniffTimeout bounds the wait for the first bytes of a flow. Protocols
+	// where the server speaks first are delayed by this much.
+	SniffTimeout = 300 * time.Millisecond
+
+	// maxSniffSize is a full TLS record, enough for any ClientHello that
+	// fits in one.
+	maxSniffSize = 5 + 1<<14
+)
+
+var (
+	errSniffMore = errors.New("need more data")
+	errSniffNone = errors.New("no domain found")
+)
+
+var httpMethods = []string{
+	"GET ", "POST ", "HEAD ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ",
+}
+
+// Sniff reads the first bytes the client sends, for at most timeout, and
+// returns the server name of a TLS ClientHello or the Host of an HTTP
+// request. The returned connection replays the bytes that were read, so
+// the client data reaches the target unchanged.
+func Sniff(conn net.Conn, timeout time.Duration) (net.Conn, string) {
+	buf := make([]byte, maxSniffSize)
+	n := 0
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var domain string
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+		if read > 0 {
+			name, sniffErr := sniffDomain(buf[:n])
+			if sniffErr != errSniffMore {
+				domain = name
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return &sniffedConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(buf[:n]), conn),
+	}, domain
+}
+
+type sniffedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *sniffedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// sniffDomain returns the domain found in data, errSniffMore if data is the
+// incomplete start of a TLS or HTTP request, or errSniffNone.
+func sniffDomain(data []byte) (string, error) {
+	var name string
+	var err error
+	if data[0] == 0x16 {
+		name, err = sniffTLS(data)
+	} else {
+		name, err = sniffHTTP(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || net.ParseIP(name) != nil {
+		return "", errSniffNone
+	}
+	return name, nil
+}
+
+// sniffTLS returns the server name extension of a ClientHello.
+func sniffTLS(data []byte) (string, error) {
+	if len(data) < 5 {
+		return "", errSniffMore
+	}
+	if data[1] != 3 {
+		return "", errSniffNone
+	}
+	recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+recordLen {
+		return "", errSniffMore
+	}
+
+	// handshake type, length, client version, random
+	hello := data[5 : 5+recordLen]
+	if len(hello) < 38 || hello[0] != 1 {
+		return "", errSniffNone
+	}
+	p := hello[38:]
+
+	// session id, cipher suites, compression methods
+	for _, lenSize := range []int{1, 2, 1} {
+		if len(p) < lenSize {
+			return "", errSniffNone
+		}
+		n := int(p[0])
+		if lenSize == 2 {
+			n = int(binary.BigEndian.Uint16(p))
+		}
+		if len(p) < lenSize+n {
+			return "", errSniffNone
+		}
+		p = p[lenSize+n:]
+	}
+
+	if len(p) < 2 {
+		return "", errSniffNone
+	}
+	extLen := int(binary.BigEndian.Uint16(p))
+	p = p[2:]
+	if len(p) < extLen {
+		return "", errSniffNone
+	}
+	p = p[:extLen]
+
+	for len(p) >= 4 {
+		extType := binary.BigEndian.Uint16(p)
+		n := int(binary.BigEndian.Uint16(p[2:]))
+		if len(p) < 4+n {
+			break
+		}
+		ext := p[4 : 4+n]
+		p = p[4+n:]
+		if extType != 0 { // server_name
+			continue
+		}
+
+		// server name list: length, then type and length prefixed names
+		if len(ext) < 2 {
+			break
+		}
+		ext = ext[2:]
+		for len(ext) >= 3 {
+			nameType := ext[0]
+			nameLen := int(binary.BigEndian.Uint16(ext[1:]))
+			if len(ext) < 3+nameLen {
+				break
+			}
+			if nameType == 0 { // host_name
+				return string(ext[3 : 3+nameLen]), nil
+			}
+			ext = ext[3+nameLen:]
+		}
+		break
+	}
+	return "", errSniffNone
+}
+
+// sniffHTTP returns the Host header of an HTTP/1 request.
+func sniffHTTP(data []byte) (string, error) {
+	known := false
+	for _, method := range httpMethods {
+		n := min(len(method), len(data))
+		if string(data[:n]) == method[:n] {
+			if n < len(method) {
+				return "", errSniffMore
+			}
+			known = true
+			break
+		}
+	}
+	if !known {
+		return "", errSniffNone
+	}
+
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return "", errSniffMore
+	}
+
+	lines := strings.Split(string(data[:end]), "\r\n")
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.Trim(host, "[]"), nil
+	}
+	return "", errSniffNone
+}
@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello returns the first record a TLS client sends for serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		// Without a server name the client only starts if it skips verification.
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("reading record header: %v", err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("reading record body: %v", err)
+	}
+	return append(header, body...)
+}
+
+func TestSniffDomain(t *testing.T) {
+	hello := clientHello(t, "Example.COM")
+
+	tests := []struct {
+		name   string
+		data   []byte
+		domain string
+		err    error
+	}{
+		{"tls", hello, "example.com", nil},
+		{"tls partial record", hello[:len(hello)-1], "", errSniffMore},
+		{"tls record header only", hello[:5], "", errSniffMore},
+		{"tls wrong version", append([]byte{0x16, 2}, hello[2:]...), "", errSniffNone},
+		{"tls without sni", clientHello(t, ""), "", errSniffNone},
+		{"http", []byte("GET / HTTP/1.1\r\nHost: www.example.org:8080\r\nAccept: */*\r\n\r\n"), "www.example.org", nil},
+		{"http header case", []byte("POST /x HTTP/1.1\r\nhost: Example.net.\r\n\r\n"), "example.net", nil},
+		{"http partial method", []byte("GE"), "", errSniffMore},
+		{"http partial headers", []byte("GET / HTTP/1.1\r\nHost: example.org\r\n"), "", errSniffMore},
+		{"http address host", []byte("GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n"), "", errSniffNone},
+		{"http without host", []byte("GET / HTTP/1.1\r\n\r\n"), "", errSniffNone},
+		{"ssh", []byte("SSH-2.0-OpenSSH_9.6\r\n"), "", errSniffNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, err := sniffDomain(tt.data)
+			if domain != tt.domain || err != tt.err {
+				t.Errorf("sniffDomain() = %q, %v; want %q, %v", domain, err, tt.domain, tt.err)
+			}
+		})
+	}
+}
+
+func TestSniffReplaysData(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: example.org\r\n\r\n"
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte(request))
+		client.Close()
+	}()
+
+	conn, domain := Sniff(server, time.Second)
+	if domain != "example.org" {
+		t.Errorf("domain = %q, want example.org", domain)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("reading sniffed connection: %v", err)
+	}
+	if string(data) != request {
+		t.Errorf("replayed %q, want %q", data, request)
+	}
+}
@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"xengate/internal/models"
+)
+
+type TraceStep struct {
+	Stage  string
+	Result string
+	Detail string
+}
+
+// RouteDecision is the outcome of a routing dry-run: what Forward would do
+// with a flow, and why.
+type RouteDecision struct {
+	Metadata     Metadata
+	Allowed      bool
+	BlockedSince *time.Time
+	AccessRule   *models.AccessRule
+	AccessStatus *AccessStatus
+	RoutingRule  *models.RoutingRule
+	Action       string
+	Pool         string
+	Steps        []TraceStep
+}
+
+func (d *RouteDecision) addStep(stage, result, detail string) {
+	d.Steps = append(d.Steps, TraceStep{Stage: stage, Result: result, Detail: detail})
+}
+
+// Explain runs the same checks as ForwardMetadata without opening any
+// connection or starting an access session.
+func (m *Manager) Explain(meta *Metadata) *RouteDecision {
+	d := &RouteDecision{Metadata: *meta}
+
+	// Blocklist
+	if entry, blocked := m.blocklist.Lookup(meta.ClientIP); blocked {
+		d.BlockedSince = &entry.Added
+		detail := fmt.Sprintf("%s is blocked by %s since %s",
+			meta.ClientIP, entry.String(), entry.Added.Format("2006-01-02 15:04:05"))
+		if !entry.Expires.IsZero() {
+			detail += fmt.Sprintf(" until %s", entry.Expires.Format("2006-01-02 15:04:05"))
+		}
+		if entry.Reason != "" {
+			detail += fmt.Sprintf(" (%s)", entry.Reason)
+		}
+		d.addStep("Blocklist", "Denied", detail)
+		d.Action = models.RouteActionReject
+		return d
+	}
+	d.addStep("Blocklist", "Passed", fmt.Sprintf("%s is not on the blocklist", meta.ClientIP))
+
+	// Allowlist
+	if m.allowlist.Enabled() {
+		if !m.IsClientAllowed(meta.ClientIP, meta.User) {
+			d.addStep("Allowlist", "Denied", "Client is not on the allowlist and has no access rule")
+			d.Action = models.RouteActionReject
+			return d
+		}
+		d.addStep("Allowlist", "Passed", "Client is on the allowlist or has an access rule")
+	}
+
+	// Access rules
+	rule, status, allowed := m.accessControl.CheckSession(meta.ClientIP, meta.User)
+	d.AccessRule = rule
+	d.AccessStatus = status
+	switch {
+	case rule == nil:
+		d.addStep("Access Rule", "Passed", "No access rule for this client (unrestricted)")
+	case rule.IsMaster:
+		d.addStep("Access Rule", "Passed", fmt.Sprintf("Rule %q is a master rule", rule.Title))
+	case !allowed:
+		reason := "daily limit exceeded"
+		if status.ActiveSince != nil {
+			reason = "a session is already active"
+		}
+		d.addStep("Access Rule", "Denied",
+			fmt.Sprintf("Rule %q: %s (used %s of %s)", rule.Title, reason, status.UsedTime.Round(time.Second), rule.DailyLimit))
+		d.Action = models.RouteActionReject
+		return d
+	default:
+		d.addStep("Access Rule", "Passed",
+			fmt.Sprintf("Rule %q: used %s of %s", rule.Title, status.UsedTime.Round(time.Second), rule.DailyLimit))
+	}
+
+	// Routing
+	// Resolving may go through a tunnel, so the dry run only matches
+	// names and reports that the target would be resolved.
+	route, _ := m.matchRoute(meta, false)
+	d.RoutingRule = route
+	if m.needsResolve(meta.Target) && meta.Domain == "" {
+		host, _, _ := net.SplitHostPort(meta.Target)
+		d.addStep("Resolve", "Would resolve",
+			fmt.Sprintf("%s would be resolved to match address rules; only name rules were checked", host))
+	}
+	if list, blocked := m.matchDestination(meta, nil); blocked {
+		d.addStep("Destination", "Denied", fmt.Sprintf("Blocked by destination list %q", list))
+		d.Action = models.RouteActionReject
+		return d
+	}
+	d.addStep("Destination", "Passed", "Not on any destination list")
+
+	if route == nil {
+		d.addStep("Routing", "Default", "No routing rule matched")
+	} else {
+		d.addStep("Routing", "Matched", fmt.Sprintf("Rule %q (action: %s)", route.Title, route.Action))
+		switch route.Action {
+		case models.RouteActionReject:
+			d.Action = models.RouteActionReject
+			return d
+		case models.RouteActionDirect:
+			d.Allowed = true
+			d.Action = models.RouteActionDirect
+			d.addStep("Pool", "Bypassed", "Connection would be dialed directly")
+			return d
+		}
+	}
+
+	// Pool selection
+	pool, err := m.selectPool(route)
+	if err != nil {
+		d.addStep("Pool", "Failed", err.Error())
+		d.Action = models.RouteActionReject
+		return d
+	}
+
+	stats := pool.GetStats()
+	d.Allowed = true
+	d.Action = models.RouteActionTunnel
+	d.Pool = pool.server.Name
+	d.addStep("Pool", "Selected",
+		fmt.Sprintf("%s (%d/%d tunnels connected, %d active connections)",
+			pool.server.Name, stats.Connected, stats.TotalTunnels, stats.ActiveConnections))
+	return d
+}
@@ -222,81 +222,109 @@ func (t *Tunnel) keepAlive() {
 }
 
 func (t *Tunnel) Forward(localConn net.Conn, targetAddr string) error {
-	atomic.AddInt64(&t.requestCount, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	logger := log.WithFields(log.Fields{
-		"tunnel": t.id,
-		"target": targetAddr,
-	})
+	remoteConn, err := t.Dial(ctx, "tcp", targetAddr)
+	if err != nil {
+		return err
+	}
+	defer remoteConn.Close()
+
+	return t.Relay(localConn, remoteConn)
+}
+
+// Dial opens a raw connection to addr through the SSH client.
+func (t *Tunnel) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	atomic.AddInt64(&t.requestCount, 1)
 
 	t.mu.RLock()
 	client := t.client
 	t.mu.RUnlock()
 
 	if client == nil {
-		return fmt.Errorf("tunnel not connected")
+		return nil, fmt.Errorf("tunnel %s not connected: %w", t.id, ErrNoTunnel)
 	}
 
-	atomic.AddInt64(&t.active, 1)
-	defer atomic.AddInt64(&t.active, -1)
-
 	t.mu.Lock()
 	t.lastUsed = time.Now()
 	t.mu.Unlock()
 
-	logger.Debug("Starting forward connection")
+	conn, err := client.DialContext(ctx, network, addr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tunnel": t.id,
+			"target": addr,
+		}).WithError(err).Debug("Failed to dial target")
+		return nil, dialError(addr, err)
+	}
+	return conn, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// Relay copies data between localConn and a connection opened with Dial,
+// accounting it in the tunnel stats.
+func (t *Tunnel) Relay(localConn, remoteConn net.Conn) error {
+	logger := log.WithFields(log.Fields{
+		"tunnel": t.id,
+		"target": remoteConn.RemoteAddr().String(),
+	})
 
-	if strings.HasPrefix(targetAddr, "[") {
-		targetAddr = targetAddr[1 : len(targetAddr)-1]
-	}
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
 
-	remoteConn, err := client.DialContext(ctx, "tcp", targetAddr)
+	logger.Debug("Starting forward connection")
+
+	n, err := relay(localConn, remoteConn)
+	atomic.AddInt64(&t.totalBytes, n)
 	if err != nil {
-		logger.WithError(err).Error("Failed to dial target")
-		return fmt.Errorf("failed to dial %s: %w", targetAddr, err)
+		logger.WithError(err).Error("Forward error")
+		return err
 	}
-	defer remoteConn.Close()
 
-	logger.Debug("Connected to target")
+	logger.Debug("Forward connection completed normally")
+	return nil
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
 
+// relay copies data in both directions until both sides are done and
+// returns the number of bytes transferred.
+func relay(localConn, remoteConn net.Conn) (int64, error) {
+	var total int64
 	errCh := make(chan error, 2)
 
 	go func() {
 		n, err := io.Copy(remoteConn, localConn)
-		atomic.AddInt64(&t.totalBytes, n)
-		if tcpConn, ok := remoteConn.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
+		atomic.AddInt64(&total, n)
+		if cw, ok := remoteConn.(closeWriter); ok {
+			cw.CloseWrite()
 		}
 		errCh <- err
 	}()
 
 	go func() {
 		n, err := io.Copy(localConn, remoteConn)
-		atomic.AddInt64(&t.totalBytes, n)
-		if tcpConn, ok := localConn.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
+		atomic.AddInt64(&total, n)
+		if cw, ok := localConn.(closeWriter); ok {
+			cw.CloseWrite()
 		}
 		errCh <- err
 	}()
 
 	err1 := <-errCh
 	err2 := <-errCh
+	total = atomic.LoadInt64(&total)
 
 	if isNormalError(err1) && isNormalError(err2) {
-		logger.Debug("Forward connection completed normally")
-		return nil
+		return total, nil
 	}
 
-	if err1 != nil {
-		logger.WithError(err1).Error("Forward error (local to remote)")
-		return fmt.Errorf("local to remote error: %w", err1)
+	if err1 != nil && !isNormalError(err1) {
+		return total, fmt.Errorf("local to remote error: %w", err1)
 	}
-
-	logger.WithError(err2).Error("Forward error (remote to local)")
-	return fmt.Errorf("remote to local error: %w", err2)
+	return total, fmt.Errorf("remote to local error: %w", err2)
 }
 
 func (t *Tunnel) Disconnect() {
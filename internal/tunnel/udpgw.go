@@ -0,0 +1,405 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"xengate/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SSH only forwards TCP, so UDP is carried over a TCP stream to a
+// badvpn-udpgw compatible daemon running on the server. Each frame is a
+// little-endian uint16 length followed by a header (flags, conid) and the
+// destination address in network byte order.
+const (
+	DefaultUDPGW = "127.0.0.1:7300"
+
+	udpgwFlagKeepalive = 1 << 0
+	udpgwFlagRebind    = 1 << 1
+	udpgwFlagDNS       = 1 << 2
+	udpgwFlagIPv6      = 1 << 3
+
+	udpgwHeaderLen  = 3
+	udpgwMaxPayload = 65507
+
+	// udpgwIdleTimeout is how long a destination keeps its connection id
+	// and check verdict without datagrams.
+	udpgwIdleTimeout = 2 * time.Minute
+)
+
+// UDPRelay carries the datagrams of one UDP association through a tunnel.
+// Every destination, or every pair of source and destination for
+// WriteToFrom, gets its own udpgw connection id, which it gives up after
+// udpgwIdleTimeout without datagrams.
+type UDPRelay struct {
+	conn      net.Conn
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	dests     map[string]*udpgwDest // key: source and addr
+	ids       map[uint16]string     // ids in use, to their dests key
+	nextID    uint16
+	check     func(target string, addr *net.UDPAddr) error
+	verdicts  map[string]*udpVerdict // key: target and addr
+	lastSweep time.Time
+	onClose   func()
+	closed    sync.Once
+}
+
+type udpgwDest struct {
+	id       uint16
+	src      *net.UDPAddr
+	lastUsed time.Time
+}
+
+func udpgwDestKey(src, addr *net.UDPAddr) string {
+	if src == nil {
+		return addr.String()
+	}
+	return src.String() + "|" + addr.String()
+}
+
+type udpVerdict struct {
+	err      error
+	lastUsed time.Time
+}
+
+func newUDPRelay(conn net.Conn, check func(string, *net.UDPAddr) error, onClose func()) *UDPRelay {
+	return &UDPRelay{
+		conn:      conn,
+		dests:     make(map[string]*udpgwDest),
+		ids:       make(map[uint16]string),
+		check:     check,
+		verdicts:  make(map[string]*udpVerdict),
+		lastSweep: time.Now(),
+		onClose:   onClose,
+	}
+}
+
+// allow runs the destination checks for a datagram, once per destination
+// of the association.
+func (r *UDPRelay) allow(target string, addr *net.UDPAddr) error {
+	if r.check == nil {
+		return nil
+	}
+	key := target + "|" + addr.String()
+	now := time.Now()
+
+	r.mu.Lock()
+	verdict, checked := r.verdicts[key]
+	if checked {
+		verdict.lastUsed = now
+	}
+	r.mu.Unlock()
+	if checked {
+		return verdict.err
+	}
+
+	err := r.check(target, addr)
+	r.mu.Lock()
+	r.verdicts[key] = &udpVerdict{err: err, lastUsed: now}
+	r.mu.Unlock()
+	return err
+}
+
+// conID returns the connection id of addr, for datagrams from src, and
+// whether it was assigned now. Ids still in use are skipped when the
+// counter wraps; with all of them in use, the least recently used
+// destination gives up its id.
+func (r *UDPRelay) conID(src, addr *net.UDPAddr) (uint16, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.sweepLocked(now)
+
+	key := udpgwDestKey(src, addr)
+	if dest, ok := r.dests[key]; ok {
+		dest.lastUsed = now
+		return dest.id, false
+	}
+
+	if len(r.ids) >= 1<<16-1 {
+		r.evictOldestLocked()
+	}
+	for {
+		r.nextID++
+		if _, used := r.ids[r.nextID]; r.nextID != 0 && !used {
+			break
+		}
+	}
+	r.dests[key] = &udpgwDest{id: r.nextID, src: src, lastUsed: now}
+	r.ids[r.nextID] = key
+	return r.nextID, true
+}
+
+func (r *UDPRelay) evictOldestLocked() {
+	var oldest string
+	for key, dest := range r.dests {
+		if oldest == "" || dest.lastUsed.Before(r.dests[oldest].lastUsed) {
+			oldest = key
+		}
+	}
+	delete(r.ids, r.dests[oldest].id)
+	delete(r.dests, oldest)
+}
+
+// sweepLocked forgets the destinations and verdicts idle for longer than
+// udpgwIdleTimeout, at most once per timeout.
+func (r *UDPRelay) sweepLocked(now time.Time) {
+	if now.Sub(r.lastSweep) < udpgwIdleTimeout {
+		return
+	}
+	r.lastSweep = now
+
+	for key, dest := range r.dests {
+		if now.Sub(dest.lastUsed) > udpgwIdleTimeout {
+			delete(r.ids, dest.id)
+			delete(r.dests, key)
+		}
+	}
+	for key, verdict := range r.verdicts {
+		if now.Sub(verdict.lastUsed) > udpgwIdleTimeout {
+			delete(r.verdicts, key)
+		}
+	}
+}
+
+// WriteTo sends a datagram to addr, which must be an IP address. target
+// is the destination as the client gave it, which may name a host; both
+// are checked against the destination lists and routing rules, and
+// datagrams they refuse are dropped with an error for which
+// IsPolicyError is true.
+func (r *UDPRelay) WriteTo(p []byte, addr *net.UDPAddr, target string) error {
+	return r.WriteToFrom(p, nil, addr, target)
+}
+
+// WriteToFrom is WriteTo for an association shared by several sources.
+// Datagrams from src keep their own connection id to addr, so ReadFromTo
+// can tell which source a reply is for.
+func (r *UDPRelay) WriteToFrom(p []byte, src, addr *net.UDPAddr, target string) error {
+	if len(p) > udpgwMaxPayload {
+		return fmt.Errorf("datagram too large: %d bytes", len(p))
+	}
+	if err := r.allow(target, addr); err != nil {
+		return err
+	}
+
+	id, isNew := r.conID(src, addr)
+
+	var flags byte
+	if isNew {
+		flags |= udpgwFlagRebind
+	}
+	if addr.Port == 53 {
+		flags |= udpgwFlagDNS
+	}
+
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+		flags |= udpgwFlagIPv6
+	}
+
+	frameLen := udpgwHeaderLen + len(ip) + 2 + len(p)
+	frame := make([]byte, 2+frameLen)
+	binary.LittleEndian.PutUint16(frame[0:], uint16(frameLen))
+	frame[2] = flags
+	binary.LittleEndian.PutUint16(frame[3:], id)
+	copy(frame[5:], ip)
+	binary.BigEndian.PutUint16(frame[5+len(ip):], uint16(addr.Port))
+	copy(frame[7+len(ip):], p)
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	_, err := r.conn.Write(frame)
+	return err
+}
+
+// ReadFrom reads the next datagram and the address it came from.
+func (r *UDPRelay) ReadFrom(p []byte) (int, *net.UDPAddr, error) {
+	n, from, _, err := r.ReadFromTo(p)
+	return n, from, err
+}
+
+// ReadFromTo reads the next datagram, the address it came from and the
+// source it answers, as given to WriteToFrom. The source is nil for
+// replies to WriteTo and to destinations idle for udpgwIdleTimeout.
+func (r *UDPRelay) ReadFromTo(p []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	for {
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(r.conn, lenBuf[:]); err != nil {
+			return 0, nil, nil, err
+		}
+		frame := make([]byte, binary.LittleEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(r.conn, frame); err != nil {
+			return 0, nil, nil, err
+		}
+		if len(frame) < udpgwHeaderLen {
+			return 0, nil, nil, fmt.Errorf("short udpgw frame")
+		}
+
+		flags := frame[0]
+		if flags&udpgwFlagKeepalive != 0 {
+			continue
+		}
+
+		ipLen := net.IPv4len
+		if flags&udpgwFlagIPv6 != 0 {
+			ipLen = net.IPv6len
+		}
+		if len(frame) < udpgwHeaderLen+ipLen+2 {
+			return 0, nil, nil, fmt.Errorf("short udpgw frame")
+		}
+
+		addr := &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), frame[udpgwHeaderLen:udpgwHeaderLen+ipLen]...)),
+			Port: int(binary.BigEndian.Uint16(frame[udpgwHeaderLen+ipLen:])),
+		}
+		n := copy(p, frame[udpgwHeaderLen+ipLen+2:])
+		return n, addr, r.source(binary.LittleEndian.Uint16(frame[1:])), nil
+	}
+}
+
+// source returns the source that connection id id was assigned for.
+func (r *UDPRelay) source(id uint16) *net.UDPAddr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if dest, ok := r.dests[r.ids[id]]; ok {
+		return dest.src
+	}
+	return nil
+}
+
+func (r *UDPRelay) SetDeadline(t time.Time) error {
+	return r.conn.SetDeadline(t)
+}
+
+func (r *UDPRelay) Close() error {
+	var err error
+	r.closed.Do(func() {
+		err = r.conn.Close()
+		if r.onClose != nil {
+			r.onClose()
+		}
+	})
+	return err
+}
+
+// OpenUDP starts a UDP association for the flow. The access session lasts
+// until the relay is closed.
+func (m *Manager) OpenUDP(meta *Metadata) (*UDPRelay, error) {
+	logger := log.WithFields(log.Fields{
+		"clientIP": meta.ClientIP,
+		"inbound":  meta.Inbound,
+		"user":     meta.User,
+	})
+
+	if m.IsIPBlocked(meta.ClientIP) {
+		logger.Warn("UDP association blocked by IP blocklist")
+		return nil, fmt.Errorf("%w: %s is on the IP blocklist", ErrBlocked, meta.ClientIP)
+	}
+
+	if !m.IsClientAllowed(meta.ClientIP, meta.User) {
+		m.allowlist.Reject(meta.ClientIP, meta.User, meta.Inbound)
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowlisted, meta.ClientIP)
+	}
+
+	if !m.accessControl.StartUserSession(meta.ClientIP, meta.User) {
+		logger.Debug("UDP association denied (time limit exceeded)")
+		m.accessControl.EndUserSession(meta.ClientIP, meta.User)
+		return nil, fmt.Errorf("%w for %s (time limit exceeded)", ErrAccessDenied, meta.ClientIP)
+	}
+
+	pool, err := m.selectPool(nil)
+	if err != nil {
+		m.accessControl.EndUserSession(meta.ClientIP, meta.User)
+		return nil, err
+	}
+
+	udpgw := pool.server.Config.UDPGW
+	if udpgw == "" {
+		udpgw = DefaultUDPGW
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	conn, err := pool.Dial(ctx, "tcp", udpgw)
+	if err != nil {
+		m.accessControl.EndUserSession(meta.ClientIP, meta.User)
+		return nil, fmt.Errorf("failed to reach udpgw on %s: %w", pool.server.Name, err)
+	}
+
+	logger.WithField("pool", pool.server.Name).Debug("UDP association opened")
+	check := func(target string, addr *net.UDPAddr) error {
+		return m.checkUDPTarget(meta, target, addr)
+	}
+	return newUDPRelay(m.userStats.track(meta.User, conn), check, func() {
+		m.accessControl.EndUserSession(meta.ClientIP, meta.User)
+	}), nil
+}
+
+// checkUDPTarget runs the destination list and routing checks of Connect
+// for a datagram of the association to addr, sent to target. UDP is
+// always carried by a tunnel, so routes only decide whether the datagram
+// is rejected.
+func (m *Manager) checkUDPTarget(meta *Metadata, target string, addr *net.UDPAddr) error {
+	flow := *meta
+	flow.Target = addr.String()
+	if host, _, err := net.SplitHostPort(target); err == nil && net.ParseIP(host) == nil {
+		flow.Domain = normalizeDomain(host)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"clientIP":   flow.ClientIP,
+		"targetAddr": target,
+		"inbound":    flow.Inbound,
+		"user":       flow.User,
+	})
+
+	route, _ := m.matchRoute(&flow, false)
+	if list, blocked := m.matchDestination(&flow, nil); blocked {
+		m.destinations.Hit(list)
+		logger.WithField("list", list).Info("UDP datagrams blocked by destination list")
+		return fmt.Errorf("datagrams to %s %w by list %q", target, ErrDestinationBlocked, list)
+	}
+	if route != nil && route.Action == models.RouteActionReject {
+		logger.WithField("route", route.Title).Info("UDP datagrams rejected by routing rule")
+		return fmt.Errorf("datagrams to %s %w %q", target, ErrRejected, route.Title)
+	}
+	return nil
+}
+
+// ResolveUDPAddr turns a host:port target into an IP address using the
+// configured resolver, so that names are not leaked to the local network.
+func (m *Manager) ResolveUDPAddr(ctx context.Context, target string) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.LookupPort("udp", portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+
+	resolver := m.Resolver()
+	if resolver == nil {
+		return nil, fmt.Errorf("cannot resolve %s: no resolver configured", host)
+	}
+	ips, err := resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ips[0], Port: port}, nil
+}
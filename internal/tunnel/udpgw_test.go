@@ -0,0 +1,258 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// udpgwFrame builds a frame as the udpgw daemon sends it.
+func udpgwFrame(flags byte, conID uint16, ip net.IP, port int, payload []byte) []byte {
+	body := []byte{flags}
+	body = binary.LittleEndian.AppendUint16(body, conID)
+	body = append(body, ip...)
+	body = binary.BigEndian.AppendUint16(body, uint16(port))
+	body = append(body, payload...)
+	return append(binary.LittleEndian.AppendUint16(nil, uint16(len(body))), body...)
+}
+
+func TestUDPRelayWriteTo(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  *net.UDPAddr
+		want  []byte
+		again []byte // the next datagram to the same address
+	}{
+		{
+			name:  "ipv4",
+			addr:  &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+			want:  udpgwFrame(udpgwFlagRebind, 1, net.IP{192, 0, 2, 1}, 443, []byte("data")),
+			again: udpgwFrame(0, 1, net.IP{192, 0, 2, 1}, 443, []byte("data")),
+		},
+		{
+			name:  "dns",
+			addr:  &net.UDPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53},
+			want:  udpgwFrame(udpgwFlagRebind|udpgwFlagDNS, 1, net.IP{192, 0, 2, 53}, 53, []byte("data")),
+			again: udpgwFrame(udpgwFlagDNS, 1, net.IP{192, 0, 2, 53}, 53, []byte("data")),
+		},
+		{
+			name:  "ipv6",
+			addr:  &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 123},
+			want:  udpgwFrame(udpgwFlagRebind|udpgwFlagIPv6, 1, net.ParseIP("2001:db8::1"), 123, []byte("data")),
+			again: udpgwFrame(udpgwFlagIPv6, 1, net.ParseIP("2001:db8::1"), 123, []byte("data")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			relay := newUDPRelay(client, nil, nil)
+			defer relay.Close()
+
+			for _, want := range [][]byte{tt.want, tt.again} {
+				go relay.WriteTo([]byte("data"), tt.addr, tt.addr.String())
+				got := make([]byte, len(want))
+				if _, err := io.ReadFull(server, got); err != nil {
+					t.Fatalf("reading frame: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("frame = %x, want %x", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUDPRelayWriteToTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	relay := newUDPRelay(client, nil, nil)
+	defer relay.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}
+	if err := relay.WriteTo(make([]byte, udpgwMaxPayload+1), addr, addr.String()); err == nil {
+		t.Error("WriteTo accepted an oversized datagram")
+	}
+}
+
+func TestUDPRelayWriteToChecksOncePerDestination(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	checks := 0
+	relay := newUDPRelay(client, func(target string, addr *net.UDPAddr) error {
+		checks++
+		if target == "blocked.example:53" {
+			return ErrDestinationBlocked
+		}
+		return nil
+	}, nil)
+	defer relay.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	for i := 0; i < 3; i++ {
+		if err := relay.WriteTo([]byte("q"), addr, "allowed.example:53"); err != nil {
+			t.Fatalf("WriteTo allowed target: %v", err)
+		}
+		if err := relay.WriteTo([]byte("q"), addr, "blocked.example:53"); !errors.Is(err, ErrDestinationBlocked) {
+			t.Fatalf("WriteTo blocked target = %v, want ErrDestinationBlocked", err)
+		}
+	}
+	if checks != 2 {
+		t.Errorf("checked %d times, want once per destination", checks)
+	}
+}
+
+func TestUDPRelayReadFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		addr    string
+		payload string
+		wantErr bool
+	}{
+		{
+			name:    "ipv4",
+			stream:  udpgwFrame(0, 1, net.IP{192, 0, 2, 1}, 443, []byte("reply")),
+			addr:    "192.0.2.1:443",
+			payload: "reply",
+		},
+		{
+			name:    "ipv6",
+			stream:  udpgwFrame(udpgwFlagIPv6, 2, net.ParseIP("2001:db8::1"), 123, []byte("reply")),
+			addr:    "[2001:db8::1]:123",
+			payload: "reply",
+		},
+		{
+			name: "keepalive skipped",
+			stream: append(udpgwFrame(udpgwFlagKeepalive, 0, net.IP{0, 0, 0, 0}, 0, nil),
+				udpgwFrame(0, 1, net.IP{192, 0, 2, 1}, 53, []byte("answer"))...),
+			addr:    "192.0.2.1:53",
+			payload: "answer",
+		},
+		{
+			name:    "short header",
+			stream:  []byte{2, 0, 0, 1},
+			wantErr: true,
+		},
+		{
+			name:    "short address",
+			stream:  udpgwFrame(udpgwFlagIPv6, 1, net.IP{192, 0, 2, 1}, 53, nil),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			stream:  udpgwFrame(0, 1, net.IP{192, 0, 2, 1}, 53, []byte("answer"))[:8],
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			relay := newUDPRelay(client, nil, nil)
+			defer relay.Close()
+			go func() {
+				server.Write(tt.stream)
+				server.Close()
+			}()
+
+			buf := make([]byte, 64)
+			n, addr, err := relay.ReadFrom(buf)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadFrom() = %q from %v, want an error", buf[:n], addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadFrom() error: %v", err)
+			}
+			if addr.String() != tt.addr || string(buf[:n]) != tt.payload {
+				t.Errorf("ReadFrom() = %q from %v, want %q from %s", buf[:n], addr, tt.payload, tt.addr)
+			}
+		})
+	}
+}
+
+func TestUDPRelayConIDSkipsIDsInUse(t *testing.T) {
+	relay := newUDPRelay(nil, nil, nil)
+
+	first, _ := relay.conID(nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1})
+	relay.nextID = 1<<16 - 1
+	id, isNew := relay.conID(nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 2})
+	if !isNew || id == 0 || id == first {
+		t.Errorf("conID after wrapping = %d, %v; want a new id other than 0 and %d", id, isNew, first)
+	}
+	if again, isNew := relay.conID(nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}); again != first || isNew {
+		t.Errorf("conID of the first destination = %d, %v; want %d", again, isNew, first)
+	}
+}
+
+func TestUDPRelayForgetsIdleDestinations(t *testing.T) {
+	relay := newUDPRelay(nil, func(string, *net.UDPAddr) error { return nil }, nil)
+
+	idle := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	relay.allow(idle.String(), idle)
+	relay.conID(nil, idle)
+
+	past := time.Now().Add(-2 * udpgwIdleTimeout)
+	relay.dests[idle.String()].lastUsed = past
+	relay.verdicts[idle.String()+"|"+idle.String()].lastUsed = past
+	relay.lastSweep = past
+
+	active := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}
+	relay.conID(nil, active)
+	if len(relay.dests) != 1 || len(relay.ids) != 1 || len(relay.verdicts) != 0 {
+		t.Errorf("after sweep: %d destinations, %d ids, %d verdicts; want 1, 1, 0",
+			len(relay.dests), len(relay.ids), len(relay.verdicts))
+	}
+	if _, isNew := relay.conID(nil, idle); !isNew {
+		t.Error("idle destination kept its connection id")
+	}
+}
+
+func TestUDPRelaySharedBySources(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	relay := newUDPRelay(client, nil, nil)
+	defer relay.Close()
+
+	dns := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}
+	first := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40001}
+	second := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40002}
+
+	// The daemon takes the three queries, then answers them in reverse
+	// order and once more on an id it never saw.
+	go func() {
+		frame := udpgwFrame(0, 1, dns.IP.To4(), 53, []byte("q"))
+		io.ReadFull(server, make([]byte, 3*len(frame)))
+		for id := uint16(3); id >= 1; id-- {
+			server.Write(udpgwFrame(0, id, dns.IP.To4(), 53, []byte("a")))
+		}
+		server.Write(udpgwFrame(0, 9, dns.IP.To4(), 53, []byte("a")))
+	}()
+
+	for _, src := range []*net.UDPAddr{first, second, nil} {
+		if err := relay.WriteToFrom([]byte("q"), src, dns, dns.String()); err != nil {
+			t.Fatalf("WriteToFrom(%v): %v", src, err)
+		}
+	}
+
+	buf := make([]byte, 16)
+	for _, want := range []*net.UDPAddr{nil, second, first, nil} {
+		_, from, src, err := relay.ReadFromTo(buf)
+		if err != nil {
+			t.Fatalf("ReadFromTo() error: %v", err)
+		}
+		if from.String() != dns.String() || src.String() != want.String() {
+			t.Errorf("ReadFromTo() = from %v for %v, want from %v for %v", from, src, dns, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UserStats are the traffic counters of an authenticated user over all
+// inbounds.
+type UserStats struct {
+	User        string
+	Active      int64 // connections and UDP associations open now
+	Connections int64 // connections and UDP associations opened
+	TotalBytes  int64 // bytes sent and received
+	LastSeen    time.Time
+}
+
+type userCounters struct {
+	active      atomic.Int64
+	connections atomic.Int64
+	totalBytes  atomic.Int64
+	lastSeen    atomic.Int64 // unix nanoseconds
+}
+
+// userStatsTable accounts the outbound connections of flows by the
+// authenticated user they belong to. Anonymous flows are not accounted.
+type userStatsTable struct {
+	mu    sync.RWMutex
+	users map[string]*userCounters
+}
+
+func newUserStatsTable() *userStatsTable {
+	return &userStatsTable{
+		users: make(map[string]*userCounters),
+	}
+}
+
+func (t *userStatsTable) counters(user string) *userCounters {
+	t.mu.RLock()
+	c, ok := t.users[user]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok = t.users[user]; !ok {
+		c = &userCounters{}
+		t.users[user] = c
+	}
+	return c
+}
+
+// track returns conn accounting its traffic to user until it is closed.
+// conn is returned as is for anonymous flows.
+func (t *userStatsTable) track(user string, conn net.Conn) net.Conn {
+	if user == "" {
+		return conn
+	}
+	c := t.counters(user)
+	c.active.Add(1)
+	c.connections.Add(1)
+	c.lastSeen.Store(time.Now().UnixNano())
+	return &countingConn{Conn: conn, counters: c}
+}
+
+func (t *userStatsTable) all() []UserStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := make([]UserStats, 0, len(t.users))
+	for user, c := range t.users {
+		stats = append(stats, UserStats{
+			User:        user,
+			Active:      c.active.Load(),
+			Connections: c.connections.Load(),
+			TotalBytes:  c.totalBytes.Load(),
+			LastSeen:    time.Unix(0, c.lastSeen.Load()),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].User < stats[j].User
+	})
+	return stats
+}
+
+// countingConn adds the bytes read and written to the counters of a user.
+type countingConn struct {
+	net.Conn
+	counters *userCounters
+	closed   sync.Once
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.count(n)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.count(n)
+	return n, err
+}
+
+func (c *countingConn) count(n int) {
+	if n > 0 {
+		c.counters.totalBytes.Add(int64(n))
+		c.counters.lastSeen.Store(time.Now().UnixNano())
+	}
+}
+
+// CloseWrite half-closes the connection if it supports it, so relays can
+// still signal the end of a direction through the wrapper.
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *countingConn) Close() error {
+	c.closed.Do(func() { c.counters.active.Add(-1) })
+	return c.Conn.Close()
+}
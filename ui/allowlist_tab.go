@@ -0,0 +1,336 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"xengate/internal/common"
+	"xengate/internal/models"
+	"xengate/internal/storage"
+	"xengate/internal/tunnel"
+	"xengate/ui/util"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	log "github.com/sirupsen/logrus"
+)
+
+// AllowlistTab manages the allowlist-only mode: the devices and users on
+// the allowlist, and the clients it rejected, which can be approved with
+// one click.
+type AllowlistTab struct {
+	window        fyne.Window
+	manager       *tunnel.Manager
+	container     *fyne.Container
+	table         *widget.Table
+	rejectedList  *widget.List
+	enabledCheck  *widget.Check
+	entries       []*models.AllowedClient
+	rejected      []tunnel.RejectedClient
+	configManager common.ConfigManager
+
+	mu         sync.Mutex
+	newClients []tunnel.RejectedClient // first rejected since the last update
+	update     func()
+}
+
+func NewAllowlistTab(window fyne.Window, manager *tunnel.Manager) *AllowlistTab {
+	storage, err := storage.NewAppStorage(fyne.CurrentApp())
+	if err != nil {
+		log.WithError(err).Error("Failed to initialize storage")
+		return nil
+	}
+
+	tab := &AllowlistTab{
+		window:  window,
+		manager: manager,
+		configManager: &common.DefaultConfigManager{
+			Storage: storage,
+		},
+	}
+
+	enabled := false
+	if config := tab.configManager.LoadConfig(); config != nil {
+		tab.entries = config.Allowlist
+		enabled = config.AllowlistOnly
+	}
+
+	tab.initUI(enabled)
+
+	// Rejections come in bursts from any goroutine; the list and the
+	// notification are updated once the burst is over.
+	tab.update = util.NewDebouncer(500*time.Millisecond, tab.showRejected)
+	manager.OnClientRejected(func(client tunnel.RejectedClient) {
+		if client.Count == 1 {
+			tab.mu.Lock()
+			tab.newClients = append(tab.newClients, client)
+			tab.mu.Unlock()
+		}
+		tab.update()
+	})
+
+	return tab
+}
+
+// showRejected refreshes the rejected clients and notifies about the new
+// ones. It runs on the UI thread.
+func (a *AllowlistTab) showRejected() {
+	a.mu.Lock()
+	clients := a.newClients
+	a.newClients = nil
+	a.mu.Unlock()
+
+	a.refreshRejected()
+
+	switch len(clients) {
+	case 0:
+	case 1:
+		fyne.CurrentApp().SendNotification(&fyne.Notification{
+			Title:   "New client rejected",
+			Content: fmt.Sprintf("%s is not on the allowlist", clientName(clients[0].IP, clients[0].User)),
+		})
+	default:
+		fyne.CurrentApp().SendNotification(&fyne.Notification{
+			Title:   "New clients rejected",
+			Content: fmt.Sprintf("%d clients are not on the allowlist", len(clients)),
+		})
+	}
+}
+
+func clientName(ip, user string) string {
+	if user != "" {
+		return fmt.Sprintf("%s (%s)", user, ip)
+	}
+	return ip
+}
+
+func (a *AllowlistTab) initUI(enabled bool) {
+	a.enabledCheck = widget.NewCheck("Allowlist only: reject clients without an entry or access rule", func(checked bool) {
+		a.saveToConfig()
+		a.apply()
+	})
+	a.enabledCheck.Checked = enabled
+
+	a.table = widget.NewTable(
+		func() (int, int) {
+			return len(a.entries) + 1, 3 // +1 for header row
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+
+			if id.Row == 0 {
+				headers := []string{"Client", "Title", "Added"}
+				if id.Col < len(headers) {
+					label.SetText(headers[id.Col])
+					label.TextStyle = fyne.TextStyle{Bold: true}
+				}
+				return
+			}
+
+			dataRow := id.Row - 1
+			if dataRow >= len(a.entries) {
+				return
+			}
+			entry := a.entries[dataRow]
+			switch id.Col {
+			case 0:
+				if entry.Username != "" {
+					label.SetText("User " + entry.Username)
+				} else {
+					label.SetText(entry.Address)
+				}
+			case 1:
+				label.SetText(entry.Title)
+			case 2:
+				label.SetText(entry.AddedAt.Format("2006-01-02 15:04:05"))
+			}
+		},
+	)
+
+	a.table.SetColumnWidth(0, 180)
+	a.table.SetColumnWidth(1, 180)
+	a.table.SetColumnWidth(2, 180)
+
+	a.table.OnSelected = func(id widget.TableCellID) {
+		if id.Row > 0 && id.Row <= len(a.entries) {
+			a.showRemoveDialog(a.entries[id.Row-1])
+		}
+		a.table.UnselectAll()
+	}
+
+	a.rejectedList = widget.NewList(
+		func() int {
+			return len(a.rejected)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel("Template"),
+				layout.NewSpacer(),
+				widget.NewButtonWithIcon("Approve", theme.ConfirmIcon(), nil),
+				widget.NewButtonWithIcon("Dismiss", theme.CancelIcon(), nil),
+			)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			if id >= len(a.rejected) {
+				return
+			}
+			client := a.rejected[id]
+			row := item.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s via %s, %d attempts, last %s",
+				clientName(client.IP, client.User), client.Inbound, client.Count,
+				client.LastSeen.Format("2006-01-02 15:04:05")))
+			row.Objects[2].(*widget.Button).OnTapped = func() {
+				a.approve(client)
+			}
+			row.Objects[3].(*widget.Button).OnTapped = func() {
+				a.manager.ForgetRejectedClient(client.IP, client.User)
+				a.refreshRejected()
+			}
+		},
+	)
+
+	addButton := widget.NewButtonWithIcon("Add Entry", theme.ContentAddIcon(), func() {
+		a.showAddDialog()
+	})
+
+	toolbar := container.NewHBox(
+		addButton,
+		a.enabledCheck,
+	)
+
+	a.container = container.NewBorder(
+		toolbar, nil, nil, nil,
+		container.NewVSplit(
+			container.NewPadded(a.table),
+			container.NewBorder(
+				widget.NewLabelWithStyle("Rejected Clients", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+				nil, nil, nil,
+				a.rejectedList,
+			),
+		),
+	)
+
+	a.refreshRejected()
+}
+
+func (a *AllowlistTab) showAddDialog() {
+	clientEntry := widget.NewEntry()
+	clientEntry.SetPlaceHolder("IP, CIDR range or username")
+
+	titleEntry := widget.NewEntry()
+	titleEntry.SetPlaceHolder("e.g. Living room TV")
+
+	dialog.ShowForm("Add Allowlist Entry",
+		"Add", "Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("Client", clientEntry),
+			widget.NewFormItem("Title", titleEntry),
+		},
+		func(ok bool) {
+			client := strings.TrimSpace(clientEntry.Text)
+			if !ok || client == "" {
+				return
+			}
+
+			entry := &models.AllowedClient{
+				Title:   strings.TrimSpace(titleEntry.Text),
+				AddedAt: time.Now(),
+			}
+			if _, err := tunnel.ParseBlockPrefix(client); err == nil {
+				entry.Address = client
+			} else {
+				entry.Username = client
+			}
+			a.addEntry(entry)
+		},
+		a.window)
+}
+
+// approve adds a rejected client to the allowlist: the user if it
+// authenticated, the device address otherwise.
+func (a *AllowlistTab) approve(client tunnel.RejectedClient) {
+	entry := &models.AllowedClient{AddedAt: time.Now()}
+	if client.User != "" {
+		entry.Username = client.User
+	} else {
+		entry.Address = client.IP
+	}
+	a.addEntry(entry)
+	a.manager.ForgetRejectedClient(client.IP, client.User)
+	a.refreshRejected()
+}
+
+func (a *AllowlistTab) addEntry(entry *models.AllowedClient) {
+	a.entries = append(a.entries, entry)
+	a.saveToConfig()
+	a.apply()
+}
+
+func (a *AllowlistTab) showRemoveDialog(entry *models.AllowedClient) {
+	name := entry.Address
+	if entry.Username != "" {
+		name = "user " + entry.Username
+	}
+	dialog.ShowConfirm("Remove Entry",
+		fmt.Sprintf("Do you want to remove %s from the allowlist?", name),
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			for i, e := range a.entries {
+				if e == entry {
+					a.entries = append(a.entries[:i], a.entries[i+1:]...)
+					break
+				}
+			}
+			a.saveToConfig()
+			a.apply()
+		},
+		a.window)
+}
+
+// apply hands the allowlist to the manager.
+func (a *AllowlistTab) apply() {
+	if err := a.manager.SetAllowlist(a.enabledCheck.Checked, a.entries); err != nil {
+		log.WithError(err).Warn("Invalid allowlist entries")
+		dialog.ShowError(err, a.window)
+	}
+	a.table.Refresh()
+}
+
+func (a *AllowlistTab) refreshRejected() {
+	a.rejected = a.manager.RejectedClients()
+	sort.Slice(a.rejected, func(i, j int) bool {
+		return a.rejected[i].LastSeen.After(a.rejected[j].LastSeen)
+	})
+	a.rejectedList.Refresh()
+}
+
+func (a *AllowlistTab) saveToConfig() {
+	config := a.configManager.LoadConfig()
+	if config == nil {
+		config = &common.Config{}
+	}
+
+	config.AllowlistOnly = a.enabledCheck.Checked
+	config.Allowlist = a.entries
+
+	if err := a.configManager.SaveConfig(config); err != nil {
+		log.WithError(err).Error("Failed to save allowlist to config")
+		dialog.ShowError(fmt.Errorf("Failed to save configuration: %v", err), a.window)
+	}
+}
+
+func (a *AllowlistTab) Container() fyne.CanvasObject {
+	return a.container
+}
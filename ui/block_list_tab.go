@@ -5,11 +5,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"xengate/internal/common"
 	"xengate/internal/models"
 	"xengate/internal/storage"
 	"xengate/internal/tunnel"
+	"xengate/ui/util"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -49,6 +51,15 @@ func NewBlockListTab(window fyne.Window, manager *tunnel.Manager) *BlockListTab
 	tab.initUI()
 	tab.loadBlockedIPsFromConfig()
 
+	// Entries also change on their own when they expire or a client is
+	// banned automatically; bans are saved too, once a burst of them is
+	// over, so they survive a restart.
+	save := util.NewDebouncer(time.Second, tab.saveToConfig)
+	manager.OnBlocklistChange(func(tunnel.BlocklistEvent) {
+		fyne.Do(tab.refreshList)
+		save()
+	})
+
 	return tab
 }
 
@@ -63,19 +74,18 @@ func (b *BlockListTab) loadBlockedIPsFromConfig() {
 		b.manager.UnblockIP(item.IP)
 	}
 
-	b.items = make([]models.BlockedIPInfo, 0)
-
 	// اضافه کردن IP های موجود در فایل کانفیگ
 	for _, blockedIP := range config.BlockedList {
 		if blockedIP != nil && blockedIP.IP != "" {
-			b.manager.BlockIP(blockedIP.IP)
-			b.items = append(b.items, models.BlockedIPInfo{
-				IP:        blockedIP.IP,
-				Timestamp: blockedIP.Timestamp,
-			})
+			if err := b.manager.RestoreBlockedIP(blockedIP); err != nil {
+				log.WithError(err).Warn("Skipping invalid blocklist entry")
+			}
 		}
 	}
 
+	// ورودی‌های منقضی شده بازیابی نمی‌شوند
+	b.items = b.manager.GetBlockedIPs()
+
 	// مرتب سازی بر اساس زمان
 	b.sortItems()
 
@@ -88,11 +98,8 @@ func (b *BlockListTab) saveToConfig() {
 	currentBlocked := b.manager.GetBlockedIPs()
 	blockedList := make([]*models.BlockedIPInfo, len(currentBlocked))
 
-	for i, item := range currentBlocked {
-		blockedList[i] = &models.BlockedIPInfo{
-			IP:        item.IP,
-			Timestamp: item.Timestamp,
-		}
+	for i := range currentBlocked {
+		blockedList[i] = &currentBlocked[i]
 	}
 
 	config := b.configManager.LoadConfig()
@@ -102,6 +109,12 @@ func (b *BlockListTab) saveToConfig() {
 
 	config.BlockedList = blockedList
 
+	history := b.manager.AutoBanHistory()
+	config.AutoBanHistory = make([]*models.AutoBanRecord, len(history))
+	for i := range history {
+		config.AutoBanHistory[i] = &history[i]
+	}
+
 	if err := b.configManager.SaveConfig(config); err != nil {
 		log.WithError(err).Error("Failed to save blocked IPs to config")
 		dialog.ShowError(fmt.Errorf("Failed to save configuration: %v", err), b.window)
@@ -119,7 +132,7 @@ func (b *BlockListTab) initUI() {
 
 	b.table = widget.NewTable(
 		func() (int, int) {
-			return len(b.items) + 1, 2 // +1 for header row
+			return len(b.items) + 1, 4 // +1 for header row
 		},
 		func() fyne.CanvasObject {
 			return widget.NewLabel("Template")
@@ -128,7 +141,7 @@ func (b *BlockListTab) initUI() {
 			label := cell.(*widget.Label)
 
 			if id.Row == 0 {
-				headers := []string{"IP Address", "Blocked Since"}
+				headers := []string{"IP Address", "Blocked Since", "Expires", "Reason"}
 				if id.Col < len(headers) {
 					label.SetText(headers[id.Col])
 					label.TextStyle = fyne.TextStyle{Bold: true}
@@ -144,6 +157,14 @@ func (b *BlockListTab) initUI() {
 					label.SetText(item.IP)
 				case 1:
 					label.SetText(item.Timestamp.Format("2006-01-02 15:04:05"))
+				case 2:
+					if item.ExpiresAt != nil {
+						label.SetText(item.ExpiresAt.Format("2006-01-02 15:04:05"))
+					} else {
+						label.SetText("Never")
+					}
+				case 3:
+					label.SetText(item.Reason)
 				}
 			}
 		},
@@ -151,6 +172,8 @@ func (b *BlockListTab) initUI() {
 
 	b.table.SetColumnWidth(0, 150)
 	b.table.SetColumnWidth(1, 180)
+	b.table.SetColumnWidth(2, 180)
+	b.table.SetColumnWidth(3, 200)
 
 	addButton := widget.NewButtonWithIcon("Add IP", theme.ContentAddIcon(), func() {
 		b.showAddDialog()
@@ -176,10 +199,15 @@ func (b *BlockListTab) initUI() {
 		}
 	}
 
+	autoBanButton := widget.NewButtonWithIcon("Auto-Ban", theme.SettingsIcon(), func() {
+		b.showAutoBanDialog()
+	})
+
 	toolbar := container.NewHBox(
 		addButton,
 		removeButton,
 		clearButton,
+		autoBanButton,
 	)
 
 	b.container = container.NewBorder(
@@ -195,26 +223,23 @@ func (b *BlockListTab) showAddDialog() {
 	}
 
 	input := widget.NewEntry()
-	input.SetPlaceHolder("Enter IP address")
+	input.SetPlaceHolder("IP address or CIDR range")
+
+	durationEntry := widget.NewEntry()
+	durationEntry.SetPlaceHolder("e.g. 1h, empty for permanent")
+
+	reasonEntry := widget.NewEntry()
 
 	validate := func(s string) error {
 		if s = strings.TrimSpace(s); s == "" {
 			return fmt.Errorf("IP address cannot be empty")
 		}
 
-		parts := strings.Split(s, ".")
-		if len(parts) != 4 {
-			return fmt.Errorf("Invalid IP format")
-		}
-
-		for _, part := range parts {
-			num, err := strconv.Atoi(part)
-			if err != nil || num < 0 || num > 255 {
-				return fmt.Errorf("Invalid IP format")
-			}
+		if _, err := tunnel.ParseBlockPrefix(s); err != nil {
+			return fmt.Errorf("Invalid IP or CIDR format")
 		}
 
-		if b.manager.IsIPBlocked(s) {
+		if !strings.Contains(s, "/") && b.manager.IsIPBlocked(s) {
 			return fmt.Errorf("This IP is already blocked")
 		}
 
@@ -225,6 +250,8 @@ func (b *BlockListTab) showAddDialog() {
 		"Block", "Cancel",
 		[]*widget.FormItem{
 			widget.NewFormItem("IP Address", input),
+			widget.NewFormItem("Duration", durationEntry),
+			widget.NewFormItem("Reason", reasonEntry),
 		},
 		func(ok bool) {
 			if !ok || input.Text == "" {
@@ -236,23 +263,139 @@ func (b *BlockListTab) showAddDialog() {
 				return
 			}
 
-			b.manager.BlockIP(input.Text)
-			b.refreshList()
+			var duration time.Duration
+			if text := strings.TrimSpace(durationEntry.Text); text != "" {
+				d, err := time.ParseDuration(text)
+				if err != nil || d <= 0 {
+					dialog.ShowError(fmt.Errorf("Invalid duration %q", text), b.window)
+					return
+				}
+				duration = d
+			}
+
+			ip := strings.TrimSpace(input.Text)
+			if err := b.manager.BlockIP(ip, duration, strings.TrimSpace(reasonEntry.Text)); err != nil {
+				dialog.ShowError(err, b.window)
+				return
+			}
+			b.saveChanges()
 
 			dialog.ShowInformation("Success",
-				fmt.Sprintf("IP %s has been blocked", input.Text),
+				fmt.Sprintf("%s has been blocked", ip),
 				b.window)
 		},
 		b.window)
 }
 
+// showAutoBanDialog edits the automatic banning of clients that fail
+// authentication, send malformed handshakes or open connections in bursts.
+func (b *BlockListTab) showAutoBanDialog() {
+	current := b.manager.AutoBanConfig()
+
+	enabledCheck := widget.NewCheck("Ban abusive clients automatically", nil)
+	enabledCheck.SetChecked(current.Enabled)
+
+	thresholdEntry := widget.NewEntry()
+	thresholdEntry.SetText(strconv.Itoa(current.Threshold))
+
+	windowEntry := widget.NewEntry()
+	windowEntry.SetText(current.Window.String())
+
+	banTimeEntry := widget.NewEntry()
+	banTimeEntry.SetText(current.BanTime.String())
+
+	maxBanTimeEntry := widget.NewEntry()
+	maxBanTimeEntry.SetText(current.MaxBanTime.String())
+
+	burstEntry := widget.NewEntry()
+	burstEntry.SetPlaceHolder("0 to disable")
+	burstEntry.SetText(strconv.Itoa(current.ConnectionBurst))
+
+	allowlistEntry := widget.NewMultiLineEntry()
+	allowlistEntry.SetPlaceHolder("One IP or CIDR per line")
+	allowlistEntry.SetText(strings.Join(current.Allowlist, "\n"))
+
+	parse := func() (*models.AutoBanConfig, error) {
+		config := &models.AutoBanConfig{Enabled: enabledCheck.Checked}
+
+		var err error
+		if config.Threshold, err = strconv.Atoi(strings.TrimSpace(thresholdEntry.Text)); err != nil || config.Threshold <= 0 {
+			return nil, fmt.Errorf("Threshold must be a positive number")
+		}
+		if config.ConnectionBurst, err = strconv.Atoi(strings.TrimSpace(burstEntry.Text)); err != nil || config.ConnectionBurst < 0 {
+			return nil, fmt.Errorf("Connection burst must be a number")
+		}
+		for _, d := range []struct {
+			name  string
+			entry *widget.Entry
+			value *time.Duration
+		}{
+			{"Window", windowEntry, &config.Window},
+			{"Ban time", banTimeEntry, &config.BanTime},
+			{"Maximum ban time", maxBanTimeEntry, &config.MaxBanTime},
+		} {
+			if *d.value, err = time.ParseDuration(strings.TrimSpace(d.entry.Text)); err != nil || *d.value <= 0 {
+				return nil, fmt.Errorf("%s must be a duration such as 10m", d.name)
+			}
+		}
+		for _, line := range strings.Split(allowlistEntry.Text, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if _, err := tunnel.ParseBlockPrefix(line); err != nil {
+				return nil, err
+			}
+			config.Allowlist = append(config.Allowlist, line)
+		}
+		return config, nil
+	}
+
+	dialog.ShowForm("Auto-Ban",
+		"Save", "Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("", enabledCheck),
+			widget.NewFormItem("Threshold", thresholdEntry),
+			widget.NewFormItem("Window", windowEntry),
+			widget.NewFormItem("Ban Time", banTimeEntry),
+			widget.NewFormItem("Max Ban Time", maxBanTimeEntry),
+			widget.NewFormItem("Connection Burst", burstEntry),
+			widget.NewFormItem("Allowlist", allowlistEntry),
+		},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+
+			autoBan, err := parse()
+			if err != nil {
+				dialog.ShowError(err, b.window)
+				return
+			}
+			if err := b.manager.SetAutoBan(autoBan); err != nil {
+				dialog.ShowError(err, b.window)
+				return
+			}
+
+			config := b.configManager.LoadConfig()
+			if config == nil {
+				config = &common.Config{}
+			}
+			config.AutoBan = autoBan
+			if err := b.configManager.SaveConfig(config); err != nil {
+				log.WithError(err).Error("Failed to save auto-ban settings to config")
+				dialog.ShowError(fmt.Errorf("Failed to save configuration: %v", err), b.window)
+			}
+		},
+		b.window)
+}
+
 func (b *BlockListTab) showRemoveDialog(ip string) {
 	dialog.ShowConfirm("Remove IP",
 		fmt.Sprintf("Do you want to unblock %s?", ip),
 		func(ok bool) {
 			if ok {
 				b.manager.UnblockIP(ip)
-				b.refreshList()
+				b.saveChanges()
 			}
 		},
 		b.window)
@@ -273,10 +416,7 @@ func (b *BlockListTab) clearAllBlockedIPs() {
 	for _, item := range b.items {
 		b.manager.UnblockIP(item.IP)
 	}
-
-	b.items = make([]models.BlockedIPInfo, 0)
-	b.table.Refresh()
-	b.saveToConfig()
+	b.saveChanges()
 }
 
 func (b *BlockListTab) refreshList() {
@@ -285,18 +425,14 @@ func (b *BlockListTab) refreshList() {
 		return
 	}
 
-	blockedIPs := b.manager.GetBlockedIPs()
-	b.items = make([]models.BlockedIPInfo, len(blockedIPs))
-
-	for i, item := range blockedIPs {
-		b.items[i] = models.BlockedIPInfo{
-			IP:        item.IP,
-			Timestamp: item.Timestamp,
-		}
-	}
-
+	b.items = b.manager.GetBlockedIPs()
 	b.sortItems()
 	b.table.Refresh()
+}
+
+// saveChanges shows and saves the list after an edit made in the tab.
+func (b *BlockListTab) saveChanges() {
+	b.refreshList()
 	b.saveToConfig()
 }
 
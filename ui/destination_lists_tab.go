@@ -0,0 +1,262 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"xengate/internal/common"
+	"xengate/internal/models"
+	"xengate/internal/storage"
+	"xengate/internal/tunnel"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	log "github.com/sirupsen/logrus"
+)
+
+// DestinationListsTab manages the files of blocked destinations. Global
+// lists apply to every client, the others to the access rules naming them.
+type DestinationListsTab struct {
+	window        fyne.Window
+	manager       *tunnel.Manager
+	container     *fyne.Container
+	table         *widget.Table
+	lists         []*models.DestinationList
+	configManager common.ConfigManager
+}
+
+func NewDestinationListsTab(window fyne.Window, manager *tunnel.Manager) *DestinationListsTab {
+	storage, err := storage.NewAppStorage(fyne.CurrentApp())
+	if err != nil {
+		log.WithError(err).Error("Failed to initialize storage")
+		return nil
+	}
+
+	tab := &DestinationListsTab{
+		window:  window,
+		manager: manager,
+		configManager: &common.DefaultConfigManager{
+			Storage: storage,
+		},
+	}
+
+	if config := tab.configManager.LoadConfig(); config != nil {
+		tab.lists = config.DestinationLists
+	}
+
+	tab.initUI()
+
+	return tab
+}
+
+func (d *DestinationListsTab) initUI() {
+	d.table = widget.NewTable(
+		func() (int, int) {
+			return len(d.lists) + 1, 5 // +1 for header row
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+
+			if id.Row == 0 {
+				headers := []string{"Name", "File", "Applies To", "Entries", "Hits"}
+				if id.Col < len(headers) {
+					label.SetText(headers[id.Col])
+					label.TextStyle = fyne.TextStyle{Bold: true}
+				}
+				return
+			}
+
+			dataRow := id.Row - 1
+			if dataRow >= len(d.lists) {
+				return
+			}
+			list := d.lists[dataRow]
+			stats, loaded := d.listStats(list.Name)
+
+			switch id.Col {
+			case 0:
+				label.SetText(list.Name)
+			case 1:
+				label.SetText(list.Path)
+			case 2:
+				if list.Global {
+					label.SetText("All clients")
+				} else {
+					label.SetText("Assigned rules")
+				}
+			case 3:
+				if loaded {
+					label.SetText(fmt.Sprintf("%d", stats.Entries))
+				} else {
+					label.SetText("Not loaded")
+				}
+			case 4:
+				label.SetText(fmt.Sprintf("%d", stats.Hits))
+			}
+		},
+	)
+
+	d.table.SetColumnWidth(0, 120)
+	d.table.SetColumnWidth(1, 260)
+	d.table.SetColumnWidth(2, 120)
+	d.table.SetColumnWidth(3, 80)
+	d.table.SetColumnWidth(4, 80)
+
+	d.table.OnSelected = func(id widget.TableCellID) {
+		if id.Row > 0 && id.Row <= len(d.lists) {
+			d.showRemoveDialog(d.lists[id.Row-1])
+		}
+		d.table.UnselectAll()
+	}
+
+	addButton := widget.NewButtonWithIcon("Add List", theme.ContentAddIcon(), func() {
+		d.showAddDialog()
+	})
+
+	reloadButton := widget.NewButtonWithIcon("Reload", theme.ViewRefreshIcon(), func() {
+		d.applyLists()
+	})
+
+	toolbar := container.NewHBox(
+		addButton,
+		reloadButton,
+	)
+
+	d.container = container.NewBorder(
+		toolbar, nil, nil, nil,
+		container.NewPadded(d.table),
+	)
+
+	go d.periodicRefresh()
+}
+
+func (d *DestinationListsTab) showAddDialog() {
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("e.g. adult")
+
+	pathEntry := widget.NewEntry()
+	pathEntry.SetPlaceHolder("/path/to/list.txt")
+	browseButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			pathEntry.SetText(reader.URI().Path())
+			reader.Close()
+		}, d.window)
+	})
+
+	globalCheck := widget.NewCheck("Apply to all clients", nil)
+
+	validate := func() error {
+		name := strings.TrimSpace(nameEntry.Text)
+		if name == "" {
+			return fmt.Errorf("List name cannot be empty")
+		}
+		for _, list := range d.lists {
+			if list.Name == name {
+				return fmt.Errorf("A list named %s already exists", name)
+			}
+		}
+		if strings.TrimSpace(pathEntry.Text) == "" {
+			return fmt.Errorf("List file cannot be empty")
+		}
+		return nil
+	}
+
+	dialog.ShowForm("Add Destination List",
+		"Add", "Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("Name", nameEntry),
+			widget.NewFormItem("File", container.NewBorder(nil, nil, nil, browseButton, pathEntry)),
+			widget.NewFormItem("", globalCheck),
+		},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := validate(); err != nil {
+				dialog.ShowError(err, d.window)
+				return
+			}
+
+			d.lists = append(d.lists, &models.DestinationList{
+				Name:   strings.TrimSpace(nameEntry.Text),
+				Path:   strings.TrimSpace(pathEntry.Text),
+				Global: globalCheck.Checked,
+			})
+			d.saveToConfig()
+			d.applyLists()
+		},
+		d.window)
+}
+
+func (d *DestinationListsTab) showRemoveDialog(list *models.DestinationList) {
+	dialog.ShowConfirm("Remove List",
+		fmt.Sprintf("Do you want to remove the list %s?", list.Name),
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			for i, l := range d.lists {
+				if l == list {
+					d.lists = append(d.lists[:i], d.lists[i+1:]...)
+					break
+				}
+			}
+			d.saveToConfig()
+			d.applyLists()
+		},
+		d.window)
+}
+
+// applyLists reloads the list files into the manager.
+func (d *DestinationListsTab) applyLists() {
+	if err := d.manager.SetDestinationLists(d.lists); err != nil {
+		log.WithError(err).Warn("Failed to load destination lists")
+		dialog.ShowError(err, d.window)
+	}
+	d.table.Refresh()
+}
+
+func (d *DestinationListsTab) saveToConfig() {
+	config := d.configManager.LoadConfig()
+	if config == nil {
+		config = &common.Config{}
+	}
+
+	config.DestinationLists = d.lists
+
+	if err := d.configManager.SaveConfig(config); err != nil {
+		log.WithError(err).Error("Failed to save destination lists to config")
+		dialog.ShowError(fmt.Errorf("Failed to save configuration: %v", err), d.window)
+	}
+}
+
+// listStats returns the entry and hit counts of a loaded list.
+func (d *DestinationListsTab) listStats(name string) (tunnel.DestinationListStats, bool) {
+	for _, s := range d.manager.DestinationListStats() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return tunnel.DestinationListStats{}, false
+}
+
+func (d *DestinationListsTab) periodicRefresh() {
+	ticker := time.NewTicker(5 * time.Second)
+	for range ticker.C {
+		d.table.Refresh()
+	}
+}
+
+func (d *DestinationListsTab) Container() fyne.CanvasObject {
+	return d.container
+}
@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"xengate/internal/common"
 	"xengate/internal/models"
+	"xengate/internal/tunnel"
 	"xengate/ui/util"
 
 	"fyne.io/fyne/v2"
@@ -34,6 +36,7 @@ type EditDialog struct {
 	passwordEntry    *widget.Entry
 	connectionsEntry *widget.Entry
 	maxRetriesEntry  *widget.Entry
+	udpgwEntry       *widget.Entry
 	// proxyAddrEntry   *widget.Entry
 	// proxyPortEntry   *widget.Entry
 	proxyModeSelect *widget.RadioGroup
@@ -66,6 +69,8 @@ func (d *EditDialog) createFormFields() {
 	d.passwordEntry = widget.NewPasswordEntry()
 	d.connectionsEntry = widget.NewEntry()
 	d.maxRetriesEntry = widget.NewEntry()
+	d.udpgwEntry = widget.NewEntry()
+	d.udpgwEntry.SetPlaceHolder(tunnel.DefaultUDPGW)
 	// d.proxyAddrEntry = widget.NewEntry()
 	// d.proxyPortEntry = widget.NewEntry()
 	// d.proxyModeSelect = widget.NewSelect([]string{"socks5", "http"}, nil)
@@ -92,6 +97,7 @@ func (d *EditDialog) populateFields() {
 		d.passwordEntry.SetText(d.conn.Config.Password)
 		d.connectionsEntry.SetText(fmt.Sprintf("%d", d.conn.Config.Connections))
 		d.maxRetriesEntry.SetText(fmt.Sprintf("%d", d.conn.Config.MaxRetries))
+		d.udpgwEntry.SetText(d.conn.Config.UDPGW)
 		// d.proxyAddrEntry.SetText(d.proxy.ListenAddr)
 		// d.proxyPortEntry.SetText(fmt.Sprintf("%d", d.proxy.ListenPort))
 		d.proxyModeSelect.SetSelected(d.conn.Config.Mode)
@@ -120,6 +126,7 @@ func (d *EditDialog) createDialogContent() fyne.CanvasObject {
 	connectionSettings := container.NewGridWithColumns(2,
 		widget.NewForm(&widget.FormItem{Text: "Connections", Widget: d.connectionsEntry}),
 		widget.NewForm(&widget.FormItem{Text: "Max Retries", Widget: d.maxRetriesEntry}),
+		widget.NewForm(&widget.FormItem{Text: "UDPGW", Widget: d.udpgwEntry}),
 	)
 	connectionCard := widget.NewCard("Connection Settings", "Advanced configuration",
 		container.NewPadded(connectionSettings),
@@ -197,6 +204,7 @@ func (d *EditDialog) validateAndSave() error {
 		Mode:        d.proxyModeSelect.Selected,
 		Connections: connections,
 		MaxRetries:  maxRetries,
+		UDPGW:       strings.TrimSpace(d.udpgwEntry.Text),
 	}
 
 	d.conn.Name = d.nameEntry.Text
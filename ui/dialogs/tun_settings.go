@@ -22,20 +22,23 @@ type TunSettingsDialog struct {
 func NewTunSettingsDialog(config *models.TunConfig, parent fyne.Window) *TunSettingsDialog {
 	d := &TunSettingsDialog{
 		config: &models.TunConfig{ // Create a copy of config
-			// Enabled:    config.Enabled,
-			DeviceName: config.DeviceName,
-			Address:    config.Address,
-			Gateway:    config.Gateway,
-			MTU:        config.MTU,
-			DNSServers: make([]string, len(config.DNSServers)),
+			Enabled:       config.Enabled,
+			DeviceName:    config.DeviceName,
+			Address:       config.Address,
+			Gateway:       config.Gateway,
+			MTU:           config.MTU,
+			DNSServers:    append([]string(nil), config.DNSServers...),
+			IncludeRoutes: append([]string(nil), config.IncludeRoutes...),
+			ExcludeRoutes: append([]string(nil), config.ExcludeRoutes...),
+			FakeIP:        config.FakeIP,
+			FakeIPRange:   config.FakeIPRange,
 		},
 	}
-	copy(d.config.DNSServers, config.DNSServers)
 
 	enabledCheck := widget.NewCheck("Enable TUN", func(enabled bool) {
-		// d.config.Enabled = enabled
+		d.config.Enabled = enabled
 	})
-	// enabledCheck.Checked = config.Enabled
+	enabledCheck.Checked = config.Enabled
 
 	deviceEntry := widget.NewEntry()
 	deviceEntry.SetText(config.DeviceName)
@@ -66,7 +69,32 @@ func NewTunSettingsDialog(config *models.TunConfig, parent fyne.Window) *TunSett
 	dnsEntry := widget.NewEntry()
 	dnsEntry.SetText(strings.Join(config.DNSServers, ","))
 	dnsEntry.OnChanged = func(s string) {
-		d.config.DNSServers = strings.Split(s, ",")
+		d.config.DNSServers = splitList(s)
+	}
+
+	includeEntry := widget.NewEntry()
+	includeEntry.SetPlaceHolder("all traffic")
+	includeEntry.SetText(strings.Join(config.IncludeRoutes, ","))
+	includeEntry.OnChanged = func(s string) {
+		d.config.IncludeRoutes = splitList(s)
+	}
+
+	excludeEntry := widget.NewEntry()
+	excludeEntry.SetText(strings.Join(config.ExcludeRoutes, ","))
+	excludeEntry.OnChanged = func(s string) {
+		d.config.ExcludeRoutes = splitList(s)
+	}
+
+	fakeIPCheck := widget.NewCheck("Resolve names to fake IPs", func(enabled bool) {
+		d.config.FakeIP = enabled
+	})
+	fakeIPCheck.Checked = config.FakeIP
+
+	fakeIPRangeEntry := widget.NewEntry()
+	fakeIPRangeEntry.SetPlaceHolder("198.18.0.0/15")
+	fakeIPRangeEntry.SetText(config.FakeIPRange)
+	fakeIPRangeEntry.OnChanged = func(s string) {
+		d.config.FakeIPRange = strings.TrimSpace(s)
 	}
 
 	form := widget.NewForm(
@@ -75,6 +103,10 @@ func NewTunSettingsDialog(config *models.TunConfig, parent fyne.Window) *TunSett
 		&widget.FormItem{Text: "Gateway", Widget: gatewayEntry},
 		&widget.FormItem{Text: "MTU", Widget: mtuEntry},
 		&widget.FormItem{Text: "DNS Servers (comma-separated)", Widget: dnsEntry},
+		&widget.FormItem{Text: "Include Routes (CIDRs)", Widget: includeEntry},
+		&widget.FormItem{Text: "Exclude Routes (CIDRs)", Widget: excludeEntry},
+		&widget.FormItem{Text: "Fake IP DNS", Widget: fakeIPCheck},
+		&widget.FormItem{Text: "Fake IP Range", Widget: fakeIPRangeEntry},
 	)
 
 	// Save and Cancel buttons
@@ -122,3 +154,14 @@ func (d *TunSettingsDialog) SetOnSave(callback func(*models.TunConfig)) {
 func (d *TunSettingsDialog) SetOnCancel(callback func()) {
 	d.onCancel = callback
 }
+
+// splitList splits a comma-separated entry, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,125 @@
+package ui
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"xengate/internal/proxy"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// DNSTab shows the counters and the latest queries of the DNS listener.
+type DNSTab struct {
+	container *fyne.Container
+	table     *widget.Table
+	summary   *widget.Label
+
+	mu      sync.Mutex
+	server  *proxy.DNSServer
+	queries []proxy.DNSQueryLog
+}
+
+func NewDNSTab() *DNSTab {
+	tab := &DNSTab{}
+	tab.initUI()
+	return tab
+}
+
+// SetServer sets the running DNS listener, or nil once it is stopped.
+func (d *DNSTab) SetServer(server *proxy.DNSServer) {
+	d.mu.Lock()
+	d.server = server
+	d.mu.Unlock()
+}
+
+func (d *DNSTab) initUI() {
+	d.summary = widget.NewLabel("DNS server is not running")
+	d.summary.TextStyle = fyne.TextStyle{Bold: true}
+
+	d.table = widget.NewTable(
+		func() (int, int) {
+			return len(d.queries) + 1, 6 // +1 for header row
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+
+			if id.Row == 0 {
+				headers := []string{"Time", "Client", "Name", "Type", "Result", "Duration"}
+				label.SetText(headers[id.Col])
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				return
+			}
+
+			dataRow := id.Row - 1
+			if dataRow >= len(d.queries) {
+				return
+			}
+			entry := d.queries[dataRow]
+			switch id.Col {
+			case 0:
+				label.SetText(entry.Time.Format("15:04:05"))
+			case 1:
+				label.SetText(entry.ClientIP)
+			case 2:
+				label.SetText(entry.Name)
+			case 3:
+				label.SetText(entry.Type)
+			case 4:
+				label.SetText(entry.Result)
+			case 5:
+				label.SetText(entry.Duration.Round(time.Millisecond).String())
+			}
+		},
+	)
+
+	d.table.SetColumnWidth(0, 90)
+	d.table.SetColumnWidth(1, 130)
+	d.table.SetColumnWidth(2, 260)
+	d.table.SetColumnWidth(3, 70)
+	d.table.SetColumnWidth(4, 100)
+	d.table.SetColumnWidth(5, 90)
+
+	d.container = container.NewBorder(
+		d.summary, nil, nil, nil,
+		container.NewPadded(d.table),
+	)
+
+	go d.periodicRefresh()
+}
+
+func (d *DNSTab) refresh() {
+	d.mu.Lock()
+	server := d.server
+	d.mu.Unlock()
+
+	if server == nil {
+		d.queries = nil
+		d.summary.SetText("DNS server is not running")
+		d.table.Refresh()
+		return
+	}
+
+	stats := server.GetStats()
+	d.queries = server.RecentQueries()
+	d.summary.SetText(fmt.Sprintf("Queries: %d   Cache/hosts hits: %d   Forwarded: %d   Blocked: %d   Failed: %d",
+		stats.Queries, stats.CacheHits, stats.Forwarded, stats.Blocked, stats.Failed))
+	d.table.Refresh()
+}
+
+func (d *DNSTab) periodicRefresh() {
+	ticker := time.NewTicker(2 * time.Second)
+	for range ticker.C {
+		fyne.Do(d.refresh)
+	}
+}
+
+func (d *DNSTab) Container() fyne.CanvasObject {
+	return d.container
+}
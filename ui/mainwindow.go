@@ -2,15 +2,20 @@ package ui
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"xengate/backend"
+	"xengate/internal/dns"
 	"xengate/internal/models"
 	"xengate/internal/proxy"
+	"xengate/internal/security"
 	"xengate/internal/tunnel"
 	"xengate/res"
 
@@ -55,6 +60,7 @@ type MainWindow struct {
 	actAbout            *widget.ToolbarAction
 	actMenu             *widget.ToolbarAction
 	actSettings         *widget.ToolbarAction
+	actTunSettings      *widget.ToolbarAction
 	actAdd              *widget.ToolbarAction
 	actToggleView       *widget.ToolbarAction
 	actToggleFullScreen *widget.ToolbarAction
@@ -74,8 +80,11 @@ type MainWindow struct {
 
 	fyneApp fyne.App
 
-	socksServer proxy.Proxy
-	httpServer  proxy.Proxy
+	proxies     []proxy.Proxy
+	dnsTab      *DNSTab
+	listeners   []*models.ListenerConfig
+	credentials *security.StaticCredentialStore
+	dnsConfig   *models.DNSConfig
 
 	Man *tunnel.Manager
 
@@ -123,6 +132,12 @@ func NewMainWindow(fyneApp fyne.App, appName, displayAppName, appVersion string,
 
 	m.Man = tunnel.NewManager(fyneApp, m.accessControl)
 
+	// Undo network changes of a TUN or transparent mode that did not shut
+	// down cleanly.
+	if err := proxy.RecoverNetwork(app.StateDir()); err != nil {
+		log.WithError(err).Warn("Failed to roll back network changes")
+	}
+
 	m.initUI()
 
 	m.setInitialSize()
@@ -254,20 +269,14 @@ func (m *MainWindow) initUI() {
 
 	m.timerPanel.SetOnClick(func(status bool) {
 		if status {
-			m.socksServer, _ = proxy.NewProxy("socks5", strings.TrimSpace(m.ipWidget.Text), 1080, m.Man)
-			m.httpServer, _ = proxy.NewProxy("http", strings.TrimSpace(m.ipWidget.Text), 1090, m.Man)
-			// tuntapproxy, _ := proxy.NewProxy("tuntap", "10.0.0.1", 0, m.Man)
+			m.startProxies()
+			// tuntapproxy, _ := proxy.NewProxy("tuntap", "10.0.0.1", 0, m.Man, nil)
 
 			// if err := tuntapproxy.Start(context.Background()); err != nil {
 			// 	log.Fatal(err)
 			// }
-
-			m.socksServer.Start(context.Background())
-			m.httpServer.Start(context.Background())
-
 		} else {
-			m.socksServer.Stop()
-			m.httpServer.Stop()
+			m.stopProxies()
 		}
 
 		for _, c := range m.connectionList.GetConnections() {
@@ -331,6 +340,46 @@ func (m *MainWindow) initUI() {
 	rulesTab := NewRulesTab(m.Window, m.accessControl)
 	tabs.Append(container.NewTabItem("Access Rules", rulesTab.Container()))
 
+	usersTab := NewUsersTab(m.Man)
+	tabs.Append(container.NewTabItem("Users", usersTab.Container()))
+
+	if config := m.connectionList.GetConfigManager().LoadConfig(); config != nil {
+		m.Man.SetRoutingRules(config.RoutingRules)
+		m.Man.SetSniffing(config.Sniffing)
+		if err := m.Man.SetDestinationLists(config.DestinationLists); err != nil {
+			log.WithError(err).Warn("Failed to load destination lists")
+		}
+		if err := m.Man.SetAutoBan(config.AutoBan); err != nil {
+			log.WithError(err).Warn("Invalid auto-ban configuration")
+		}
+		m.Man.RestoreAutoBanHistory(config.AutoBanHistory)
+		if err := m.Man.SetAllowlist(config.AllowlistOnly, config.Allowlist); err != nil {
+			log.WithError(err).Warn("Invalid allowlist entries")
+		}
+		m.Man.SetConnectionLimit(config.MaxConnections)
+		m.dnsConfig = config.DNS
+		m.Man.SetResolver(newResolver(config.DNS, m.Man))
+		m.listeners = config.Listeners
+		m.credentials = security.NewStaticCredentialStore(config.Users)
+		if config.Tun != nil {
+			m.tunConfig = config.Tun
+		}
+	}
+	if len(m.listeners) == 0 {
+		m.listeners = defaultListeners()
+	}
+	allowlistTab := NewAllowlistTab(m.Window, m.Man)
+	tabs.Append(container.NewTabItem("Allowlist", allowlistTab.Container()))
+
+	destinationListsTab := NewDestinationListsTab(m.Window, m.Man)
+	tabs.Append(container.NewTabItem("Destination Lists", destinationListsTab.Container()))
+
+	m.dnsTab = NewDNSTab()
+	tabs.Append(container.NewTabItem("DNS", m.dnsTab.Container()))
+
+	routeTesterTab := NewRouteTesterTab(m.Window, m.Man, m.inboundNames())
+	tabs.Append(container.NewTabItem("Route Tester", routeTesterTab.Container()))
+
 	proc, err := process.NewProcess(int32(os.Getpid()))
 	if err == nil {
 		monitorTab := NewMonitorTab(proc)
@@ -340,7 +389,7 @@ func (m *MainWindow) initUI() {
 	m.ipWidget = widget.NewEntry()
 	m.ipWidget.SetText("0.0.0.0")
 
-	portLabel := widget.NewLabel("SOCKS5[1080] HTTP[1090]")
+	portLabel := widget.NewLabel(listenersLabel(m.listeners))
 	portLabel.TextStyle = fyne.TextStyle{Monospace: true}
 
 	details := container.NewHBox(
@@ -350,6 +399,175 @@ func (m *MainWindow) initUI() {
 	m.Window.SetContent(container.NewBorder(m.toolBar, container.NewBorder(nil, nil, container.NewHBox(container.NewPadded(container.NewCenter(details))), container.NewPadded(m.timerPanel), nil), nil, nil, container.NewPadded(tabs)))
 }
 
+func defaultListeners() []*models.ListenerConfig {
+	return []*models.ListenerConfig{
+		{Mode: "socks5", Port: 1080, AllowAnonymous: true},
+		{Mode: "http", Port: 1090, AllowAnonymous: true},
+	}
+}
+
+// inboundNames returns the inbound names of the configured listeners, as
+// set on the flows they accept. Mixed listeners hand their flows to the
+// SOCKS and HTTP inbounds.
+func (m *MainWindow) inboundNames() []string {
+	var names []string
+	add := func(name string) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, l := range m.listeners {
+		if l.Mode == "mixed" {
+			add("socks5")
+			add("http")
+			continue
+		}
+		add(l.Mode)
+	}
+	if m.tunConfig != nil && m.tunConfig.Enabled {
+		add("tun")
+	}
+	if m.dnsConfig != nil && m.dnsConfig.ListenPort != 0 {
+		add("dns")
+	}
+	return names
+}
+
+func listenersLabel(listeners []*models.ListenerConfig) string {
+	parts := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		parts = append(parts, fmt.Sprintf("%s[%d]", strings.ToUpper(l.Mode), l.Port))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (m *MainWindow) startProxies() {
+	bindAddr := strings.TrimSpace(m.ipWidget.Text)
+
+	var credentials security.CredentialStore
+	if m.credentials != nil && m.credentials.Len() > 0 {
+		credentials = m.credentials
+	}
+
+	var bypass []string
+	for _, c := range m.connectionList.GetConnections() {
+		bypass = append(bypass, net.JoinHostPort(c.Address, c.Port))
+	}
+
+	for _, l := range m.listeners {
+		addr := l.Address
+		if addr == "" {
+			addr = bindAddr
+		}
+
+		opts := &proxy.Options{
+			Credentials:    credentials,
+			AllowAnonymous: l.AllowAnonymous,
+			XForwardedFor:  l.XForwardedFor,
+			Listeners:      m.listeners,
+			Bypass:         bypass,
+			StateDir:       m.App.StateDir(),
+		}
+		if l.TLS != nil || l.Mode == "https" {
+			tlsConfig, err := m.listenerTLSConfig(l.TLS)
+			if err != nil {
+				log.WithError(err).Errorf("Failed to set up TLS for %s listener on %s:%d", l.Mode, addr, l.Port)
+				continue
+			}
+			opts.TLSConfig = tlsConfig
+		}
+
+		p, err := proxy.NewProxy(l.Mode, addr, l.Port, m.Man, opts)
+		if err == nil {
+			err = p.Start(context.Background())
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Failed to start %s listener on %s:%d", l.Mode, addr, l.Port)
+			continue
+		}
+		m.proxies = append(m.proxies, p)
+	}
+
+	if m.tunConfig != nil && m.tunConfig.Enabled {
+		opts := &proxy.Options{
+			Bypass:   bypass,
+			StateDir: m.App.StateDir(),
+			Tun:      m.tunConfig,
+		}
+		tun, err := proxy.NewProxy("tuntap", "", 0, m.Man, opts)
+		if err == nil {
+			err = tun.Start(context.Background())
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Failed to start TUN on %s", m.tunConfig.DeviceName)
+		} else {
+			m.proxies = append(m.proxies, tun)
+		}
+	}
+
+	if m.dnsConfig != nil && m.dnsConfig.ListenPort != 0 {
+		listenAddr := m.dnsConfig.ListenAddr
+		if listenAddr == "" {
+			listenAddr = bindAddr
+		}
+		dnsServer, err := proxy.NewProxy("dns", listenAddr, m.dnsConfig.ListenPort, m.Man, nil)
+		if err == nil {
+			err = dnsServer.Start(context.Background())
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to start DNS server")
+		} else {
+			m.proxies = append(m.proxies, dnsServer)
+			if server, ok := dnsServer.(*proxy.DNSServer); ok {
+				m.dnsTab.SetServer(server)
+			}
+		}
+	}
+}
+
+func (m *MainWindow) listenerTLSConfig(config *models.TLSConfig) (*tls.Config, error) {
+	if config == nil {
+		config = &models.TLSConfig{}
+	}
+
+	certs, err := security.NewCertManager(m.App.CertsDir(), config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if caFile := certs.CAFile(); caFile != "" {
+		log.Infof("TLS listeners use a certificate issued by the local CA in %s", caFile)
+	}
+	return certs.TLSConfig(config)
+}
+
+func (m *MainWindow) stopProxies() {
+	for _, p := range m.proxies {
+		p.Stop()
+	}
+	m.proxies = nil
+	m.dnsTab.SetServer(nil)
+}
+
+func newResolver(config *models.DNSConfig, man *tunnel.Manager) *dns.Resolver {
+	if config == nil {
+		config = &models.DNSConfig{}
+	}
+
+	resolver := dns.NewResolver(dns.Policy(config.Policy), config.Upstream, man.Dial, config.CacheSize)
+	resolver.Hosts().SetAll(config.Hosts)
+	if config.HostsFile != "" {
+		if f, err := os.Open(config.HostsFile); err == nil {
+			if err := resolver.Hosts().Load(f); err != nil {
+				log.WithError(err).Warn("Failed to load hosts file")
+			}
+			f.Close()
+		} else {
+			log.WithError(err).Warn("Failed to open hosts file")
+		}
+	}
+	return resolver
+}
+
 func (m *MainWindow) DesiredSize() fyne.Size {
 	w := float32(m.App.Config.Application.WindowWidth)
 	if w <= 1 {
@@ -411,6 +629,19 @@ func (m *MainWindow) initToolbar() {
 		m.haveModal = true
 		pop.Show()
 	})
+	m.actTunSettings = widget.NewToolbarAction(theme.ComputerIcon(), func() {
+		dlg := dialogs.NewTunSettingsDialog(m.tunConfig, m.Window)
+		dlg.SetOnSave(func(config *models.TunConfig) {
+			// takes effect the next time the service starts
+			m.tunConfig = config
+			appConfig := m.connectionList.GetConfigManager().LoadConfig()
+			appConfig.Tun = config
+			if err := m.connectionList.GetConfigManager().SaveConfig(appConfig); err != nil {
+				m.showError("Failed to save TUN settings", err)
+			}
+		})
+		dlg.Show()
+	})
 	m.actAbout = widget.NewToolbarAction(theme.InfoIcon(), func() {
 		dlg := dialogs.NewAboutDialog("")
 
@@ -431,6 +662,7 @@ func (m *MainWindow) initToolbar() {
 	m.toolBar.Append(widget.NewToolbarSeparator())
 	m.toolBar.Append(widget.NewToolbarSpacer())
 	m.toolBar.Append(widget.NewToolbarSeparator())
+	m.toolBar.Append(m.actTunSettings)
 	m.toolBar.Append(m.actSettings)
 	m.toolBar.Append(m.actAbout)
 }
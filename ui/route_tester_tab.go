@@ -0,0 +1,152 @@
+package ui
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"xengate/internal/tunnel"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type RouteTesterTab struct {
+	window    fyne.Window
+	manager   *tunnel.Manager
+	container *fyne.Container
+	ipEntry   *widget.Entry
+	userEntry *widget.Entry
+	inbound   *widget.Select
+	target    *widget.Entry
+	table     *widget.Table
+	summary   *widget.Label
+	steps     []tunnel.TraceStep
+	inbounds  []string
+}
+
+// NewRouteTesterTab creates the tab; inbounds are the inbound names flows
+// of the configured listeners carry.
+func NewRouteTesterTab(window fyne.Window, manager *tunnel.Manager, inbounds []string) *RouteTesterTab {
+	tab := &RouteTesterTab{
+		window:   window,
+		manager:  manager,
+		inbounds: inbounds,
+	}
+	tab.initUI()
+	return tab
+}
+
+func (r *RouteTesterTab) initUI() {
+	r.ipEntry = widget.NewEntry()
+	r.ipEntry.SetPlaceHolder("192.168.1.10")
+
+	r.userEntry = widget.NewEntry()
+	r.userEntry.SetPlaceHolder("Optional")
+
+	r.inbound = widget.NewSelect(r.inbounds, nil)
+	if len(r.inbounds) > 0 {
+		r.inbound.SetSelected(r.inbounds[0])
+	}
+
+	r.target = widget.NewEntry()
+	r.target.SetPlaceHolder("example.com:443")
+
+	form := widget.NewForm(
+		widget.NewFormItem("Client IP", r.ipEntry),
+		widget.NewFormItem("Username", r.userEntry),
+		widget.NewFormItem("Inbound", r.inbound),
+		widget.NewFormItem("Target", r.target),
+	)
+
+	testButton := widget.NewButtonWithIcon("Test", theme.SearchIcon(), func() {
+		r.runTest()
+	})
+
+	r.summary = widget.NewLabel("")
+	r.summary.TextStyle = fyne.TextStyle{Bold: true}
+
+	r.table = widget.NewTable(
+		func() (int, int) {
+			return len(r.steps) + 1, 3 // +1 for header row
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+
+			if id.Row == 0 {
+				headers := []string{"Stage", "Result", "Detail"}
+				label.SetText(headers[id.Col])
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				return
+			}
+
+			label.TextStyle = fyne.TextStyle{}
+			step := r.steps[id.Row-1]
+			switch id.Col {
+			case 0:
+				label.SetText(step.Stage)
+			case 1:
+				label.SetText(step.Result)
+			case 2:
+				label.SetText(step.Detail)
+			}
+		},
+	)
+
+	r.table.SetColumnWidth(0, 120)
+	r.table.SetColumnWidth(1, 100)
+	r.table.SetColumnWidth(2, 420)
+
+	top := container.NewVBox(
+		form,
+		container.NewHBox(testButton, r.summary),
+	)
+
+	r.container = container.NewBorder(
+		top, nil, nil, nil,
+		container.NewPadded(r.table),
+	)
+}
+
+func (r *RouteTesterTab) runTest() {
+	clientIP := strings.TrimSpace(r.ipEntry.Text)
+	target := strings.TrimSpace(r.target.Text)
+
+	if net.ParseIP(clientIP) == nil {
+		dialog.ShowError(fmt.Errorf("Invalid client IP: %q", clientIP), r.window)
+		return
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		dialog.ShowError(fmt.Errorf("Target must be host:port"), r.window)
+		return
+	}
+
+	decision := r.manager.Explain(&tunnel.Metadata{
+		Inbound:  r.inbound.Selected,
+		ClientIP: clientIP,
+		User:     strings.TrimSpace(r.userEntry.Text),
+		Target:   target,
+	})
+
+	r.steps = decision.Steps
+	if decision.Allowed {
+		summary := fmt.Sprintf("Allowed via %s", decision.Action)
+		if decision.Pool != "" {
+			summary = fmt.Sprintf("Allowed via pool %s", decision.Pool)
+		}
+		r.summary.SetText(summary)
+	} else {
+		r.summary.SetText("Rejected")
+	}
+	r.table.Refresh()
+}
+
+func (r *RouteTesterTab) Container() fyne.CanvasObject {
+	return r.container
+}
@@ -4,6 +4,7 @@ package ui
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"xengate/internal/common"
@@ -225,7 +226,9 @@ func (r *RulesTab) showDetailsDialog(rule *models.AccessRule) {
 	details := container.NewVBox(
 		widget.NewLabel(fmt.Sprintf("Title: %s", rule.Title)),
 		widget.NewLabel(fmt.Sprintf("IP: %s", rule.IP)),
+		widget.NewLabel(fmt.Sprintf("Username: %s", rule.Username)),
 		widget.NewLabel(fmt.Sprintf("Daily Limit: %s", rule.DailyLimit)),
+		widget.NewLabel(fmt.Sprintf("Block Lists: %s", strings.Join(rule.BlockLists, ", "))),
 		widget.NewLabel(fmt.Sprintf("Description: %s", rule.Description)),
 		widget.NewLabel(fmt.Sprintf("Created: %s", rule.CreatedAt.Format("2006-01-02 15:04:05"))),
 		widget.NewLabel(fmt.Sprintf("Updated: %s", rule.UpdatedAt.Format("2006-01-02 15:04:05"))),
@@ -284,17 +287,23 @@ func (r *RulesTab) showDetailsDialog(rule *models.AccessRule) {
 func (r *RulesTab) showAddDialog() {
 	titleEntry := widget.NewEntry()
 	ipEntry := widget.NewEntry()
+	userEntry := widget.NewEntry()
+	userEntry.SetPlaceHolder("Optional proxy username")
 	isMasterCheck := widget.NewCheck("Master IP", nil)
 	limitEntry := widget.NewEntry()
 	limitEntry.SetText("1h")
+	listsEntry := widget.NewEntry()
+	listsEntry.SetPlaceHolder("Destination list names, comma-separated")
 	descEntry := widget.NewMultiLineEntry()
 
 	form := &widget.Form{
 		Items: []*widget.FormItem{
 			{Text: "Title", Widget: titleEntry},
 			{Text: "IP Address", Widget: ipEntry},
+			{Text: "Username", Widget: userEntry},
 			{Text: "Is Master", Widget: isMasterCheck},
 			{Text: "Daily Limit", Widget: limitEntry},
+			{Text: "Block Lists", Widget: listsEntry},
 			{Text: "Description", Widget: descEntry},
 		},
 		OnSubmit: func() {
@@ -307,9 +316,11 @@ func (r *RulesTab) showAddDialog() {
 			rule := &models.AccessRule{
 				Title:       titleEntry.Text,
 				IP:          ipEntry.Text,
+				Username:    strings.TrimSpace(userEntry.Text),
 				IsMaster:    isMasterCheck.Checked,
 				DailyLimit:  limit,
 				Description: descEntry.Text,
+				BlockLists:  splitNames(listsEntry.Text),
 			}
 
 			if err := r.accessControl.AddRule(rule); err != nil {
@@ -334,12 +345,18 @@ func (r *RulesTab) showEditDialog(rule *models.AccessRule) {
 	ipEntry := widget.NewEntry()
 	ipEntry.SetText(rule.IP)
 
+	userEntry := widget.NewEntry()
+	userEntry.SetText(rule.Username)
+
 	isMasterCheck := widget.NewCheck("Master IP", nil)
 	isMasterCheck.Checked = rule.IsMaster
 
 	limitEntry := widget.NewEntry()
 	limitEntry.SetText(rule.DailyLimit.String())
 
+	listsEntry := widget.NewEntry()
+	listsEntry.SetText(strings.Join(rule.BlockLists, ", "))
+
 	descEntry := widget.NewMultiLineEntry()
 	descEntry.SetText(rule.Description)
 
@@ -347,8 +364,10 @@ func (r *RulesTab) showEditDialog(rule *models.AccessRule) {
 		Items: []*widget.FormItem{
 			{Text: "Title", Widget: titleEntry},
 			{Text: "IP Address", Widget: ipEntry},
+			{Text: "Username", Widget: userEntry},
 			{Text: "Is Master", Widget: isMasterCheck},
 			{Text: "Daily Limit", Widget: limitEntry},
+			{Text: "Block Lists", Widget: listsEntry},
 			{Text: "Description", Widget: descEntry},
 		},
 		OnSubmit: func() {
@@ -362,9 +381,12 @@ func (r *RulesTab) showEditDialog(rule *models.AccessRule) {
 				ID:          rule.ID,
 				Title:       titleEntry.Text,
 				IP:          ipEntry.Text,
+				Username:    strings.TrimSpace(userEntry.Text),
 				IsMaster:    isMasterCheck.Checked,
 				DailyLimit:  limit,
 				Description: descEntry.Text,
+				DNSPolicy:   rule.DNSPolicy,
+				BlockLists:  splitNames(listsEntry.Text),
 			}
 
 			if err := r.accessControl.UpdateRule(updatedRule); err != nil {
@@ -413,3 +435,14 @@ func getStatusText(rule *models.AccessRule, status *tunnel.AccessStatus) string
 	}
 	return "Inactive"
 }
+
+// splitNames splits a comma-separated entry, dropping empty items.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
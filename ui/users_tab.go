@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"strconv"
+	"time"
+
+	"xengate/internal/tunnel"
+	"xengate/ui/util"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// UsersTab shows the connections and traffic of every authenticated user.
+type UsersTab struct {
+	manager   *tunnel.Manager
+	container *fyne.Container
+	table     *widget.Table
+	stats     []tunnel.UserStats
+}
+
+func NewUsersTab(manager *tunnel.Manager) *UsersTab {
+	tab := &UsersTab{manager: manager}
+	tab.initUI()
+	return tab
+}
+
+func (u *UsersTab) initUI() {
+	u.table = widget.NewTable(
+		func() (int, int) {
+			return len(u.stats) + 1, 5 // +1 for header row
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+
+			if id.Row == 0 {
+				headers := []string{"User", "Active", "Connections", "Traffic", "Last Seen"}
+				label.SetText(headers[id.Col])
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				return
+			}
+
+			dataRow := id.Row - 1
+			if dataRow >= len(u.stats) {
+				return
+			}
+			entry := u.stats[dataRow]
+			switch id.Col {
+			case 0:
+				label.SetText(entry.User)
+			case 1:
+				label.SetText(strconv.FormatInt(entry.Active, 10))
+			case 2:
+				label.SetText(strconv.FormatInt(entry.Connections, 10))
+			case 3:
+				label.SetText(util.BytesToSizeString(entry.TotalBytes))
+			case 4:
+				label.SetText(entry.LastSeen.Format("2006-01-02 15:04:05"))
+			}
+		},
+	)
+
+	u.table.SetColumnWidth(0, 180)
+	u.table.SetColumnWidth(1, 80)
+	u.table.SetColumnWidth(2, 110)
+	u.table.SetColumnWidth(3, 110)
+	u.table.SetColumnWidth(4, 180)
+
+	u.container = container.NewBorder(
+		widget.NewLabel("Traffic of clients that authenticated with a username or certificate"),
+		nil, nil, nil,
+		container.NewPadded(u.table),
+	)
+
+	u.refresh()
+	go u.periodicRefresh()
+}
+
+func (u *UsersTab) refresh() {
+	u.stats = u.manager.UserStats()
+	u.table.Refresh()
+}
+
+func (u *UsersTab) periodicRefresh() {
+	ticker := time.NewTicker(2 * time.Second)
+	for range ticker.C {
+		fyne.Do(u.refresh)
+	}
+}
+
+func (u *UsersTab) Container() fyne.CanvasObject {
+	return u.container
+}